@@ -0,0 +1,201 @@
+package main
+
+import (
+    "os"
+    "io"
+    "fmt"
+    "path"
+    "errors"
+    "strings"
+
+    "io/ioutil"
+
+    flags "github.com/jessevdk/go-flags"
+
+    "github.com/dsoprea/go-pathfingerprint/internal/pfinternal"
+)
+
+// Exit codes, like diff.
+const (
+    ExitValid = 0
+    ExitNotValid = 1
+    ExitFailure = 2
+)
+
+type makeOptions struct {
+    CatalogFilepath string  `short:"c" long:"catalog-filepath" description:"Catalog for the payload (will be created if it doesn't exist)" required:"true"`
+    HashAlgorithm string    `short:"h" long:"algorithm" default:"sha256" description:"Hashing algorithm (sha1, sha256)"`
+    BagPath string          `short:"o" long:"bag-path" default:"" description:"Bag to write the tag files to (defaults to the parent of the payload)"`
+    Info []string           `short:"i" long:"info" description:"Extra 'Label: value' line for bag-info.txt (can be given more than once)"`
+
+    Arguments struct {
+        ScanPath string     `positional-arg-name:"payload" description:"The bag's \"data\" directory"`
+    } `positional-args:"true" required:"true"`
+}
+
+type validateOptions struct {
+    CatalogFilepath string  `short:"c" long:"catalog-filepath" default:"" description:"Catalog for the payload (will be created if it doesn't exist; a temporary one is used if not given)"`
+    Quiet bool              `short:"q" long:"quiet" description:"Don't print the problems or the hash (just set the exit code)"`
+
+    Arguments struct {
+        BagPath string      `positional-arg-name:"bag" description:"Bag to check"`
+    } `positional-args:"true" required:"true"`
+}
+
+type options struct {
+    ShowDebugLogging bool   `short:"d" long:"debug-log" description:"Show debug logging"`
+
+    Make makeOptions        `command:"make" description:"Write the tag files for a bag"`
+    Validate validateOptions `command:"validate" description:"Check a bag"`
+}
+
+// Prints the problems as they're found.
+type problemPrinter struct {
+    w io.Writer
+}
+
+func (self *problemPrinter) Begin() error {
+    return nil
+}
+
+func (self *problemPrinter) Event(ce *pfinternal.ChangeEvent) error {
+    var problem string
+
+    switch ce.ChangeType {
+    case pfinternal.UpdateTypeCreate:
+        problem = "extra"
+
+    case pfinternal.UpdateTypeDelete:
+        problem = "missing"
+
+    case pfinternal.UpdateTypeUpdate:
+        problem = "mismatch"
+
+    default:
+        return errors.New(fmt.Sprintf("Change-type not valid: (%d)", ce.ChangeType))
+    }
+
+    _, err := fmt.Fprintf(self.w, "%s file %s\n", problem, ce.RelPath)
+    return err
+}
+
+func (self *problemPrinter) End() error {
+    return nil
+}
+
+func (self *problemPrinter) Error(err error) {
+}
+
+func readOptions () (*options, string) {
+    o := options {}
+
+    p := flags.NewParser(&o, flags.Default)
+
+    _, err := p.Parse()
+    if err != nil {
+        os.Exit(ExitFailure)
+    }
+
+    return &o, p.Active.Name
+}
+
+func makeBag(o *makeOptions) {
+    bagPath := o.BagPath
+    if bagPath == "" {
+        bagPath = path.Dir(path.Clean(o.Arguments.ScanPath))
+    }
+
+    extraTags := make([]*pfinternal.BagItTag, len(o.Info))
+    for i, line := range o.Info {
+        parts := strings.SplitN(line, ":", 2)
+        if len(parts) != 2 {
+            panic(errors.New(fmt.Sprintf("Info not valid (expected 'Label: value'): [%s]", line)))
+        }
+
+        extraTags[i] = &pfinternal.BagItTag {
+                Label: strings.TrimSpace(parts[0]),
+                Value: strings.TrimSpace(parts[1]),
+        }
+    }
+
+    rootHash, err := pfinternal.MakeBag(&o.Arguments.ScanPath, &bagPath, &o.CatalogFilepath, &o.HashAlgorithm, extraTags)
+    if err != nil {
+        panic(err)
+    }
+
+    fmt.Printf("%s\n", rootHash)
+}
+
+func validateBag(o *validateOptions) int {
+    catalogFilepath := o.CatalogFilepath
+    if catalogFilepath == "" {
+        f, err := ioutil.TempFile("", "pfbag_")
+        if err != nil {
+            panic(err)
+        }
+
+        f.Close()
+
+        catalogFilepath = f.Name()
+        defer os.Remove(catalogFilepath)
+    }
+
+    var w io.Writer = os.Stdout
+    if o.Quiet == true {
+        w = ioutil.Discard
+    }
+
+    pp := &problemPrinter {
+            w: w,
+    }
+
+    bv, err := pfinternal.ValidateBag(&o.Arguments.BagPath, &catalogFilepath, pp)
+    if err != nil {
+        panic(err)
+    }
+
+    count := bv.Count
+
+    if bv.ExpectedPayloadOxum != "" && bv.ExpectedPayloadOxum != bv.PayloadOxum {
+        fmt.Fprintf(w, "mismatch oxum %s (expected %s)\n", bv.PayloadOxum, bv.ExpectedPayloadOxum)
+        count++
+    }
+
+    fmt.Fprintf(w, "%s\n", bv.RootHash)
+
+    if count > 0 {
+        return ExitNotValid
+    }
+
+    return ExitValid
+}
+
+func main() {
+    exitCode := ExitValid
+
+    // This runs after everything else has been cleaned-up.
+    defer func() {
+        if r := recover(); r != nil {
+            err := r.(error)
+
+            fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+            os.Exit(ExitFailure)
+        } else if exitCode != ExitValid {
+            os.Exit(exitCode)
+        }
+    }()
+
+    o, command := readOptions()
+
+    if o.ShowDebugLogging == true {
+        pfinternal.SetDebugLogging()
+    }
+
+    pfinternal.ConfigureRootLogger()
+
+    if command == "make" {
+        makeBag(&o.Make)
+    } else {
+        exitCode = validateBag(&o.Validate)
+    }
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+    "os"
+    "fmt"
+
+    "io/ioutil"
+
+    flags "github.com/jessevdk/go-flags"
+
+    "github.com/dsoprea/go-pathfingerprint/internal/pfinternal"
+)
+
+// Exit codes, like diff.
+const (
+    ExitSame = 0
+    ExitDifferent = 1
+    ExitFailure = 2
+)
+
+type options struct {
+    HashAlgorithm string    `short:"h" long:"algorithm" default:"sha1" description:"Hashing algorithm (sha1, sha256)"`
+    ShowDebugLogging bool   `short:"d" long:"debug-log" description:"Show debug logging"`
+    CatalogFilepathA string `short:"a" long:"catalog-a" default:"" description:"Catalog for the first path (will be created if it doesn't exist; a temporary one is used if not given)"`
+    CatalogFilepathB string `short:"b" long:"catalog-b" default:"" description:"Catalog for the second path (will be created if it doesn't exist; a temporary one is used if not given)"`
+    Quiet bool              `short:"q" long:"quiet" description:"Don't print the differences (just set the exit code)"`
+
+    Paths struct {
+        ScanPathA string    `positional-arg-name:"path-a" description:"The original path"`
+        ScanPathB string    `positional-arg-name:"path-b" description:"The path to compare it to"`
+    } `positional-args:"true" required:"true"`
+}
+
+func readOptions () *options {
+    o := options {}
+
+    _, err := flags.Parse(&o)
+    if err != nil {
+        os.Exit(ExitFailure)
+    }
+
+    return &o
+}
+
+// Return the given catalog file-path or, if empty, the file-path of a new
+// temporary catalog (and that it needs to be removed).
+func getCatalogFilepath(catalogFilepath string) (string, bool) {
+    if catalogFilepath != "" {
+        return catalogFilepath, false
+    }
+
+    f, err := ioutil.TempFile("", "pfcompare_")
+    if err != nil {
+        panic(err)
+    }
+
+    defer f.Close()
+
+    return f.Name(), true
+}
+
+func main() {
+    exitCode := ExitSame
+
+    // This runs after everything else has been cleaned-up.
+    defer func() {
+        if r := recover(); r != nil {
+            err := r.(error)
+
+            fmt.Printf("ERROR: %s\n", err.Error())
+            os.Exit(ExitFailure)
+        } else if exitCode != ExitSame {
+            os.Exit(exitCode)
+        }
+    }()
+
+    o := readOptions()
+
+    if o.ShowDebugLogging == true {
+        pfinternal.SetDebugLogging()
+    }
+
+    pfinternal.ConfigureRootLogger()
+
+    catalogFilepathA, isTemporaryA := getCatalogFilepath(o.CatalogFilepathA)
+    if isTemporaryA == true {
+        defer os.Remove(catalogFilepathA)
+    }
+
+    catalogFilepathB, isTemporaryB := getCatalogFilepath(o.CatalogFilepathB)
+    if isTemporaryB == true {
+        defer os.Remove(catalogFilepathB)
+    }
+
+    var cs pfinternal.ChangeSink
+    if o.Quiet == false {
+        cs = pfinternal.NewReportChangeSink(os.Stdout, true)
+    } else {
+        cs = pfinternal.NewFanoutChangeSink()
+    }
+
+    err := cs.Begin()
+    if err != nil {
+        panic(err)
+    }
+
+    _, _, count, err := pfinternal.CompareTrees(&o.Paths.ScanPathA, &catalogFilepathA, &o.Paths.ScanPathB, &catalogFilepathB, &o.HashAlgorithm, cs)
+    if err != nil {
+        cs.Error(err)
+        panic(err)
+    }
+
+    err = cs.End()
+    if err != nil {
+        panic(err)
+    }
+
+    if count > 0 {
+        exitCode = ExitDifferent
+    }
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "errors"
+
+    flags "github.com/jessevdk/go-flags"
+
+    "github.com/dsoprea/go-pathfingerprint/internal/pfinternal"
+)
+
+// Exit codes, like diff.
+const (
+    ExitSame = 0
+    ExitDifferent = 1
+    ExitFailure = 2
+)
+
+type options struct {
+    HashAlgorithm string    `short:"h" long:"algorithm" default:"sha1" description:"Hashing algorithm (sha1, sha256)"`
+    ShowDebugLogging bool   `short:"d" long:"debug-log" description:"Show debug logging"`
+    RelPath string          `short:"r" long:"rel-path" default:"" description:"Only compare this subdirectory"`
+    FromScan string         `long:"from-scan" default:"" description:"Compare two recorded scans of one catalog: the older scan (a scan ID or a time)"`
+    ToScan string           `long:"to-scan" default:"" description:"The newer scan (a scan ID or a time)"`
+
+    Catalogs struct {
+        FromFilepath string `positional-arg-name:"from-catalog" required:"yes" description:"The older catalog (or the only one, when comparing scans)"`
+        ToFilepath string   `positional-arg-name:"to-catalog" description:"The newer catalog"`
+    } `positional-args:"true"`
+}
+
+func readOptions () *options {
+    o := options {}
+
+    _, err := flags.Parse(&o)
+    if err != nil {
+        os.Exit(ExitFailure)
+    }
+
+    return &o
+}
+
+// Opening a catalog that doesn't exist would create an empty one.
+func checkCatalogExists(catalogFilepath *string) {
+    _, err := os.Stat(*catalogFilepath)
+    if err != nil {
+        panic(err)
+    }
+}
+
+// Diff two recorded scans of a single catalog. Returns the number of changes.
+func diffScans(o *options) int {
+    if o.FromScan == "" || o.ToScan == "" {
+        panic(errors.New("Both --from-scan and --to-scan are required."))
+    } else if o.Catalogs.ToFilepath != "" {
+        panic(errors.New("Only one catalog can be given when comparing scans."))
+    }
+
+    checkCatalogExists(&o.Catalogs.FromFilepath)
+
+    cr, err := pfinternal.NewCatalogResource(&o.Catalogs.FromFilepath, &o.HashAlgorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    err = cr.OpenReadOnly()
+    if err != nil {
+        panic(err)
+    }
+
+    defer cr.Close()
+
+    fromScan, err := cr.FindScan(o.FromScan)
+    if err != nil {
+        panic(err)
+    }
+
+    toScan, err := cr.FindScan(o.ToScan)
+    if err != nil {
+        panic(err)
+    }
+
+    rcs := pfinternal.NewReportChangeSink(os.Stdout, true)
+
+    err = rcs.Begin()
+    if err != nil {
+        panic(err)
+    }
+
+    count, err := cr.DiffScans(fromScan.ScanId, toScan.ScanId, &o.RelPath, rcs)
+    if err != nil {
+        rcs.Error(err)
+        panic(err)
+    }
+
+    err = rcs.End()
+    if err != nil {
+        panic(err)
+    }
+
+    return count
+}
+
+func main() {
+    defer func() {
+        if r := recover(); r != nil {
+            err := r.(error)
+
+            fmt.Printf("ERROR: %s\n", err.Error())
+            os.Exit(ExitFailure)
+        }
+    }()
+
+    o := readOptions()
+
+    if o.ShowDebugLogging == true {
+        pfinternal.SetDebugLogging()
+    }
+
+    pfinternal.ConfigureRootLogger()
+
+    if o.FromScan != "" || o.ToScan != "" {
+        count := diffScans(o)
+        if count > 0 {
+            os.Exit(ExitDifferent)
+        }
+
+        return
+    } else if o.Catalogs.ToFilepath == "" {
+        panic(errors.New("Two catalogs are required (unless comparing scans)."))
+    }
+
+    checkCatalogExists(&o.Catalogs.FromFilepath)
+    checkCatalogExists(&o.Catalogs.ToFilepath)
+
+    from, err := pfinternal.NewCatalogResource(&o.Catalogs.FromFilepath, &o.HashAlgorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    err = from.OpenReadOnly()
+    if err != nil {
+        panic(err)
+    }
+
+    defer from.Close()
+
+    to, err := pfinternal.NewCatalogResource(&o.Catalogs.ToFilepath, &o.HashAlgorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    err = to.OpenReadOnly()
+    if err != nil {
+        panic(err)
+    }
+
+    defer to.Close()
+
+    rcs := pfinternal.NewReportChangeSink(os.Stdout, true)
+
+    err = rcs.Begin()
+    if err != nil {
+        panic(err)
+    }
+
+    count, err := pfinternal.DiffCatalogs(from, to, &o.RelPath, rcs)
+    if err != nil {
+        rcs.Error(err)
+        panic(err)
+    }
+
+    err = rcs.End()
+    if err != nil {
+        panic(err)
+    }
+
+    if count > 0 {
+        from.Close()
+        to.Close()
+
+        os.Exit(ExitDifferent)
+    }
+}
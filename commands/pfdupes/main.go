@@ -0,0 +1,133 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "errors"
+
+    "encoding/json"
+
+    flags "github.com/jessevdk/go-flags"
+
+    "github.com/dsoprea/go-pathfingerprint/internal/pfinternal"
+)
+
+const (
+    FormatText = "text"
+    FormatJson = "json"
+)
+
+type options struct {
+    CatalogFilepath string  `short:"c" long:"catalog-filepath" description:"Catalog file-path" required:"true"`
+    HashAlgorithm string    `short:"h" long:"algorithm" default:"sha1" description:"Hashing algorithm (sha1, sha256)"`
+    ShowDebugLogging bool   `short:"d" long:"debug-log" description:"Show debug logging"`
+    RelPath string          `short:"r" long:"rel-path" default:"" description:"Only look below this subdirectory"`
+    MinSize int64           `short:"m" long:"min-size" default:"1" description:"Ignore files and directories smaller than this many bytes"`
+    NoFiles bool            `long:"no-files" description:"Only report duplicate directories"`
+    Format string           `short:"F" long:"format" default:"text" choice:"text" choice:"json" description:"Output format (text, json)"`
+}
+
+func readOptions () *options {
+    o := options {}
+
+    _, err := flags.Parse(&o)
+    if err != nil {
+        os.Exit(1)
+    }
+
+    return &o
+}
+
+func printGroups(groups []*pfinternal.DuplicateGroup) {
+    for _, dg := range groups {
+        var size string
+        if dg.Size == nil {
+            size = "?"
+        } else {
+            size = fmt.Sprintf("%d", *dg.Size)
+        }
+
+        fmt.Printf("%s  size=%s copies=%d wasted=%d\n", dg.Hash, size, len(dg.RelPaths), dg.WastedBytes)
+
+        for _, relPath := range dg.RelPaths {
+            if dg.IsPath == true {
+                relPath += "/"
+            }
+
+            fmt.Printf("  %s\n", relPath)
+        }
+
+        fmt.Printf("\n")
+    }
+}
+
+func main() {
+    defer func() {
+        if r := recover(); r != nil {
+            err := r.(error)
+
+            fmt.Printf("ERROR: %s\n", err.Error())
+            os.Exit(1)
+        }
+    }()
+
+    o := readOptions()
+
+    if o.ShowDebugLogging == true {
+        pfinternal.SetDebugLogging()
+    }
+
+    pfinternal.ConfigureRootLogger()
+
+    if o.MinSize < 0 {
+        panic(errors.New("Minimum size can not be negative."))
+    }
+
+    cr, err := pfinternal.NewCatalogResource(&o.CatalogFilepath, &o.HashAlgorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    err = cr.OpenReadOnly()
+    if err != nil {
+        panic(err)
+    }
+
+    defer cr.Close()
+
+    dr, err := cr.FindDuplicates(&o.RelPath, o.MinSize)
+    if err != nil {
+        panic(err)
+    }
+
+    if o.NoFiles == true {
+        for _, dg := range dr.Files {
+            dr.WastedBytes -= dg.WastedBytes
+        }
+
+        dr.Files = make([]*pfinternal.DuplicateGroup, 0)
+    }
+
+    if o.Format == FormatJson {
+        encoded, err := json.MarshalIndent(dr, "", "  ")
+        if err != nil {
+            panic(err)
+        }
+
+        fmt.Println(string(encoded))
+
+        return
+    }
+
+    if len(dr.Paths) > 0 {
+        fmt.Printf("Duplicate directories:\n\n")
+        printGroups(dr.Paths)
+    }
+
+    if len(dr.Files) > 0 {
+        fmt.Printf("Duplicate files:\n\n")
+        printGroups(dr.Files)
+    }
+
+    fmt.Printf("Wasted: (%d) bytes\n", dr.WastedBytes)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+    "os"
+    "io"
+    "fmt"
+    "bufio"
+
+    flags "github.com/jessevdk/go-flags"
+
+    "github.com/dsoprea/go-pathfingerprint/internal/pfinternal"
+)
+
+type options struct {
+    CatalogFilepath string  `short:"c" long:"catalog-filepath" description:"Catalog file-path" required:"true"`
+    HashAlgorithm string    `short:"h" long:"algorithm" default:"sha1" description:"Hashing algorithm (sha1, sha256)"`
+    ShowDebugLogging bool   `short:"d" long:"debug-log" description:"Show debug logging"`
+    RelPath string          `short:"r" long:"rel-path" default:"" description:"Only export this subdirectory (paths will be relative to it)"`
+    Format string           `short:"F" long:"format" default:"gnu" choice:"gnu" choice:"bsd" choice:"csv" choice:"json" description:"Output format (gnu, bsd, csv, json)"`
+    OutputFilepath string   `short:"o" long:"output" default:"-" description:"File to write to ('-' for STDOUT)"`
+}
+
+func readOptions () *options {
+    o := options {}
+
+    _, err := flags.Parse(&o)
+    if err != nil {
+        os.Exit(1)
+    }
+
+    return &o
+}
+
+func main() {
+    defer func() {
+        if r := recover(); r != nil {
+            err := r.(error)
+
+            fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+            os.Exit(1)
+        }
+    }()
+
+    o := readOptions()
+
+    if o.ShowDebugLogging == true {
+        pfinternal.SetDebugLogging()
+    }
+
+    pfinternal.ConfigureRootLogger()
+
+    // Opening a catalog that doesn't exist would create an empty one.
+    _, err := os.Stat(o.CatalogFilepath)
+    if err != nil {
+        panic(err)
+    }
+
+    cr, err := pfinternal.NewCatalogResource(&o.CatalogFilepath, &o.HashAlgorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    err = cr.OpenReadOnly()
+    if err != nil {
+        panic(err)
+    }
+
+    defer cr.Close()
+
+    var w io.Writer = os.Stdout
+    if o.OutputFilepath != "-" {
+        f, err := os.Create(o.OutputFilepath)
+        if err != nil {
+            panic(err)
+        }
+
+        defer f.Close()
+
+        w = f
+    }
+
+    bw := bufio.NewWriter(w)
+
+    mw, err := pfinternal.NewManifestWriter(bw, o.Format)
+    if err != nil {
+        panic(err)
+    }
+
+    err = cr.ExportManifest(&o.RelPath, mw)
+    if err != nil {
+        panic(err)
+    }
+
+    err = bw.Flush()
+    if err != nil {
+        panic(err)
+    }
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "errors"
+
+    flags "github.com/jessevdk/go-flags"
+
+    "github.com/dsoprea/go-pathfingerprint/internal/pfinternal"
+)
+
+type options struct {
+    CatalogFilepath string  `short:"c" long:"catalog-filepath" description:"Catalog file-path" required:"true"`
+    HashAlgorithm string    `short:"h" long:"algorithm" default:"sha1" description:"Hashing algorithm (sha1, sha256)"`
+    ShowDebugLogging bool   `short:"d" long:"debug-log" description:"Show debug logging"`
+    RelPath string          `short:"r" long:"rel-path" description:"Subdirectory to extract" required:"true"`
+
+    Arguments struct {
+        OutputCatalogFilepath string `positional-arg-name:"output-catalog" description:"Catalog to create (must not exist)"`
+    } `positional-args:"true" required:"true"`
+}
+
+func readOptions () *options {
+    o := options {}
+
+    _, err := flags.Parse(&o)
+    if err != nil {
+        os.Exit(1)
+    }
+
+    return &o
+}
+
+func main() {
+    defer func() {
+        if r := recover(); r != nil {
+            err := r.(error)
+
+            fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+            os.Exit(1)
+        }
+    }()
+
+    o := readOptions()
+
+    if o.ShowDebugLogging == true {
+        pfinternal.SetDebugLogging()
+    }
+
+    pfinternal.ConfigureRootLogger()
+
+    // Opening a catalog that doesn't exist would create an empty one.
+    _, err := os.Stat(o.CatalogFilepath)
+    if err != nil {
+        panic(err)
+    }
+
+    if _, err := os.Stat(o.Arguments.OutputCatalogFilepath); err == nil {
+        panic(errors.New(fmt.Sprintf("Output catalog already exists: [%s]", o.Arguments.OutputCatalogFilepath)))
+    }
+
+    source, err := pfinternal.NewCatalogResource(&o.CatalogFilepath, &o.HashAlgorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    err = source.OpenReadOnly()
+    if err != nil {
+        panic(err)
+    }
+
+    defer source.Close()
+
+    target, err := pfinternal.NewCatalogResource(&o.Arguments.OutputCatalogFilepath, &o.HashAlgorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    err = target.Open()
+    if err != nil {
+        panic(err)
+    }
+
+    wasExtracted := false
+
+    defer func() {
+        target.Close()
+
+        // Don't leave an empty catalog behind.
+        if wasExtracted == false {
+            os.Remove(o.Arguments.OutputCatalogFilepath)
+        }
+    }()
+
+    pathCount, fileCount, err := source.ExtractSubtree(&o.RelPath, target)
+    if err != nil {
+        panic(err)
+    }
+
+    wasExtracted = true
+
+    rootRelPath := ""
+    rr, err := target.ResolvePath(&rootRelPath)
+    if err != nil {
+        panic(err)
+    }
+
+    fmt.Printf("Extracted (%d) paths and (%d) files.\n", pathCount, fileCount)
+    fmt.Printf("%s\n", rr.Hash)
+}
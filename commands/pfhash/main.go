@@ -3,6 +3,8 @@ package main
 import (
     "os"
     "fmt"
+    "time"
+    "errors"
     "runtime/pprof"
 //    "runtime"
     
@@ -15,31 +17,303 @@ const (
     PathCreationMode = 0755
 )
 
+// Exit codes used when we're asked to indicate whether anything changed.
+const (
+    ExitNoChanges = 0
+    ExitChanges = 1
+    ExitPartialFailure = 2
+    ExitFatal = 3
+)
+
+// Exit codes for the "fsck" command. These follow fsck(8).
+const (
+    ExitFsckClean = 0
+    ExitFsckRepaired = 1
+    ExitFsckUnrepaired = 4
+    ExitFsckFatal = 8
+)
+
+type fsckOptions struct {
+    Repair bool             `long:"repair" description:"Repair the problems that can be repaired"`
+}
+
+type gcOptions struct {
+    KeepLast int            `long:"keep-last" default:"0" description:"Keep this many of the most recent scans"`
+    KeepDaily int           `long:"keep-daily" default:"0" description:"Keep the last scan of each of this many of the most recent days"`
+    KeepWeekly int          `long:"keep-weekly" default:"0" description:"Keep the last scan of each of this many of the most recent weeks"`
+    DryRun bool             `long:"dry-run" description:"Only print the scans that would be removed"`
+}
+
 type options struct {
-    ScanPath string         `short:"s" long:"scan-path" description:"Path to scan" required:"true"`
+    ScanPath string         `short:"s" long:"scan-path" description:"Path to scan (required unless a command is given)"`
     CatalogFilepath string  `short:"c" long:"catalog-filepath" description:"Catalog file-path (will be created if it doesn't exist)" required:"true"`
     HashAlgorithm string    `short:"h" long:"algorithm" default:"sha1" description:"Hashing algorithm (sha1, sha256)"`
-    NoUpdates bool          `short:"n" long:"no-updates" description:"Don't update the catalog (will also prevent reporting of deletions)"`
+    NoUpdates bool          `short:"n" long:"no-updates" description:"Don't update the catalog"`
     ReportFilename string   `short:"R" long:"report" default:"" description:"Write a report of changed files ('-' for STDERR)"`
+    SortReport bool         `short:"S" long:"sort-report" description:"Buffer the report and write it sorted by path"`
+    WebhookUrl string       `short:"w" long:"webhook-url" default:"" description:"POST a JSON summary of the changes to this URL (only if something changed)"`
+    WebhookSecret string    `long:"webhook-secret" default:"" env:"PFHASH_WEBHOOK_SECRET" description:"Sign webhook bodies with HMAC-SHA256 using this secret"`
+    WebhookTimeout int      `long:"webhook-timeout" default:"10" description:"Webhook timeout in seconds"`
+    WebhookRetries int      `long:"webhook-retries" default:"3" description:"Number of times to retry a failed webhook"`
     ProfileFilename string  `short:"P" long:"profile" default:"" description:"Write performance profiling information"`
     ShowDebugLogging bool   `short:"d" long:"debug-log" description:"Show debug logging"`
+    ExitCode bool           `short:"x" long:"exit-code" description:"Exit with (0) if nothing changed, (1) if something changed, (2) on partial failure, and (3) on fatal error"`
+    History string          `long:"history" choice:"on" choice:"off" description:"Turn the recording of scans in the catalog on or off (remembered by the catalog)"`
+
+    Gc gcOptions            `command:"gc" description:"Remove the recorded scans that the retention rules don't keep, and compact the catalog"`
+    Rebuild struct{}        `command:"rebuild" description:"Recalculate every directory hash from the catalog alone, without reading the scan-path"`
+    Fsck fsckOptions        `command:"fsck" description:"Check the catalog for corruption and inconsistencies (exits with (0) if clean, (1) if everything was repaired, (4) if problems remain, and (8) on error)"`
 }
 
-func readOptions () *options {
+// Counts the changes so that we can tell whether anything changed.
+type changeCounter struct {
+    count int
+}
+
+func (self *changeCounter) Begin() error {
+    return nil
+}
+
+func (self *changeCounter) Event(ce *pfinternal.ChangeEvent) error {
+    self.count++
+    return nil
+}
+
+func (self *changeCounter) End() error {
+    return nil
+}
+
+func (self *changeCounter) Error(err error) {
+}
+
+// Parse the options. Returns the command that was given, if any.
+func readOptions () (*options, string, error) {
     o := options {}
 
-    _, err := flags.Parse(&o)
+    p := flags.NewParser(&o, flags.Default)
+    p.SubcommandsOptional = true
+
+    _, err := p.Parse()
+
+    command := ""
+    if p.Active != nil {
+        command = p.Active.Name
+    }
+
     if err != nil {
+        return &o, command, err
+    }
+
+    if command == "" && o.ScanPath == "" {
+        fmt.Fprintf(os.Stderr, "the required flag `-s, --scan-path' was not specified\n")
+        return &o, command, errors.New("Scan-path not given.")
+    }
+
+    return &o, command, nil
+}
+
+// The exit code to use if we fail. This has to be decided even if the options
+// can't be parsed, so the arguments are parsed again here on their own, 
+// skipping unknown flags rather than stopping at them, so that the command 
+// and -x still count if they come after the problem.
+func fatalExitCodeFor(args []string) int {
+    o := options {}
+
+    p := flags.NewParser(&o, flags.None)
+    p.SubcommandsOptional = true
+
+    p.UnknownOptionHandler = func(option string, arg flags.SplitArgument, args []string) ([]string, error) {
+        return args, nil
+    }
+
+    // Whatever was parsed is what we go on, whether or not it failed.
+    p.ParseArgs(args)
+
+    if p.Active != nil && p.Active.Name == "fsck" {
+        return ExitFsckFatal
+    } else if o.ExitCode == true {
+        return ExitFatal
+    }
+
+    return 1
+}
+
+// The exit code for a scan that produced a hash.
+func scanExitCode(exitCodeRequested bool, partialFailure bool, changeCount int, lastHash *string, hash string) int {
+    if exitCodeRequested == false {
+        if partialFailure == true {
+            return 1
+        }
+
+        return 0
+    }
+
+    if partialFailure == true {
+        return ExitPartialFailure
+    } else if changeCount > 0 || lastHash == nil || *lastHash != hash {
+        return ExitChanges
+    }
+
+    return ExitNoChanges
+}
+
+// Apply the retention rules to the recorded scans and compact the catalog.
+func collectGarbage(catalogFilepath string, hashAlgorithm string, o *gcOptions) {
+    // Opening a catalog that doesn't exist would create an empty one.
+    _, err := os.Stat(catalogFilepath)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
         panic(err)
     }
 
-    return &o
+    cr, err := pfinternal.NewCatalogResource(&catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    err = cr.Open()
+    if err != nil {
+        panic(err)
+    }
+
+    defer cr.Close()
+
+    policy := &pfinternal.RetentionPolicy {
+                KeepLast: o.KeepLast,
+                KeepDaily: o.KeepDaily,
+                KeepWeekly: o.KeepWeekly,
+    }
+
+    removed, err := cr.ApplyRetention(policy, o.DryRun)
+    if err != nil {
+        panic(err)
+    }
+
+    if o.DryRun == true {
+        for _, sr := range removed {
+            fmt.Printf("Would remove scan (%d) from [%s].\n", sr.ScanId, time.Unix(sr.StartEpoch, 0).Format(time.RFC3339))
+        }
+
+        return
+    }
+
+    fmt.Printf("Removed (%d) scans.\n", len(removed))
+
+    result, err := cr.Compact()
+    if err != nil {
+        panic(err)
+    }
+
+    fmt.Printf("Reclaimed (%d) bytes: (%d) -> (%d)\n", result.Reclaimed(), result.SizeBefore, result.SizeAfter)
+}
+
+// Check the catalog and print what's wrong with it. Returns the exit code.
+func checkCatalog(catalogFilepath string, hashAlgorithm string, o *fsckOptions) int {
+    _, err := os.Stat(catalogFilepath)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+        panic(err)
+    }
+
+    cr, err := pfinternal.NewCatalogResource(&catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    // Only a repair writes to the catalog (and upgrades its schema).
+    if o.Repair == true {
+        err = cr.Open()
+    } else {
+        err = cr.OpenReadOnly()
+    }
+
+    if err != nil {
+        panic(err)
+    }
+
+    defer cr.Close()
+
+    problems, err := cr.CheckCatalog(o.Repair)
+    if err != nil {
+        panic(err)
+    }
+
+    repaired := 0
+    for _, fp := range problems {
+        if fp.WasRepaired == true {
+            fmt.Printf("%s (repaired)\n", fp)
+            repaired++
+        } else {
+            fmt.Printf("%s\n", fp)
+        }
+    }
+
+    if len(problems) == 0 {
+        fmt.Printf("No problems found.\n")
+        return ExitFsckClean
+    }
+
+    fmt.Printf("Found (%d) problems. Repaired (%d).\n", len(problems), repaired)
+
+    if repaired == len(problems) {
+        return ExitFsckRepaired
+    }
+
+    return ExitFsckUnrepaired
+}
+
+// Recalculate the path hashes from what's recorded in the catalog and print
+// the new root hash.
+func rebuildCatalog(catalogFilepath string, hashAlgorithm string) {
+    _, err := os.Stat(catalogFilepath)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+        panic(err)
+    }
+
+    cr, err := pfinternal.NewCatalogResource(&catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    err = cr.Open()
+    if err != nil {
+        panic(err)
+    }
+
+    defer cr.Close()
+
+    updated, kept, err := cr.RebuildPathHashes()
+    if err != nil {
+        panic(err)
+    }
+
+    fmt.Fprintf(os.Stderr, "Updated (%d) path hashes.\n", updated)
+
+    if kept > 0 {
+        fmt.Fprintf(os.Stderr, "Kept (%d) path hashes that have symlinks or haven't been scanned since upgrading.\n", kept)
+    }
+
+    rootRelPath := ""
+    rr, err := cr.ResolvePath(&rootRelPath)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+        panic(err)
+    }
+
+    fmt.Println(rr.Hash)
 }
 
 func main() {
+    fatalExitCode := 1
+    exitCode := 0
+
+    // This runs after everything else has been closed.
     defer func() {
         if r := recover(); r != nil {
-            os.Exit(1)
+            os.Exit(fatalExitCode)
+        } else if exitCode != 0 {
+            os.Exit(exitCode)
         }
     }()
 
@@ -50,7 +324,18 @@ func main() {
     var reportFilename string
     var profileFilename string
 
-    o := readOptions()
+    o, command, err := readOptions()
+
+    fatalExitCode = fatalExitCodeFor(os.Args[1:])
+
+    if err != nil {
+        // The help was asked for and has already been printed.
+        if flagsErr, ok := err.(*flags.Error); ok == true && flagsErr.Type == flags.ErrHelp {
+            return
+        }
+
+        panic(err)
+    }
 
     scanPath = o.ScanPath
     catalogFilepath = o.CatalogFilepath
@@ -59,10 +344,8 @@ func main() {
     reportFilename = o.ReportFilename
     profileFilename = o.ProfileFilename
 
-    var reportingDataChannel chan *pfinternal.ChangeEvent = nil
-    var reportingQuitChannel chan bool = nil
+    var cs pfinternal.ChangeSink = nil
     var c *pfinternal.Catalog
-    var err error
 
     if o.ShowDebugLogging == true {
         pfinternal.SetDebugLogging()
@@ -71,6 +354,21 @@ func main() {
     l := pfinternal.NewLogger("pfhash")
     pfinternal.ConfigureRootLogger()
 
+    if command == "fsck" {
+        exitCode = checkCatalog(catalogFilepath, hashAlgorithm, &o.Fsck)
+        return
+    }
+
+    if command == "rebuild" {
+        rebuildCatalog(catalogFilepath, hashAlgorithm)
+        return
+    }
+
+    if command == "gc" {
+        collectGarbage(catalogFilepath, hashAlgorithm, &o.Gc)
+        return
+    }
+
     if profileFilename != "" {
         l.Debug("Profiling enabled.")
 
@@ -84,15 +382,6 @@ func main() {
         defer pprof.StopCPUProfile()
     }
 
-    if reportFilename != "" {
-        reportingDataChannel = make(chan *pfinternal.ChangeEvent, 1000)
-        reportingQuitChannel = make(chan bool)
-
-        go recordChanges(reportFilename, reportingDataChannel, reportingQuitChannel)
-    }
-
-    p := pfinternal.NewPath(&hashAlgorithm, reportingDataChannel)
-
     cr, err := pfinternal.NewCatalogResource(&catalogFilepath, &hashAlgorithm)
     if err != nil {
         panic(err)
@@ -105,14 +394,90 @@ func main() {
 
     defer cr.Close()
 
-    c, err = pfinternal.NewCatalog(cr, &scanPath, allowUpdates, &hashAlgorithm, reportingDataChannel)
+    // Turning the history on records a baseline, so the setting is only
+    // changed if we're allowed to update the catalog.
+    if o.History != "" && allowUpdates == false {
+        fmt.Fprintf(os.Stderr, "Not changing the history setting in no-updates mode.\n")
+    } else if o.History != "" {
+        err = cr.SetHistoryEnabled(o.History == "on")
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    sinks := make([]pfinternal.ChangeSink, 0)
+
+    isHistoryEnabled, err := cr.HistoryEnabled()
     if err != nil {
         panic(err)
     }
 
+    // Nothing changes in the catalog if we're not allowed to update it, so 
+    // there's nothing to record.
+    var hcs *pfinternal.HistoryChangeSink
+    if isHistoryEnabled == true && allowUpdates == true {
+        hcs = cr.NewHistoryChangeSink()
+        sinks = append(sinks, hcs)
+    }
+
+    if reportFilename != "" {
+        var f *os.File
+        if reportFilename == "-" {
+            f = os.Stderr
+        } else {
+            f, err = os.Create(reportFilename)
+            if err != nil {
+                panic(err)
+            }
+
+            defer f.Close()
+        }
+
+        rcs := pfinternal.NewReportChangeSink(f, o.SortReport)
+        sinks = append(sinks, rcs)
+    }
+
+    var wcs *pfinternal.WebhookChangeSink
+    if o.WebhookUrl != "" {
+        timeout := time.Second * time.Duration(o.WebhookTimeout)
+        wcs = pfinternal.NewWebhookChangeSink(o.WebhookUrl, o.WebhookSecret, timeout, o.WebhookRetries)
+        sinks = append(sinks, wcs)
+    }
+
+    var counter *changeCounter
+    if o.ExitCode == true {
+        counter = new(changeCounter)
+        sinks = append(sinks, counter)
+    }
+
+    if len(sinks) > 0 {
+        cs = pfinternal.NewFanoutChangeSink(sinks...)
+
+        err = cs.Begin()
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    // Let the sinks know if we don't make it to the end.
+    fail := func(err error) {
+        if cs != nil {
+            cs.Error(err)
+        }
+
+        panic(err)
+    }
+
+    p := pfinternal.NewPath(&hashAlgorithm, cs)
+
+    c, err = pfinternal.NewCatalog(cr, &scanPath, allowUpdates, &hashAlgorithm, cs)
+    if err != nil {
+        fail(err)
+    }
+
     err = c.Open()
     if err != nil {
-        panic(err)
+        fail(err)
     }
 
     defer c.Close()
@@ -122,59 +487,53 @@ func main() {
     relPath := ""
     hash, err := p.GeneratePathHash(&scanPath, &relPath, c)
     if err != nil {
-        panic(err)
-    }
-
-    c.Cleanup()
-
-    if reportFilename != "" {
-        reportingQuitChannel <- true
+        fail(err)
     }
 
-    fmt.Printf("%s\n", hash)
-}
+    // Problems from here on out don't stop us from reporting the hash; the
+    // catalog has already been updated.
+    partialFailure := false
 
-func recordChanges (reportFilename string, reportingChannel <-chan *pfinternal.ChangeEvent, reportingQuit <-chan bool) {
-    l := pfinternal.NewLogger("recordChanges")
+    err = c.Cleanup()
+    if err != nil {
+        l.Error("Could not cleanup catalog.", "err", err)
+        fmt.Fprintf(os.Stderr, "ERROR: Could not cleanup catalog: %s\n", err.Error())
 
-    var f *os.File
-    if reportFilename == "-" {
-        f = os.Stderr
-    } else {
-        f, err := os.Create(reportFilename)
-        if err != nil {
-            panic(err)
+        // Not every deletion was seen, so the scan can't be replayed.
+        if hcs != nil {
+            hcs.Error(err)
         }
 
-        defer f.Close()
+        partialFailure = true
     }
 
-    l.Debug("Reporter running.")
+    if wcs != nil {
+        oldHash := ""
+        if lhp := c.LastHash(); lhp != nil {
+            oldHash = *lhp
+        }
 
-    for {
-        select {
-            case change := <-reportingChannel:
-                changeTypeName := pfinternal.UpdateTypeName(change.ChangeType)
-                entityTypeName := pfinternal.EntityTypeName(change.EntityType)
+        wcs.SetRootHashes(oldHash, hash)
+    }
 
-                l.Debug("Catalog change.", "EntityType", entityTypeName, "ChangeType", changeTypeName, "RelPath", change.RelPath)
+    // Every event has been delivered by now, but the sinks might still be 
+    // holding some of them.
+    if cs != nil {
+        err = cs.End()
+        if err != nil {
+            l.Error("Could not deliver changes.", "err", err)
+            fmt.Fprintf(os.Stderr, "ERROR: Could not deliver changes: %s\n", err.Error())
 
-                var effectiveRelPath string
-                if change.EntityType == pfinternal.EntityTypePath && change.RelPath == "" {
-                    effectiveRelPath = "."
-                } else {
-                    effectiveRelPath = change.RelPath
-                }
+            partialFailure = true
+        }
+    }
 
-                f.WriteString(changeTypeName)
-                f.WriteString(" ")
-                f.WriteString(entityTypeName)
-                f.WriteString(" ")
-                f.WriteString(effectiveRelPath)
-                f.WriteString("\n")
+    fmt.Printf("%s\n", hash)
 
-            case <-reportingQuit:
-                return
-        }
+    changeCount := 0
+    if counter != nil {
+        changeCount = counter.count
     }
+
+    exitCode = scanExitCode(o.ExitCode, partialFailure, changeCount, c.LastHash(), hash)
 }
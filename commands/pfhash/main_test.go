@@ -0,0 +1,70 @@
+package main
+
+import (
+    "testing"
+)
+
+func TestScanExitCode(t *testing.T) {
+    hash := "8250cf94b55e106ce48a83a15569b866aecc1183"
+    otherHash := "da39a3ee5e6b4b0d3255bfef95601890afd80709"
+
+    cases := []struct {
+        exitCodeRequested bool
+        partialFailure bool
+        changeCount int
+        lastHash *string
+        expected int
+    } {
+        // Nothing changed.
+        { true, false, 0, &hash, ExitNoChanges },
+        // Something changed.
+        { true, false, 2, &hash, ExitChanges },
+        { true, false, 0, &otherHash, ExitChanges },
+        // The root wasn't recorded before.
+        { true, false, 0, nil, ExitChanges },
+        // Partial failures win over changes.
+        { true, true, 2, &otherHash, ExitPartialFailure },
+        // Without -x, only failures are reported.
+        { false, false, 2, &otherHash, 0 },
+        { false, true, 0, &hash, 1 },
+    }
+
+    for i, c := range cases {
+        actual := scanExitCode(c.exitCodeRequested, c.partialFailure, c.changeCount, c.lastHash, hash)
+        if actual != c.expected {
+            t.Fatalf("Exit code not correct for case (%d): (%d) != (%d)", i, actual, c.expected)
+        }
+    }
+}
+
+func TestFatalExitCodeFor(t *testing.T) {
+    cases := []struct {
+        args []string
+        expected int
+    } {
+        { []string { "-c", "catalog" }, 1 },
+        { []string { "-c", "catalog", "-x" }, ExitFatal },
+        // Bundled short flags.
+        { []string { "-c", "catalog", "-nx" }, ExitFatal },
+        // Parsing doesn't stop at an unknown flag.
+        { []string { "--bad-flag", "--exit-code" }, ExitFatal },
+        { []string { "--bad-flag", "-x" }, ExitFatal },
+        { []string { "-c", "catalog", "fsck" }, ExitFsckFatal },
+        { []string { "-c", "catalog", "--bad-flag", "fsck", "--repair" }, ExitFsckFatal },
+        // A required flag that's missing doesn't hide the command.
+        { []string { "fsck" }, ExitFsckFatal },
+        // fsck has its own codes, even with -x.
+        { []string { "-x", "fsck" }, ExitFsckFatal },
+        // Option values aren't commands or flags.
+        { []string { "-s", "fsck", "-c", "catalog" }, 1 },
+        { []string { "-c", "fsck", "-x" }, ExitFatal },
+        { []string { "-c", "-x" }, 1 },
+    }
+
+    for i, c := range cases {
+        actual := fatalExitCodeFor(c.args)
+        if actual != c.expected {
+            t.Fatalf("Fatal exit code not correct for case (%d): (%d) != (%d)", i, actual, c.expected)
+        }
+    }
+}
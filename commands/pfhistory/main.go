@@ -0,0 +1,179 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "time"
+
+    flags "github.com/jessevdk/go-flags"
+
+    "github.com/dsoprea/go-pathfingerprint/internal/pfinternal"
+)
+
+type scansOptions struct {
+}
+
+type changesOptions struct {
+    Arguments struct {
+        ScanId int          `positional-arg-name:"scan-id" description:"Scan to show the changes for"`
+    } `positional-args:"true" required:"true"`
+}
+
+type timelineOptions struct {
+    Arguments struct {
+        RelPath string      `positional-arg-name:"rel-path" description:"Path or file to show the changes for"`
+    } `positional-args:"true" required:"true"`
+}
+
+type options struct {
+    CatalogFilepath string  `short:"c" long:"catalog-filepath" description:"Catalog file-path" required:"true"`
+    HashAlgorithm string    `short:"h" long:"algorithm" default:"sha1" description:"Hashing algorithm (sha1, sha256)"`
+    ShowDebugLogging bool   `short:"d" long:"debug-log" description:"Show debug logging"`
+
+    Scans scansOptions          `command:"scans" description:"List the recorded scans"`
+    Changes changesOptions      `command:"changes" description:"Show what changed in a scan"`
+    Timeline timelineOptions    `command:"timeline" description:"Show every recorded change to a path or file"`
+}
+
+func readOptions () (*options, string) {
+    o := options {}
+
+    p := flags.NewParser(&o, flags.Default)
+
+    _, err := p.Parse()
+    if err != nil {
+        os.Exit(1)
+    }
+
+    return &o, p.Active.Name
+}
+
+func formatEpoch(epoch int64) string {
+    return time.Unix(epoch, 0).Format(time.RFC3339)
+}
+
+// Hashes that aren't known are shown as a dash.
+func formatHash(hash string) string {
+    if hash == "" {
+        return "-"
+    }
+
+    return hash
+}
+
+// The root is shown as a dot, like the other tools show it.
+func formatRelPath(relPath string) string {
+    if relPath == "" {
+        return "."
+    }
+
+    return relPath
+}
+
+func printScans(scans []*pfinternal.ScanRecord) {
+    for _, sr := range scans {
+        endTime := "incomplete"
+        if sr.EndEpoch != nil {
+            endTime = formatEpoch(*sr.EndEpoch)
+        }
+
+        fmt.Printf("%d\t%s\t%s\t+%d ~%d -%d\t%s\n",
+            sr.ScanId,
+            formatEpoch(sr.StartEpoch),
+            endTime,
+            sr.Creates,
+            sr.Updates,
+            sr.Deletes,
+            formatHash(sr.RootHash))
+    }
+}
+
+func printChanges(changes []*pfinternal.ScanChange) {
+    for _, sc := range changes {
+        fmt.Printf("%s %s %s\t%s -> %s\n",
+            pfinternal.UpdateTypeName(sc.ChangeType),
+            pfinternal.EntityTypeName(sc.EntityType),
+            formatRelPath(sc.RelPath),
+            formatHash(sc.PreviousHash),
+            formatHash(sc.Hash))
+    }
+}
+
+func printTimeline(changes []*pfinternal.ScanChange) {
+    for _, sc := range changes {
+        fmt.Printf("%d\t%s\t%s %s\t%s -> %s\n",
+            sc.ScanId,
+            formatEpoch(sc.StartEpoch),
+            pfinternal.UpdateTypeName(sc.ChangeType),
+            pfinternal.EntityTypeName(sc.EntityType),
+            formatHash(sc.PreviousHash),
+            formatHash(sc.Hash))
+    }
+}
+
+func main() {
+    defer func() {
+        if r := recover(); r != nil {
+            err := r.(error)
+
+            fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+            os.Exit(1)
+        }
+    }()
+
+    o, command := readOptions()
+
+    if o.ShowDebugLogging == true {
+        pfinternal.SetDebugLogging()
+    }
+
+    pfinternal.ConfigureRootLogger()
+
+    // Opening a catalog that doesn't exist would create an empty one.
+    _, err := os.Stat(o.CatalogFilepath)
+    if err != nil {
+        panic(err)
+    }
+
+    cr, err := pfinternal.NewCatalogResource(&o.CatalogFilepath, &o.HashAlgorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    err = cr.OpenReadOnly()
+    if err != nil {
+        panic(err)
+    }
+
+    defer cr.Close()
+
+    isHistoryEnabled, err := cr.HistoryEnabled()
+    if err != nil {
+        panic(err)
+    } else if isHistoryEnabled == false {
+        fmt.Fprintf(os.Stderr, "History isn't enabled for this catalog (see pfhash --history).\n")
+    }
+
+    if command == "scans" {
+        scans, err := cr.ListScans()
+        if err != nil {
+            panic(err)
+        }
+
+        printScans(scans)
+    } else if command == "changes" {
+        changes, err := cr.GetScanChanges(o.Changes.Arguments.ScanId)
+        if err != nil {
+            panic(err)
+        }
+
+        printChanges(changes)
+    } else {
+        changes, err := cr.GetTimeline(&o.Timeline.Arguments.RelPath)
+        if err != nil {
+            panic(err)
+        }
+
+        printTimeline(changes)
+    }
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+    "os"
+    "io"
+    "fmt"
+
+    flags "github.com/jessevdk/go-flags"
+
+    "github.com/dsoprea/go-pathfingerprint/internal/pfinternal"
+)
+
+type options struct {
+    CatalogFilepath string  `short:"c" long:"catalog-filepath" description:"Catalog file-path (will be created if it doesn't exist)" required:"true"`
+    ShowDebugLogging bool   `short:"d" long:"debug-log" description:"Show debug logging"`
+
+    Arguments struct {
+        ManifestFilepath string `positional-arg-name:"manifest" description:"Manifest file-path ('-' for STDIN)"`
+        ScanPath string     `positional-arg-name:"path" description:"Path that the manifest describes"`
+    } `positional-args:"true" required:"true"`
+}
+
+func readOptions () *options {
+    o := options {}
+
+    _, err := flags.Parse(&o)
+    if err != nil {
+        os.Exit(1)
+    }
+
+    return &o
+}
+
+func readManifest(manifestFilepath string) *pfinternal.Manifest {
+    var r io.Reader
+
+    if manifestFilepath == "-" {
+        r = os.Stdin
+    } else {
+        f, err := os.Open(manifestFilepath)
+        if err != nil {
+            panic(err)
+        }
+
+        defer f.Close()
+
+        r = f
+    }
+
+    m, err := pfinternal.ReadManifest(r)
+    if err != nil {
+        panic(err)
+    }
+
+    return m
+}
+
+func main() {
+    defer func() {
+        if r := recover(); r != nil {
+            err := r.(error)
+
+            fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+            os.Exit(1)
+        }
+    }()
+
+    o := readOptions()
+
+    if o.ShowDebugLogging == true {
+        pfinternal.SetDebugLogging()
+    }
+
+    pfinternal.ConfigureRootLogger()
+
+    m := readManifest(o.Arguments.ManifestFilepath)
+
+    // The catalog has to use the same algorithm as the manifest. An existing
+    // catalog with a different one will fail to open.
+    cr, err := pfinternal.NewCatalogResource(&o.CatalogFilepath, &m.Algorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    err = cr.Open()
+    if err != nil {
+        panic(err)
+    }
+
+    defer cr.Close()
+
+    ir, err := cr.ImportManifest(&o.Arguments.ScanPath, m)
+    if err != nil {
+        panic(err)
+    }
+
+    for _, is := range ir.Skipped {
+        fmt.Fprintf(os.Stderr, "skipped (%s): %s\n", is.Reason, is.RelPath)
+    }
+
+    fmt.Printf("Imported (%d) files. Skipped (%d).\n", ir.Imported, len(ir.Skipped))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+    "io"
+    "fmt"
+    "errors"
+    "strconv"
+    "strings"
+
+    "encoding/json"
+
+    "github.com/dsoprea/go-pathfingerprint/internal/pfinternal"
+)
+
+const (
+    FormatText = "text"
+    FormatJson = "json"
+    FormatJsonLines = "jsonl"
+    FormatYaml = "yaml"
+    FormatTsv = "tsv"
+)
+
+var tsvEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+// The fields of a result, in the order that they're written.
+func resultFields(rr *pfinternal.ResolveResult) [][2]string {
+    optional := func(value *int64) string {
+        if value == nil {
+            return ""
+        }
+
+        return strconv.FormatInt(*value, 10)
+    }
+
+    fields := [][2]string {
+        { "rel_path", rr.RelPath },
+        { "path_id", strconv.Itoa(rr.PathId) },
+        { "filename", rr.Filename },
+        { "file_id", strconv.Itoa(rr.FileId) },
+        { "hash", rr.Hash },
+        { "mtime", optional(rr.Mtime) },
+        { "size", optional(rr.Size) },
+        { "last_check_epoch", strconv.FormatInt(rr.LastCheckEpoch, 10) },
+    }
+
+    return fields
+}
+
+// Quote a string for YAML. JSON strings are valid YAML double-quoted scalars.
+func yamlString(value string) string {
+    encoded, err := json.Marshal(value)
+    if err != nil {
+        panic(err)
+    }
+
+    return string(encoded)
+}
+
+func writeYamlResult(w io.Writer, rr *pfinternal.ResolveResult, isListItem bool) {
+    for i, field := range resultFields(rr) {
+        var prefix string
+        if isListItem == false {
+            prefix = ""
+        } else if i == 0 {
+            prefix = "- "
+        } else {
+            prefix = "  "
+        }
+
+        var value string
+        if field[0] == "rel_path" || field[0] == "filename" || field[0] == "hash" {
+            value = yamlString(field[1])
+        } else if field[1] == "" {
+            value = "null"
+        } else {
+            value = field[1]
+        }
+
+        fmt.Fprintf(w, "%s%s: %s\n", prefix, field[0], value)
+    }
+}
+
+// Write the results in one of the machine-readable formats. If a single result
+// is expected (isList is false), JSON and YAML output will be a single object
+// rather than a list.
+func writeResults(w io.Writer, format string, results []*pfinternal.ResolveResult, isList bool) (err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = r.(error)
+        }
+    }()
+
+    switch format {
+    case FormatJson:
+        var encoded []byte
+        if isList == true {
+            encoded, err = json.MarshalIndent(results, "", "  ")
+        } else {
+            encoded, err = json.MarshalIndent(results[0], "", "  ")
+        }
+
+        if err != nil {
+            panic(err)
+        }
+
+        w.Write(encoded)
+        io.WriteString(w, "\n")
+
+    case FormatJsonLines:
+        e := json.NewEncoder(w)
+
+        for _, rr := range results {
+            err = e.Encode(rr)
+            if err != nil {
+                panic(err)
+            }
+        }
+
+    case FormatYaml:
+        if isList == true && len(results) == 0 {
+            io.WriteString(w, "[]\n")
+        }
+
+        for _, rr := range results {
+            writeYamlResult(w, rr, isList)
+        }
+
+    case FormatTsv:
+        for i, field := range resultFields(&pfinternal.ResolveResult {}) {
+            if i > 0 {
+                io.WriteString(w, "\t")
+            }
+
+            io.WriteString(w, field[0])
+        }
+
+        io.WriteString(w, "\n")
+
+        for _, rr := range results {
+            for i, field := range resultFields(rr) {
+                if i > 0 {
+                    io.WriteString(w, "\t")
+                }
+
+                io.WriteString(w, tsvEscaper.Replace(field[1]))
+            }
+
+            io.WriteString(w, "\n")
+        }
+
+    default:
+        panic(errors.New(fmt.Sprintf("Format not valid: [%s]", format)))
+    }
+
+    return nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+    "testing"
+    "bytes"
+
+    "encoding/json"
+
+    "github.com/dsoprea/go-pathfingerprint/internal/pfinternal"
+)
+
+// Names that need quoting or escaping in at least one of the formats.
+var formatTestNames = []string {
+    "key: value",
+    "# not a comment",
+    "line1\nline2",
+    "col1\tcol2",
+    "back\\slash \"quoted\"",
+}
+
+func newFormatTestResults() []*pfinternal.ResolveResult {
+    mtime := int64(1477093840)
+    size := int64(5)
+
+    results := make([]*pfinternal.ResolveResult, 0)
+    for i, name := range formatTestNames {
+        rr := &pfinternal.ResolveResult {
+                RelPath: "dir1",
+                PathId: 2,
+                Filename: name,
+                FileId: i + 1,
+                Hash: "90cda474cb6daddeb084c0f58abe41b26f418e8f",
+                Mtime: &mtime,
+                Size: &size,
+                LastCheckEpoch: 1792369981,
+        }
+
+        results = append(results, rr)
+    }
+
+    // A path, which has no mtime or size.
+    results = append(results, &pfinternal.ResolveResult {
+            RelPath: "dir: 2",
+            PathId: 3,
+            Hash: "da39a3ee5e6b4b0d3255bfef95601890afd80709",
+            LastCheckEpoch: 1792369981,
+    })
+
+    return results
+}
+
+func TestWriteResultsYaml(t *testing.T) {
+    results := newFormatTestResults()
+
+    b := new(bytes.Buffer)
+
+    err := writeResults(b, FormatYaml, results[:1], false)
+    if err != nil {
+        t.Fatalf("Could not write YAML object.")
+    }
+
+    expected :=
+        "rel_path: \"dir1\"\n" +
+        "path_id: 2\n" +
+        "filename: \"key: value\"\n" +
+        "file_id: 1\n" +
+        "hash: \"90cda474cb6daddeb084c0f58abe41b26f418e8f\"\n" +
+        "mtime: 1477093840\n" +
+        "size: 5\n" +
+        "last_check_epoch: 1792369981\n"
+
+    if b.String() != expected {
+        t.Fatalf("YAML object not correct:\n%s", b.String())
+    }
+
+    b = new(bytes.Buffer)
+
+    err = writeResults(b, FormatYaml, results[1:], true)
+    if err != nil {
+        t.Fatalf("Could not write YAML list.")
+    }
+
+    expected =
+        "- rel_path: \"dir1\"\n" +
+        "  path_id: 2\n" +
+        "  filename: \"# not a comment\"\n" +
+        "  file_id: 2\n" +
+        "  hash: \"90cda474cb6daddeb084c0f58abe41b26f418e8f\"\n" +
+        "  mtime: 1477093840\n" +
+        "  size: 5\n" +
+        "  last_check_epoch: 1792369981\n" +
+        "- rel_path: \"dir1\"\n" +
+        "  path_id: 2\n" +
+        "  filename: \"line1\\nline2\"\n" +
+        "  file_id: 3\n" +
+        "  hash: \"90cda474cb6daddeb084c0f58abe41b26f418e8f\"\n" +
+        "  mtime: 1477093840\n" +
+        "  size: 5\n" +
+        "  last_check_epoch: 1792369981\n" +
+        "- rel_path: \"dir1\"\n" +
+        "  path_id: 2\n" +
+        "  filename: \"col1\\tcol2\"\n" +
+        "  file_id: 4\n" +
+        "  hash: \"90cda474cb6daddeb084c0f58abe41b26f418e8f\"\n" +
+        "  mtime: 1477093840\n" +
+        "  size: 5\n" +
+        "  last_check_epoch: 1792369981\n" +
+        "- rel_path: \"dir1\"\n" +
+        "  path_id: 2\n" +
+        "  filename: \"back\\\\slash \\\"quoted\\\"\"\n" +
+        "  file_id: 5\n" +
+        "  hash: \"90cda474cb6daddeb084c0f58abe41b26f418e8f\"\n" +
+        "  mtime: 1477093840\n" +
+        "  size: 5\n" +
+        "  last_check_epoch: 1792369981\n" +
+        "- rel_path: \"dir: 2\"\n" +
+        "  path_id: 3\n" +
+        "  filename: \"\"\n" +
+        "  file_id: 0\n" +
+        "  hash: \"da39a3ee5e6b4b0d3255bfef95601890afd80709\"\n" +
+        "  mtime: null\n" +
+        "  size: null\n" +
+        "  last_check_epoch: 1792369981\n"
+
+    if b.String() != expected {
+        t.Fatalf("YAML list not correct:\n%s", b.String())
+    }
+
+    b = new(bytes.Buffer)
+
+    err = writeResults(b, FormatYaml, []*pfinternal.ResolveResult {}, true)
+    if err != nil {
+        t.Fatalf("Could not write empty YAML list.")
+    } else if b.String() != "[]\n" {
+        t.Fatalf("Empty YAML list not correct: [%s]", b.String())
+    }
+}
+
+func TestWriteResultsTsv(t *testing.T) {
+    results := newFormatTestResults()
+
+    b := new(bytes.Buffer)
+
+    err := writeResults(b, FormatTsv, results[2:], true)
+    if err != nil {
+        t.Fatalf("Could not write TSV.")
+    }
+
+    expected :=
+        "rel_path\tpath_id\tfilename\tfile_id\thash\tmtime\tsize\tlast_check_epoch\n" +
+        "dir1\t2\tline1\\nline2\t3\t90cda474cb6daddeb084c0f58abe41b26f418e8f\t1477093840\t5\t1792369981\n" +
+        "dir1\t2\tcol1\\tcol2\t4\t90cda474cb6daddeb084c0f58abe41b26f418e8f\t1477093840\t5\t1792369981\n" +
+        "dir1\t2\tback\\\\slash \"quoted\"\t5\t90cda474cb6daddeb084c0f58abe41b26f418e8f\t1477093840\t5\t1792369981\n" +
+        "dir: 2\t3\t\t0\tda39a3ee5e6b4b0d3255bfef95601890afd80709\t\t\t1792369981\n"
+
+    if b.String() != expected {
+        t.Fatalf("TSV not correct:\n%s", b.String())
+    }
+}
+
+func TestWriteResultsJson(t *testing.T) {
+    results := newFormatTestResults()
+
+    // Whatever the names have in them, they have to come back the same.
+
+    b := new(bytes.Buffer)
+
+    err := writeResults(b, FormatJson, results, true)
+    if err != nil {
+        t.Fatalf("Could not write JSON.")
+    }
+
+    decoded := make([]*pfinternal.ResolveResult, 0)
+
+    err = json.Unmarshal(b.Bytes(), &decoded)
+    if err != nil {
+        t.Fatalf("Could not decode JSON: %s", err)
+    } else if len(decoded) != len(results) {
+        t.Fatalf("JSON has the wrong number of results: (%d)", len(decoded))
+    }
+
+    for i, rr := range decoded {
+        if rr.Filename != results[i].Filename || rr.RelPath != results[i].RelPath {
+            t.Fatalf("JSON result (%d) not correct: %v", i, rr)
+        }
+    }
+
+    b = new(bytes.Buffer)
+
+    err = writeResults(b, FormatJson, results[:1], false)
+    if err != nil {
+        t.Fatalf("Could not write JSON object.")
+    }
+
+    rr := new(pfinternal.ResolveResult)
+
+    err = json.Unmarshal(b.Bytes(), rr)
+    if err != nil {
+        t.Fatalf("JSON for a single result isn't an object: %s", err)
+    } else if rr.Filename != "key: value" || *rr.Size != 5 {
+        t.Fatalf("JSON object not correct: %v", rr)
+    }
+
+    // One object per line.
+
+    b = new(bytes.Buffer)
+
+    err = writeResults(b, FormatJsonLines, results, true)
+    if err != nil {
+        t.Fatalf("Could not write JSON lines.")
+    }
+
+    lines := bytes.Split(bytes.TrimRight(b.Bytes(), "\n"), []byte("\n"))
+    if len(lines) != len(results) {
+        t.Fatalf("JSON lines has the wrong number of lines: (%d)", len(lines))
+    }
+
+    for i, line := range lines {
+        rr := new(pfinternal.ResolveResult)
+
+        err = json.Unmarshal(line, rr)
+        if err != nil {
+            t.Fatalf("Could not decode JSON line (%d): %s", i, err)
+        } else if rr.Filename != results[i].Filename {
+            t.Fatalf("JSON line (%d) not correct: %v", i, rr)
+        }
+    }
+
+    last := new(pfinternal.ResolveResult)
+    json.Unmarshal(lines[len(lines) - 1], last)
+
+    if last.Mtime != nil || last.Size != nil {
+        t.Fatalf("Path should have a null mtime and size: %v", last)
+    }
+}
+
+func TestWriteResultsInvalidFormat(t *testing.T) {
+    err := writeResults(new(bytes.Buffer), "xml", newFormatTestResults(), true)
+    if err == nil {
+        t.Fatalf("Expected error for invalid format.")
+    }
+}
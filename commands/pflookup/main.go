@@ -3,18 +3,40 @@ package main
 import (
     "os"
     "fmt"
+    "path"
+    "io"
+    "errors"
+    "time"
     
     flags "github.com/jessevdk/go-flags"
+    "github.com/mattn/go-colorable"
 
     "github.com/dsoprea/go-pathfingerprint/internal/pfinternal"
 )
 
+const (
+    ExitSuccess = 0
+    ExitFailure = 1
+    ExitNotFound = 2
+)
+
 type options struct {
     CatalogFilepath string  `short:"c" long:"catalog-filepath" description:"Catalog file-path" required:"true"`
     HashAlgorithm string    `short:"h" long:"algorithm" default:"sha1" description:"Hashing algorithm (sha1, sha256)"`
-    ShowDebugLogging bool   `short:"d" long:"debug-log" default:"false" description:"Show debug logging"`
-    ShowExtended bool       `short:"e" long:"show-extended" default:"false" description:"Show extended info"`
+    ShowDebugLogging bool   `short:"d" long:"debug-log" description:"Show debug logging"`
+    ShowExtended bool       `short:"e" long:"show-extended" description:"Show extended info"`
     RelPath string          `short:"r" long:"rel-path" default:"" description:"Specific subdirectory"`
+    ShowChildren bool       `short:"C" long:"children" description:"Print the hashes of the children of the path rather than of the path itself"`
+    Levels []int            `short:"l" long:"level" description:"Depth of the children to print, where (1) is the immediate children (can be provided more than once) (default: 1)"`
+    IncludeFiles bool       `short:"f" long:"files" description:"Include files when printing children"`
+    NameFilter string       `short:"m" long:"match" default:"" description:"Only print children whose names match this glob pattern"`
+    ShowTree bool           `short:"t" long:"tree" description:"Print the path and everything below it as a tree"`
+    MaxDepth int            `long:"max-depth" default:"0" description:"Don't print the tree any deeper than this ((0) for no limit)"`
+    FullHash bool           `long:"full-hash" description:"Print full hashes in the tree"`
+    Color bool              `long:"color" description:"Highlight entries in the tree that changed in the last scan"`
+    FindHash string         `short:"H" long:"hash" default:"" description:"Print every path and file in the catalog that has this hash"`
+    Format string           `short:"F" long:"format" default:"text" choice:"text" choice:"json" choice:"jsonl" choice:"yaml" choice:"tsv" description:"Output format (text, json, jsonl, yaml, tsv)"`
+    AsOf string             `long:"as-of" default:"" description:"Resolve the path as of a recorded scan (a scan ID, or a time like 2016-01-31 or 2016-01-31T12:00:00)"`
 }
 
 func readOptions () *options {
@@ -28,13 +50,34 @@ func readOptions () *options {
     return &o
 }
 
+// Describe the result as a relative path. Directories have a trailing slash.
+func displayRelPath(rr *pfinternal.ResolveResult) string {
+    if rr.FileId != 0 {
+        return path.Join(rr.RelPath, rr.Filename)
+    } else if rr.RelPath == "" {
+        return "./"
+    } else {
+        return rr.RelPath + "/"
+    }
+}
+
 func main() {
+    exitCode := ExitSuccess
+
+    // This runs after everything else has been closed.
     defer func() {
         if r := recover(); r != nil {
             err := r.(error)
 
             fmt.Printf("ERROR: %s\n", err.Error())
-            os.Exit(1)
+
+            if err == pfinternal.ErrPathNotFound || err == pfinternal.ErrFileNotFound || err == pfinternal.ErrRootNotRecorded {
+                os.Exit(ExitNotFound)
+            }
+
+            os.Exit(ExitFailure)
+        } else if exitCode != ExitSuccess {
+            os.Exit(exitCode)
         }
     }()
 
@@ -61,19 +104,144 @@ func main() {
         panic(err)
     }
 
-    err = cr.Open()
+    err = cr.OpenReadOnly()
     if err != nil {
         panic(err)
     }
 
     defer cr.Close()
 
+    if o.AsOf != "" {
+        if o.Format != FormatText || o.ShowTree == true || o.ShowChildren == true || o.FindHash != "" {
+            panic(errors.New("Only the hash of a path can be looked up as of a scan, and only as text."))
+        }
+
+        sr, err := cr.FindScan(o.AsOf)
+        if err != nil {
+            panic(err)
+        }
+
+        sc, err := cr.ResolvePathAsOf(&relPath, sr.ScanId)
+        if err != nil {
+            panic(err)
+        }
+
+        if showExtended == true {
+            fmt.Printf("Name: [%s]\n", sc.RelPath)
+            fmt.Printf("Type: [%s]\n", pfinternal.EntityTypeName(sc.EntityType))
+            fmt.Printf("Hash: [%s]\n", sc.Hash)
+            fmt.Printf("As of scan: (%d) [%s]\n", sr.ScanId, time.Unix(sr.StartEpoch, 0).Format(time.RFC3339))
+            fmt.Printf("Last changed in scan: (%d) [%s]\n", sc.ScanId, time.Unix(sc.StartEpoch, 0).Format(time.RFC3339))
+        } else {
+            fmt.Println(sc.Hash)
+        }
+
+        return
+    }
+
+    if o.ShowTree == true {
+        if o.Format != FormatText {
+            panic(errors.New("The tree can only be printed as text."))
+        }
+
+        root, err := cr.GetTree(&relPath, o.MaxDepth)
+        if err != nil {
+            panic(err)
+        }
+
+        var w io.Writer = os.Stdout
+        if o.Color == true {
+            w = colorable.NewColorableStdout()
+        }
+
+        tp := newTreePrinter(w, o.FullHash, o.Color)
+        tp.Print(root)
+
+        return
+    }
+
+    if o.FindHash != "" {
+        results, err := cr.FindByHash(o.FindHash)
+        if err != nil {
+            panic(err)
+        }
+
+        if o.Format == FormatText {
+            for _, rr := range results {
+                fmt.Println(displayRelPath(rr))
+            }
+        } else {
+            err := writeResults(os.Stdout, o.Format, results, true)
+            if err != nil {
+                panic(err)
+            }
+        }
+
+        if len(results) == 0 {
+            exitCode = ExitNotFound
+        }
+
+        return
+    }
+
+    if o.ShowChildren == true {
+        levels := o.Levels
+        if len(levels) == 0 {
+            levels = []int { 1 }
+        }
+
+        results, err := cr.ListChildren(&relPath, levels, o.IncludeFiles)
+        if err != nil {
+            panic(err)
+        }
+
+        filtered := make([]*pfinternal.ResolveResult, 0)
+
+        for _, rr := range results {
+            var name string
+            if rr.FileId == 0 {
+                name = path.Base(rr.RelPath)
+            } else {
+                name = rr.Filename
+            }
+
+            if o.NameFilter != "" {
+                matched, err := path.Match(o.NameFilter, name)
+                if err != nil {
+                    panic(err)
+                } else if matched == false {
+                    continue
+                }
+            }
+
+            if o.Format == FormatText {
+                fmt.Printf("%s  %s\n", rr.Hash, displayRelPath(rr))
+            } else {
+                filtered = append(filtered, rr)
+            }
+        }
+
+        if o.Format != FormatText {
+            err := writeResults(os.Stdout, o.Format, filtered, true)
+            if err != nil {
+                panic(err)
+            }
+        }
+
+        return
+    }
+
     rr, err := cr.ResolvePath(&relPath)
     if err != nil {
         panic(err)
     }
 
-    if showExtended == true {
+    if o.Format != FormatText {
+        err := writeResults(os.Stdout, o.Format, []*pfinternal.ResolveResult { rr }, false)
+        if err != nil {
+            panic(err)
+        }
+    } else if showExtended == true {
         fmt.Printf("Path name: [%s]\n", rr.RelPath)
         fmt.Printf("Path ID: (%d)\n", rr.PathId)
         fmt.Printf("File name: [%s]\n", rr.Filename)
@@ -0,0 +1,93 @@
+package main
+
+import (
+    "io"
+    "fmt"
+    "time"
+
+    "github.com/dsoprea/go-pathfingerprint/internal/pfinternal"
+)
+
+const (
+    TreeShortHashLength = 12
+    TreeTimestampLayout = "2006-01-02 15:04:05"
+
+    colorChanged = "\x1b[33m"
+    colorReset = "\x1b[0m"
+)
+
+type treePrinter struct {
+    w io.Writer
+    fullHash bool
+    color bool
+}
+
+func newTreePrinter(w io.Writer, fullHash bool, color bool) *treePrinter {
+    tp := treePrinter {
+            w: w,
+            fullHash: fullHash,
+            color: color,
+    }
+
+    return &tp
+}
+
+func (self *treePrinter) describe(node *pfinternal.CatalogTreeNode) string {
+    hash := node.Hash
+    if self.fullHash == false && len(hash) > TreeShortHashLength {
+        hash = hash[:TreeShortHashLength]
+    }
+
+    name := node.Name
+    if node.IsPath == true && node.RelPath != "" {
+        name += "/"
+    }
+
+    if self.color == true && node.Changed == true {
+        name = colorChanged + name + colorReset
+    }
+
+    var size string
+    if node.Size == pfinternal.UnknownFileSize {
+        size = "?"
+    } else {
+        size = fmt.Sprintf("%d", node.Size)
+    }
+
+    var mtime string
+    if node.Mtime == 0 {
+        mtime = "-"
+    } else {
+        mtime = time.Unix(node.Mtime, 0).Format(TreeTimestampLayout)
+    }
+
+    return fmt.Sprintf("%s  [%s] %s %s", name, hash, size, mtime)
+}
+
+func (self *treePrinter) printNode(node *pfinternal.CatalogTreeNode, prefix string) {
+    for i, child := range node.Children {
+        isLast := i == len(node.Children) - 1
+
+        var branch string
+        var childPrefix string
+
+        if isLast == true {
+            branch = "└── "
+            childPrefix = prefix + "    "
+        } else {
+            branch = "├── "
+            childPrefix = prefix + "│   "
+        }
+
+        fmt.Fprintf(self.w, "%s%s%s\n", prefix, branch, self.describe(child))
+
+        self.printNode(child, childPrefix)
+    }
+}
+
+// Print the node and everything below it, like `tree`.
+func (self *treePrinter) Print(root *pfinternal.CatalogTreeNode) {
+    fmt.Fprintf(self.w, "%s\n", self.describe(root))
+
+    self.printNode(root, "")
+}
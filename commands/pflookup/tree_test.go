@@ -0,0 +1,91 @@
+package main
+
+import (
+    "testing"
+    "bytes"
+    "time"
+
+    "github.com/dsoprea/go-pathfingerprint/internal/pfinternal"
+)
+
+func newTestTree() *pfinternal.CatalogTreeNode {
+    hash := "90cda474cb6daddeb084c0f58abe41b26f418e8f"
+
+    cc := &pfinternal.CatalogTreeNode {
+            Name: "cc",
+            RelPath: "dir1/cc",
+            Hash: hash,
+            Size: 5,
+            Mtime: 1477093840,
+            Changed: true,
+    }
+
+    dd := &pfinternal.CatalogTreeNode {
+            Name: "dd",
+            RelPath: "dir1/dd",
+            Hash: hash,
+            Size: pfinternal.UnknownFileSize,
+    }
+
+    dir1 := &pfinternal.CatalogTreeNode {
+            Name: "dir1",
+            RelPath: "dir1",
+            IsPath: true,
+            Hash: hash,
+            Size: 5,
+            Mtime: 1477093840,
+            Changed: true,
+            Children: []*pfinternal.CatalogTreeNode { cc, dd },
+    }
+
+    aa := &pfinternal.CatalogTreeNode {
+            Name: "aa",
+            RelPath: "aa",
+            Hash: hash,
+    }
+
+    root := &pfinternal.CatalogTreeNode {
+            Name: ".",
+            IsPath: true,
+            Hash: hash,
+            Size: 5,
+            Mtime: 1477093840,
+            Children: []*pfinternal.CatalogTreeNode { aa, dir1 },
+    }
+
+    return root
+}
+
+func TestTreePrinter(t *testing.T) {
+    mtime := time.Unix(1477093840, 0).Format(TreeTimestampLayout)
+
+    b := new(bytes.Buffer)
+    tp := newTreePrinter(b, false, false)
+    tp.Print(newTestTree())
+
+    expected :=
+        ".  [90cda474cb6d] 5 " + mtime + "\n" +
+        "├── aa  [90cda474cb6d] 0 -\n" +
+        "└── dir1/  [90cda474cb6d] 5 " + mtime + "\n" +
+        "    ├── cc  [90cda474cb6d] 5 " + mtime + "\n" +
+        "    └── dd  [90cda474cb6d] ? -\n"
+
+    if b.String() != expected {
+        t.Fatalf("Tree not printed correctly:\n%s", b.String())
+    }
+
+    b = new(bytes.Buffer)
+    tp = newTreePrinter(b, true, true)
+    tp.Print(newTestTree())
+
+    expected =
+        ".  [90cda474cb6daddeb084c0f58abe41b26f418e8f] 5 " + mtime + "\n" +
+        "├── aa  [90cda474cb6daddeb084c0f58abe41b26f418e8f] 0 -\n" +
+        "└── " + colorChanged + "dir1/" + colorReset + "  [90cda474cb6daddeb084c0f58abe41b26f418e8f] 5 " + mtime + "\n" +
+        "    ├── " + colorChanged + "cc" + colorReset + "  [90cda474cb6daddeb084c0f58abe41b26f418e8f] 5 " + mtime + "\n" +
+        "    └── dd  [90cda474cb6daddeb084c0f58abe41b26f418e8f] ? -\n"
+
+    if b.String() != expected {
+        t.Fatalf("Tree not printed correctly with full hashes and color:\n%s", b.String())
+    }
+}
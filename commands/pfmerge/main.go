@@ -0,0 +1,97 @@
+package main
+
+import (
+    "os"
+    "fmt"
+
+    flags "github.com/jessevdk/go-flags"
+
+    "github.com/dsoprea/go-pathfingerprint/internal/pfinternal"
+)
+
+type options struct {
+    CatalogFilepath string  `short:"c" long:"catalog-filepath" description:"Catalog to graft into (will be created if it doesn't exist)" required:"true"`
+    HashAlgorithm string    `short:"h" long:"algorithm" default:"sha1" description:"Hashing algorithm (sha1, sha256)"`
+    ShowDebugLogging bool   `short:"d" long:"debug-log" description:"Show debug logging"`
+    RelPath string          `short:"r" long:"rel-path" description:"Where to graft the other catalog (replaces whatever is there)" required:"true"`
+
+    Arguments struct {
+        SourceCatalogFilepath string `positional-arg-name:"source-catalog" description:"Catalog to graft"`
+    } `positional-args:"true" required:"true"`
+}
+
+func readOptions () *options {
+    o := options {}
+
+    _, err := flags.Parse(&o)
+    if err != nil {
+        os.Exit(1)
+    }
+
+    return &o
+}
+
+func main() {
+    defer func() {
+        if r := recover(); r != nil {
+            err := r.(error)
+
+            fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+            os.Exit(1)
+        }
+    }()
+
+    o := readOptions()
+
+    if o.ShowDebugLogging == true {
+        pfinternal.SetDebugLogging()
+    }
+
+    pfinternal.ConfigureRootLogger()
+
+    // Opening a catalog that doesn't exist would create an empty one.
+    _, err := os.Stat(o.Arguments.SourceCatalogFilepath)
+    if err != nil {
+        panic(err)
+    }
+
+    // Both catalogs are opened with the same algorithm, so either one will
+    // fail to open if it was built with another.
+    source, err := pfinternal.NewCatalogResource(&o.Arguments.SourceCatalogFilepath, &o.HashAlgorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    err = source.OpenReadOnly()
+    if err != nil {
+        panic(err)
+    }
+
+    defer source.Close()
+
+    target, err := pfinternal.NewCatalogResource(&o.CatalogFilepath, &o.HashAlgorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    err = target.Open()
+    if err != nil {
+        panic(err)
+    }
+
+    defer target.Close()
+
+    pathCount, fileCount, err := target.GraftCatalog(source, &o.RelPath)
+    if err != nil {
+        panic(err)
+    }
+
+    rootRelPath := ""
+    rr, err := target.ResolvePath(&rootRelPath)
+    if err != nil {
+        panic(err)
+    }
+
+    fmt.Printf("Grafted (%d) paths and (%d) files.\n", pathCount, fileCount)
+    fmt.Printf("%s\n", rr.Hash)
+}
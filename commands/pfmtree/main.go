@@ -0,0 +1,169 @@
+package main
+
+import (
+    "os"
+    "io"
+    "fmt"
+    "bufio"
+
+    "io/ioutil"
+
+    flags "github.com/jessevdk/go-flags"
+
+    "github.com/dsoprea/go-pathfingerprint/internal/pfinternal"
+)
+
+// Exit codes, like diff.
+const (
+    ExitVerified = 0
+    ExitNotVerified = 1
+    ExitFailure = 2
+)
+
+type exportOptions struct {
+    CatalogFilepath string  `short:"c" long:"catalog-filepath" description:"Catalog for the path (only the files that changed since it was last updated are read; it isn't changed)" required:"true"`
+    HashAlgorithm string    `short:"h" long:"algorithm" default:"sha256" description:"Hashing algorithm (sha1, sha256)"`
+    OutputFilepath string   `short:"o" long:"output" default:"-" description:"File to write to ('-' for STDOUT)"`
+
+    Arguments struct {
+        ScanPath string     `positional-arg-name:"path" description:"Path to describe"`
+    } `positional-args:"true" required:"true"`
+}
+
+type verifyOptions struct {
+    CatalogFilepath string  `short:"c" long:"catalog-filepath" default:"" description:"Catalog for the path (only the files that changed since it was last updated are read; it isn't changed)"`
+    Quiet bool              `short:"q" long:"quiet" description:"Don't print the problems (just set the exit code)"`
+
+    Arguments struct {
+        SpecFilepath string `positional-arg-name:"spec" description:"Spec file-path ('-' for STDIN)"`
+        ScanPath string     `positional-arg-name:"path" description:"Path to check"`
+    } `positional-args:"true" required:"true"`
+}
+
+type options struct {
+    ShowDebugLogging bool   `short:"d" long:"debug-log" description:"Show debug logging"`
+
+    Export exportOptions    `command:"export" description:"Write an mtree spec for a path"`
+    Verify verifyOptions    `command:"verify" description:"Check a path against an mtree spec"`
+}
+
+func readOptions () (*options, string) {
+    o := options {}
+
+    p := flags.NewParser(&o, flags.Default)
+
+    _, err := p.Parse()
+    if err != nil {
+        os.Exit(ExitFailure)
+    }
+
+    return &o, p.Active.Name
+}
+
+func exportSpec(o *exportOptions) {
+    var w io.Writer = os.Stdout
+    if o.OutputFilepath != "-" {
+        f, err := os.Create(o.OutputFilepath)
+        if err != nil {
+            panic(err)
+        }
+
+        defer f.Close()
+
+        w = f
+    }
+
+    bw := bufio.NewWriter(w)
+
+    err := pfinternal.ExportMtree(&o.Arguments.ScanPath, &o.CatalogFilepath, &o.HashAlgorithm, bw)
+    if err != nil {
+        panic(err)
+    }
+
+    err = bw.Flush()
+    if err != nil {
+        panic(err)
+    }
+}
+
+func readSpec(specFilepath string) *pfinternal.MtreeSpec {
+    var r io.Reader
+
+    if specFilepath == "-" {
+        r = os.Stdin
+    } else {
+        f, err := os.Open(specFilepath)
+        if err != nil {
+            panic(err)
+        }
+
+        defer f.Close()
+
+        r = f
+    }
+
+    spec, err := pfinternal.ReadMtree(r)
+    if err != nil {
+        panic(err)
+    }
+
+    return spec
+}
+
+func verifySpec(o *verifyOptions) int {
+    spec := readSpec(o.Arguments.SpecFilepath)
+
+    var w io.Writer = os.Stdout
+    if o.Quiet == true {
+        w = ioutil.Discard
+    }
+
+    // The problems are reported the same way that pfhash reports changes.
+    rcs := pfinternal.NewReportChangeSink(w, false)
+
+    count, err := pfinternal.VerifyMtree(&o.Arguments.ScanPath, &o.CatalogFilepath, spec, rcs)
+    if err != nil {
+        panic(err)
+    }
+
+    err = rcs.End()
+    if err != nil {
+        panic(err)
+    }
+
+    if count > 0 {
+        return ExitNotVerified
+    }
+
+    return ExitVerified
+}
+
+func main() {
+    exitCode := ExitVerified
+
+    // This runs after everything else has been cleaned-up.
+    defer func() {
+        if r := recover(); r != nil {
+            err := r.(error)
+
+            fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+            os.Exit(ExitFailure)
+        } else if exitCode != ExitVerified {
+            os.Exit(exitCode)
+        }
+    }()
+
+    o, command := readOptions()
+
+    if o.ShowDebugLogging == true {
+        pfinternal.SetDebugLogging()
+    }
+
+    pfinternal.ConfigureRootLogger()
+
+    if command == "export" {
+        exportSpec(&o.Export)
+    } else {
+        exitCode = verifySpec(&o.Verify)
+    }
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+    "os"
+    "io"
+    "fmt"
+    "errors"
+
+    "io/ioutil"
+
+    flags "github.com/jessevdk/go-flags"
+
+    "github.com/dsoprea/go-pathfingerprint/internal/pfinternal"
+)
+
+// Exit codes, like diff.
+const (
+    ExitVerified = 0
+    ExitNotVerified = 1
+    ExitFailure = 2
+)
+
+type options struct {
+    ShowDebugLogging bool   `short:"d" long:"debug-log" description:"Show debug logging"`
+    CatalogFilepath string  `short:"c" long:"catalog-filepath" default:"" description:"Catalog for the path (will be created if it doesn't exist; a temporary one is used if not given)"`
+    Quiet bool              `short:"q" long:"quiet" description:"Don't print the problems or the hash (just set the exit code)"`
+
+    Arguments struct {
+        ManifestFilepath string `positional-arg-name:"manifest" description:"Manifest file-path ('-' for STDIN)"`
+        ScanPath string     `positional-arg-name:"path" description:"Path to check"`
+    } `positional-args:"true" required:"true"`
+}
+
+// Prints the problems as they're found.
+type problemPrinter struct {
+    w io.Writer
+}
+
+func (self *problemPrinter) Begin() error {
+    return nil
+}
+
+func (self *problemPrinter) Event(ce *pfinternal.ChangeEvent) error {
+    var problem string
+
+    switch ce.ChangeType {
+    case pfinternal.UpdateTypeCreate:
+        problem = "extra"
+
+    case pfinternal.UpdateTypeDelete:
+        problem = "missing"
+
+    case pfinternal.UpdateTypeUpdate:
+        problem = "mismatch"
+
+    default:
+        return errors.New(fmt.Sprintf("Change-type not valid: (%d)", ce.ChangeType))
+    }
+
+    relPath := ce.RelPath
+    if relPath == "" {
+        relPath = "."
+    }
+
+    _, err := fmt.Fprintf(self.w, "%s %s %s\n", problem, pfinternal.EntityTypeName(ce.EntityType), relPath)
+    return err
+}
+
+func (self *problemPrinter) End() error {
+    return nil
+}
+
+func (self *problemPrinter) Error(err error) {
+}
+
+func readOptions () *options {
+    o := options {}
+
+    _, err := flags.Parse(&o)
+    if err != nil {
+        os.Exit(ExitFailure)
+    }
+
+    return &o
+}
+
+func readManifest(manifestFilepath string) *pfinternal.Manifest {
+    var r io.Reader
+
+    if manifestFilepath == "-" {
+        r = os.Stdin
+    } else {
+        f, err := os.Open(manifestFilepath)
+        if err != nil {
+            panic(err)
+        }
+
+        defer f.Close()
+
+        r = f
+    }
+
+    m, err := pfinternal.ReadManifest(r)
+    if err != nil {
+        panic(err)
+    }
+
+    return m
+}
+
+func main() {
+    exitCode := ExitVerified
+
+    // This runs after everything else has been cleaned-up.
+    defer func() {
+        if r := recover(); r != nil {
+            err := r.(error)
+
+            fmt.Printf("ERROR: %s\n", err.Error())
+            os.Exit(ExitFailure)
+        } else if exitCode != ExitVerified {
+            os.Exit(exitCode)
+        }
+    }()
+
+    o := readOptions()
+
+    if o.ShowDebugLogging == true {
+        pfinternal.SetDebugLogging()
+    }
+
+    pfinternal.ConfigureRootLogger()
+
+    m := readManifest(o.Arguments.ManifestFilepath)
+
+    catalogFilepath := o.CatalogFilepath
+    if catalogFilepath == "" {
+        f, err := ioutil.TempFile("", "pfverify_")
+        if err != nil {
+            panic(err)
+        }
+
+        f.Close()
+
+        catalogFilepath = f.Name()
+        defer os.Remove(catalogFilepath)
+    }
+
+    var w io.Writer = os.Stdout
+    if o.Quiet == true {
+        w = ioutil.Discard
+    }
+
+    pp := &problemPrinter {
+            w: w,
+    }
+
+    rootHash, count, err := pfinternal.VerifyTree(&o.Arguments.ScanPath, &catalogFilepath, m, pp)
+    if err != nil {
+        panic(err)
+    }
+
+    // JSON manifests have the root hash, too.
+    if m.RootHash != "" && m.RootHash != rootHash {
+        fmt.Fprintf(w, "mismatch root %s (expected %s)\n", rootHash, m.RootHash)
+        count++
+    }
+
+    fmt.Fprintf(w, "%s\n", rootHash)
+
+    if count > 0 {
+        exitCode = ExitNotVerified
+    }
+}
@@ -0,0 +1,493 @@
+package pfinternal
+
+import (
+    "os"
+    "io"
+    "fmt"
+    "path"
+    "bufio"
+    "errors"
+    "regexp"
+    "strings"
+    "time"
+)
+
+const (
+    BagItVersion = "1.0"
+
+    bagItPayloadDirectory = "data"
+)
+
+// The algorithms that we can check, strongest first.
+var bagItAlgorithms = []string { Sha256Algorithm, Sha1Algorithm }
+
+// BagIt manifests percent-encode these (in that order) rather than escaping
+// them the way sha1sum does.
+var (
+    bagItFilenameEncoder = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+    bagItFilenameDecoder = strings.NewReplacer("%25", "%", "%0D", "\r", "%0d", "\r", "%0A", "\n", "%0a", "\n")
+)
+
+var bagItManifestLineRx = regexp.MustCompile(`^([0-9A-Fa-f]+)[ \t]+(.+)$`)
+
+var ErrNotABag = errors.New("not a bag (no bagit.txt)")
+
+// A "Label: value" line from bagit.txt or bag-info.txt.
+type BagItTag struct {
+    Label string
+    Value string
+}
+
+func bagItManifestFilename(algorithm string) string {
+    return "manifest-" + algorithm + ".txt"
+}
+
+func bagItTagManifestFilename(algorithm string) string {
+    return "tagmanifest-" + algorithm + ".txt"
+}
+
+func writeBagItManifestLine(w io.Writer, hash string, relPath string) error {
+    _, err := fmt.Fprintf(w, "%s  %s\n", hash, bagItFilenameEncoder.Replace(relPath))
+    return err
+}
+
+// Writes the payload manifest and adds-up the Payload-Oxum as it goes.
+type bagItManifestWriter struct {
+    w io.Writer
+    octets int64
+    count int64
+}
+
+func (self *bagItManifestWriter) Begin(algorithm string, rootHash string) error {
+    return nil
+}
+
+func (self *bagItManifestWriter) Write(me *ManifestEntry) error {
+    if me.Size == nil {
+        return errors.New(fmt.Sprintf("File size not recorded: [%s]", me.RelPath))
+    }
+
+    self.octets += *me.Size
+    self.count++
+
+    return writeBagItManifestLine(self.w, me.Hash, path.Join(bagItPayloadDirectory, me.RelPath))
+}
+
+func (self *bagItManifestWriter) End() error {
+    return nil
+}
+
+func (self *bagItManifestWriter) IncludesPaths() bool {
+    return false
+}
+
+func (self *bagItManifestWriter) PayloadOxum() string {
+    return fmt.Sprintf("%d.%d", self.octets, self.count)
+}
+
+// Write a tag file. Values with newlines are continued on indented lines.
+func writeBagItTags(filepath string, tags []*BagItTag) (err error) {
+    f, err := os.Create(filepath)
+    if err != nil {
+        return err
+    }
+
+    defer f.Close()
+
+    for _, bt := range tags {
+        value := strings.Replace(bt.Value, "\n", "\n  ", -1)
+
+        _, err = fmt.Fprintf(f, "%s: %s\n", bt.Label, value)
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// Read a tag file. Lines starting with whitespace continue the value before
+// them.
+func readBagItTags(filepath string) (tags []*BagItTag, err error) {
+    l := NewLogger("bagit")
+
+    defer func() {
+        if r := recover(); r != nil {
+            tags = nil
+            err = r.(error)
+
+            l.Error("Could not read tag file", "filepath", filepath, "err", err)
+        }
+    }()
+
+    f, err := os.Open(filepath)
+    if err != nil {
+        panic(err)
+    }
+
+    defer f.Close()
+
+    tags = make([]*BagItTag, 0)
+
+    s := bufio.NewScanner(f)
+    for s.Scan() {
+        line := strings.TrimRight(s.Text(), "\r")
+        if strings.TrimSpace(line) == "" {
+            continue
+        }
+
+        if line[0] == ' ' || line[0] == '\t' {
+            if len(tags) == 0 {
+                panic(errors.New(fmt.Sprintf("Tag file starts with a continuation: [%s]", filepath)))
+            }
+
+            last := tags[len(tags) - 1]
+            last.Value += " " + strings.TrimSpace(line)
+
+            continue
+        }
+
+        parts := strings.SplitN(line, ":", 2)
+        if len(parts) != 2 {
+            panic(errors.New(fmt.Sprintf("Tag line not valid: [%s] [%s]", filepath, line)))
+        }
+
+        bt := &BagItTag {
+                Label: strings.TrimSpace(parts[0]),
+                Value: strings.TrimSpace(parts[1]),
+        }
+
+        tags = append(tags, bt)
+    }
+
+    err = s.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    return tags, nil
+}
+
+func findBagItTag(tags []*BagItTag, label string) (value string, found bool) {
+    for _, bt := range tags {
+        if strings.EqualFold(bt.Label, label) == true {
+            return bt.Value, true
+        }
+    }
+
+    return "", false
+}
+
+// Read a manifest or tag-manifest into a map of hashes by path (relative to
+// the bag).
+func readBagItManifest(filepath string) (hashes map[string]string, err error) {
+    l := NewLogger("bagit")
+
+    defer func() {
+        if r := recover(); r != nil {
+            hashes = nil
+            err = r.(error)
+
+            l.Error("Could not read BagIt manifest", "filepath", filepath, "err", err)
+        }
+    }()
+
+    f, err := os.Open(filepath)
+    if err != nil {
+        panic(err)
+    }
+
+    defer f.Close()
+
+    hashes = make(map[string]string)
+
+    s := bufio.NewScanner(f)
+    lineNumber := 0
+
+    for s.Scan() {
+        lineNumber++
+
+        line := strings.TrimRight(s.Text(), "\r")
+        if line == "" {
+            continue
+        }
+
+        matches := bagItManifestLineRx.FindStringSubmatch(line)
+        if matches == nil {
+            panic(errors.New(fmt.Sprintf("Manifest line (%d) not valid: [%s]", lineNumber, filepath)))
+        }
+
+        relPath, err := normalizeManifestRelPath(bagItFilenameDecoder.Replace(matches[2]))
+        if err != nil {
+            panic(err)
+        }
+
+        hashes[relPath] = strings.ToLower(matches[1])
+    }
+
+    err = s.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    return hashes, nil
+}
+
+// Hash the given tag files (the ones that exist).
+func hashBagItTagFiles(bagPath string, relPaths []string, hashAlgorithm *string) (actual map[string]string, err error) {
+    p := NewPath(hashAlgorithm, nil)
+    actual = make(map[string]string)
+
+    for _, relPath := range relPaths {
+        filepath := path.Join(bagPath, relPath)
+
+        if _, err := os.Stat(filepath); os.IsNotExist(err) == true {
+            continue
+        }
+
+        hash, err := p.GenerateFileHash(&filepath)
+        if err != nil {
+            return nil, err
+        }
+
+        actual[relPath] = hash
+    }
+
+    return actual, nil
+}
+
+// Write bagit.txt, bag-info.txt, and the manifest and tag-manifest for a bag
+// whose payload is the scan path. The payload is scanned into the catalog
+// first, so only the files that changed since the last scan are read, and
+// the Payload-Oxum comes from the sizes in the catalog. The scan path must be
+// the "data" directory of the bag. Any extra tags are added to bag-info.txt.
+// Returns the hash of the payload.
+func MakeBag(scanPath *string, bagPath *string, catalogFilepath *string, hashAlgorithm *string, extraTags []*BagItTag) (rootHash string, err error) {
+    l := NewLogger("bagit")
+
+    defer func() {
+        if r := recover(); r != nil {
+            rootHash = ""
+            err = r.(error)
+
+            l.Error("Could not make bag", "err", err)
+        }
+    }()
+
+    scanInfo, err := os.Stat(*scanPath)
+    if err != nil {
+        panic(err)
+    }
+
+    payloadInfo, err := os.Stat(path.Join(*bagPath, bagItPayloadDirectory))
+    if err != nil || os.SameFile(scanInfo, payloadInfo) == false {
+        panic(errors.New(fmt.Sprintf("The scan path must be the \"%s\" directory of the bag.", bagItPayloadDirectory)))
+    }
+
+    cr, rootHash, err := scanIntoCatalog(scanPath, catalogFilepath, hashAlgorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    defer cr.Close()
+
+    manifestFilename := bagItManifestFilename(*hashAlgorithm)
+
+    f, err := os.Create(path.Join(*bagPath, manifestFilename))
+    if err != nil {
+        panic(err)
+    }
+
+    defer f.Close()
+
+    bw := bufio.NewWriter(f)
+    bmw := &bagItManifestWriter {
+            w: bw,
+    }
+
+    relPath := ""
+    err = cr.ExportManifest(&relPath, bmw)
+    if err != nil {
+        panic(err)
+    }
+
+    err = bw.Flush()
+    if err != nil {
+        panic(err)
+    }
+
+    err = f.Close()
+    if err != nil {
+        panic(err)
+    }
+
+    declaration := []*BagItTag {
+        &BagItTag { Label: "BagIt-Version", Value: BagItVersion },
+        &BagItTag { Label: "Tag-File-Character-Encoding", Value: "UTF-8" },
+    }
+
+    err = writeBagItTags(path.Join(*bagPath, "bagit.txt"), declaration)
+    if err != nil {
+        panic(err)
+    }
+
+    info := []*BagItTag {
+        &BagItTag { Label: "Bagging-Date", Value: time.Now().Format("2006-01-02") },
+        &BagItTag { Label: "Payload-Oxum", Value: bmw.PayloadOxum() },
+    }
+
+    info = append(info, extraTags...)
+
+    err = writeBagItTags(path.Join(*bagPath, "bag-info.txt"), info)
+    if err != nil {
+        panic(err)
+    }
+
+    tagFilenames := []string { "bagit.txt", "bag-info.txt", manifestFilename }
+
+    tagHashes, err := hashBagItTagFiles(*bagPath, tagFilenames, hashAlgorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    tf, err := os.Create(path.Join(*bagPath, bagItTagManifestFilename(*hashAlgorithm)))
+    if err != nil {
+        panic(err)
+    }
+
+    defer tf.Close()
+
+    for _, filename := range tagFilenames {
+        err = writeBagItManifestLine(tf, tagHashes[filename], filename)
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    return rootHash, nil
+}
+
+type BagValidation struct {
+    Algorithm string
+    RootHash string
+
+    // The number of events.
+    Count int
+
+    // Empty if bag-info.txt doesn't have one.
+    ExpectedPayloadOxum string
+    PayloadOxum string
+}
+
+// Check a bag. The payload is scanned into the given catalog (which will be
+// created if it doesn't exist) and checked against the strongest manifest
+// that we support. The tag files are checked against the tag-manifest for the
+// same algorithm, if there is one. An event is emitted for every file that's
+// extra (create; payload only), missing (delete), or different (update),
+// with paths relative to the bag. The Payload-Oxum is returned for the
+// caller to compare.
+func ValidateBag(bagPath *string, catalogFilepath *string, cs ChangeSink) (bv *BagValidation, err error) {
+    l := NewLogger("bagit")
+
+    defer func() {
+        if r := recover(); r != nil {
+            bv = nil
+            err = r.(error)
+
+            l.Error("Could not validate bag", "err", err)
+        }
+    }()
+
+    declaration, err := readBagItTags(path.Join(*bagPath, "bagit.txt"))
+    if os.IsNotExist(err) == true {
+        panic(ErrNotABag)
+    } else if err != nil {
+        panic(err)
+    } else if _, found := findBagItTag(declaration, "BagIt-Version"); found == false {
+        panic(ErrNotABag)
+    }
+
+    bv = new(BagValidation)
+
+    for _, algorithm := range bagItAlgorithms {
+        if _, err := os.Stat(path.Join(*bagPath, bagItManifestFilename(algorithm))); err == nil {
+            bv.Algorithm = algorithm
+            break
+        }
+    }
+
+    if bv.Algorithm == "" {
+        panic(errors.New(fmt.Sprintf("Bag has no manifest for a supported algorithm (%s).", strings.Join(bagItAlgorithms, ", "))))
+    }
+
+    expected, err := readBagItManifest(path.Join(*bagPath, bagItManifestFilename(bv.Algorithm)))
+    if err != nil {
+        panic(err)
+    }
+
+    payloadPath := path.Join(*bagPath, bagItPayloadDirectory)
+
+    cr, rootHash, err := scanIntoCatalog(&payloadPath, catalogFilepath, &bv.Algorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    defer cr.Close()
+
+    bv.RootHash = rootHash
+
+    relPath := ""
+    root, err := cr.GetTree(&relPath, 0)
+    if err != nil {
+        panic(err)
+    }
+
+    payloadPaths := make(map[string]string)
+    payloadFiles := make(map[string]string)
+
+    collectTreeHashes(root, payloadPaths, payloadFiles)
+
+    actual := make(map[string]string)
+    for relPath, hash := range payloadFiles {
+        actual[path.Join(bagItPayloadDirectory, relPath)] = hash
+    }
+
+    // The size of a path is the total size of the files below it.
+    bv.PayloadOxum = fmt.Sprintf("%d.%d", root.Size, len(payloadFiles))
+
+    tagManifestFilepath := path.Join(*bagPath, bagItTagManifestFilename(bv.Algorithm))
+    if _, err := os.Stat(tagManifestFilepath); err == nil {
+        expectedTags, err := readBagItManifest(tagManifestFilepath)
+        if err != nil {
+            panic(err)
+        }
+
+        relPaths := make([]string, 0, len(expectedTags))
+        for relPath, _ := range expectedTags {
+            relPaths = append(relPaths, relPath)
+        }
+
+        actualTags, err := hashBagItTagFiles(*bagPath, relPaths, &bv.Algorithm)
+        if err != nil {
+            panic(err)
+        }
+
+        for relPath, hash := range expectedTags {
+            expected[relPath] = hash
+        }
+
+        for relPath, hash := range actualTags {
+            actual[relPath] = hash
+        }
+    }
+
+    if info, err := readBagItTags(path.Join(*bagPath, "bag-info.txt")); err == nil {
+        bv.ExpectedPayloadOxum, _ = findBagItTag(info, "Payload-Oxum")
+    } else if os.IsNotExist(err) == false {
+        panic(err)
+    }
+
+    bv.Count = compareHashSets(EntityTypeFile, expected, actual, cs)
+
+    return bv, nil
+}
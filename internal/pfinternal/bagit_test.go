@@ -0,0 +1,110 @@
+package pfinternal
+
+import (
+    "testing"
+    "os"
+    "path"
+    "bytes"
+    "strings"
+
+    "io/ioutil"
+)
+
+func TestMakeAndValidateBag(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    bagPath := createTempPath(tempPath, "bag")
+
+    cleanup := func() {
+        os.RemoveAll(bagPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    payloadPath := path.Join(bagPath, "data")
+    os.MkdirAll(path.Join(payloadPath, "sub"), 0755)
+
+    ioutil.WriteFile(path.Join(payloadPath, "aa"), []byte("aa"), 0644)
+    ioutil.WriteFile(path.Join(payloadPath, "sub", "b%b"), []byte("bbb"), 0644)
+
+    hashAlgorithm := Sha256Algorithm
+    extraTags := []*BagItTag {
+        &BagItTag { Label: "Source-Organization", Value: "Example" },
+    }
+
+    rootHash, err := MakeBag(&payloadPath, &bagPath, &catalogFilepath, &hashAlgorithm, extraTags)
+    if err != nil {
+        t.Fatalf("Could not make bag.")
+    }
+
+    manifest, err := ioutil.ReadFile(path.Join(bagPath, "manifest-sha256.txt"))
+    if err != nil {
+        t.Fatalf("Could not read manifest.")
+    } else if strings.Contains(string(manifest), "  data/sub/b%25b\n") == false {
+        t.Fatalf("Manifest not correct: [%s]", manifest)
+    }
+
+    validate := func() (*BagValidation, string) {
+        b := new(bytes.Buffer)
+        rcs := NewReportChangeSink(b, true)
+
+        bv, err := ValidateBag(&bagPath, &catalogFilepath, rcs)
+        if err != nil {
+            t.Fatalf("Could not validate bag.")
+        }
+
+        err = rcs.End()
+        if err != nil {
+            t.Fatalf("Could not end report.")
+        }
+
+        return bv, b.String()
+    }
+
+    bv, report := validate()
+    if bv.Count != 0 || report != "" {
+        t.Fatalf("Bag not valid: [%s]", report)
+    } else if bv.Algorithm != Sha256Algorithm {
+        t.Fatalf("Algorithm not correct: [%s]", bv.Algorithm)
+    } else if bv.RootHash != rootHash {
+        t.Fatalf("Root hash not correct.")
+    } else if bv.ExpectedPayloadOxum != "5.2" || bv.PayloadOxum != "5.2" {
+        t.Fatalf("Payload-Oxum not correct: [%s] [%s]", bv.ExpectedPayloadOxum, bv.PayloadOxum)
+    }
+
+    ioutil.WriteFile(path.Join(payloadPath, "aa"), []byte("changed"), 0644)
+    ioutil.WriteFile(path.Join(payloadPath, "zz"), []byte("zz"), 0644)
+    ioutil.WriteFile(path.Join(bagPath, "bag-info.txt"), []byte("Payload-Oxum: 5.2\n"), 0644)
+
+    bv, report = validate()
+
+    expected := "update file bag-info.txt\nupdate file data/aa\ncreate file data/zz\n"
+    if report != expected {
+        t.Fatalf("Problems not correct: [%s]", report)
+    } else if bv.PayloadOxum != "12.3" {
+        t.Fatalf("Payload-Oxum not correct: [%s]", bv.PayloadOxum)
+    }
+}
+
+func TestValidateBagNotABag(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    bagPath := createTempPath(tempPath, "bag")
+
+    cleanup := func() {
+        os.RemoveAll(bagPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    _, err := ValidateBag(&bagPath, &catalogFilepath, nil)
+    if err != ErrNotABag {
+        t.Fatalf("Expected not-a-bag error: %v", err)
+    }
+}
@@ -15,6 +15,30 @@ const (
 var ErrNoHash = errors.New("no hash recorded for the filename")
 var ErrFileChanged = errors.New("mtime for filename does not match")
 
+// Returned by the catalog lookups when nothing is recorded for the given 
+// path. If the parent path of a file isn't recorded, we return 
+// ErrPathNotFound rather than ErrFileNotFound.
+var ErrPathNotFound = errors.New("path not found in catalog")
+var ErrFileNotFound = errors.New("file not found in catalog")
+var ErrRootNotRecorded = errors.New("root path not recorded in catalog")
+
+// Returned when a catalog is opened with a different algorithm than the one it 
+// was built with.
+var ErrAlgorithmMismatch = errors.New("catalog was built with a different hash algorithm")
+
+// Returned when something can only be written to a new catalog.
+var ErrCatalogNotEmpty = errors.New("catalog is not empty")
+
+// Returned when the catalog's history doesn't have the requested scan.
+var ErrScanNotFound = errors.New("scan not found in catalog history")
+
+// Returned when a catalog is grafted into itself.
+var ErrGraftIntoSelf = errors.New("catalog can not be grafted into itself")
+
+// Returned when a catalog is opened read-only but has an older schema. Only
+// the tools that write to a catalog upgrade it.
+var ErrSchemaOutdated = errors.New("catalog has an older schema (scan it with pfhash to upgrade it)")
+
 type Catalog struct {
     scanPath string
     allowUpdates bool
@@ -23,12 +47,13 @@ type Catalog struct {
     pd pathDescriptor
     nowTime time.Time
     nowEpoch int64
-    reportingChannel chan<- *ChangeEvent
+    changeSink ChangeSink
+    visited *visitedRecords
     cc *catalogCommon
     cr *catalogResource
 }
 
-func NewCatalog(catalogResource *catalogResource, scanPath *string, allowUpdates bool, hashAlgorithm *string, reportingChannel chan<- *ChangeEvent) (cp *Catalog, err error) {
+func NewCatalog(catalogResource *catalogResource, scanPath *string, allowUpdates bool, hashAlgorithm *string, changeSink ChangeSink) (cp *Catalog, err error) {
     l := NewLogger("catalog")
 
     defer func() {
@@ -46,17 +71,30 @@ func NewCatalog(catalogResource *catalogResource, scanPath *string, allowUpdates
         panic(err)
     }
 
+    if allowUpdates == true {
+        err = catalogResource.recordHashAlgorithm()
+        if err != nil {
+            panic(err)
+        }
+    }
+
     nowTime := time.Now()
     nowEpoch := nowTime.Unix()
 
     l.Debug("Current time.", "nowEpoch", nowEpoch)
 
+    var visited *visitedRecords
+    if allowUpdates == false {
+        visited = newVisitedRecords()
+    }
+
     c := Catalog { 
             scanPath: *scanPath, 
             allowUpdates: allowUpdates,
             nowTime: nowTime,
             nowEpoch: nowEpoch,
-            reportingChannel: reportingChannel,
+            changeSink: changeSink,
+            visited: visited,
             cc: cc,
             cr: catalogResource,
     }
@@ -93,7 +131,8 @@ func (self *Catalog) BranchCatalog(childPathName *string) (*Catalog, error) {
             pd: *pd,
             nowTime: self.nowTime,
             nowEpoch: self.nowEpoch,
-            reportingChannel: self.reportingChannel,
+            changeSink: self.changeSink,
+            visited: self.visited,
             cc: self.cc,
             cr: self.cr,
     }
@@ -101,10 +140,28 @@ func (self *Catalog) BranchCatalog(childPathName *string) (*Catalog, error) {
     return &c, nil
 }
 
-func (self *Catalog) getLastHash() *string {
+// Return the hash that was recorded for this path before the current run, or 
+// nil if it wasn't recorded.
+func (self *Catalog) LastHash() *string {
     return self.lastHash
 }
 
+// Send a change to the sink. The caller must check that there is one.
+func (self *Catalog) reportChange(entityType int, changeType int, relPath string, hash string, previousHash string) {
+    ce := &ChangeEvent {
+            EntityType: entityType,
+            ChangeType: changeType,
+            RelPath: relPath,
+            Hash: hash,
+            PreviousHash: previousHash,
+    }
+
+    err := self.changeSink.Event(ce)
+    if err != nil {
+        panic(err)
+    }
+}
+
 func (self *Catalog) ensurePathRecord(relPath *string) (*pathDescriptor, *string, error) {
     l := NewLogger("catalog")
 
@@ -129,6 +186,13 @@ func (self *Catalog) ensurePathRecord(relPath *string) (*pathDescriptor, *string
 
         pd = newRecordedPathDescriptor(relPath, pathInfoId)
     } else {
+        // We can't create the record, but the report should still look like 
+        // it would have if we could have.
+
+        if self.changeSink != nil {
+            self.reportChange(EntityTypePath, UpdateTypeCreate, *relPath, "", "")
+        }
+
         pd = newUnknownPathDescriptor(relPath)
     }
 
@@ -155,16 +219,14 @@ func (self *Catalog) Cleanup() (err error) {
         }
     }()
 
-    if self.allowUpdates == true {
-        err := self.PruneOldFiles()
-        if err != nil {
-            panic(err)
-        }
+    err = self.PruneOldFiles()
+    if err != nil {
+        panic(err)
+    }
 
-        err = self.PruneOldPaths()
-        if err != nil {
-            panic(err)
-        }
+    err = self.PruneOldPaths()
+    if err != nil {
+        panic(err)
     }
 
     return nil
@@ -209,6 +271,10 @@ func (self *Catalog) lookupFile(filename *string) (flrp *fileLookupResult, err e
             if err != nil {
                 panic(err)
             }
+        } else if flrp.wasFound == true {
+            // We can't touch the record, so just remember that we saw it.
+
+            self.visited.fileIds[flrp.entry.id] = true
         }
     }
 
@@ -249,6 +315,10 @@ func (self *Catalog) lookupPath(relPath *string) (plrp *pathLookupResult, err er
         if err != nil {
             panic(err)
         }
+    } else if plrp.wasFound == true {
+        // We can't touch the record, so just remember that we saw it.
+
+        self.visited.pathIds[plrp.entry.id] = true
     }
 
     l.Debug("Path lookup finished.", "relPath", *relPath)
@@ -256,7 +326,7 @@ func (self *Catalog) lookupPath(relPath *string) (plrp *pathLookupResult, err er
     return plrp, nil
 }
 
-func (self *Catalog) setFile(flrp *fileLookupResult, mtime int64, hash *string) (err error) {
+func (self *Catalog) setFile(flrp *fileLookupResult, mtime int64, size int64, hash *string) (err error) {
     l := NewLogger("catalog")
 
     defer func() {
@@ -266,26 +336,18 @@ func (self *Catalog) setFile(flrp *fileLookupResult, mtime int64, hash *string)
         }
     }()
 
-    if self.reportingChannel != nil {
+    if self.changeSink != nil {
         relFilepath := path.Join(self.pd.GetRelPath(), flrp.filename)
 
         if flrp.wasFound == true {
-            self.reportingChannel <- &ChangeEvent { 
-                    EntityType: EntityTypeFile, 
-                    ChangeType: UpdateTypeUpdate, 
-                    RelPath: relFilepath,
-            }
+            self.reportChange(EntityTypeFile, UpdateTypeUpdate, relFilepath, *hash, flrp.entry.hash)
         } else {
-            self.reportingChannel <- &ChangeEvent {
-                    EntityType: EntityTypeFile,
-                    ChangeType: UpdateTypeCreate,
-                    RelPath: relFilepath,
-            }
+            self.reportChange(EntityTypeFile, UpdateTypeCreate, relFilepath, *hash, "")
         }
     }
 
     if self.allowUpdates == true {
-        err = self.cr.setFile(flrp, mtime, hash, self.nowEpoch)
+        err = self.cr.setFile(flrp, mtime, size, hash, self.nowEpoch)
         if err != nil {
             panic(err)
         }
@@ -294,6 +356,30 @@ func (self *Catalog) setFile(flrp *fileLookupResult, mtime int64, hash *string)
     return nil
 }
 
+// Record a new mtime and size for a file whose content didn't change. This 
+// isn't reported.
+func (self *Catalog) setFileStat(flrp *fileLookupResult, mtime int64, size int64) (err error) {
+    l := NewLogger("catalog")
+
+    defer func() {
+        if r := recover(); r != nil {
+            err = r.(error)
+            l.Error("Could not set file stat.", "err", err)
+        }
+    }()
+
+    if self.allowUpdates == false {
+        return nil
+    }
+
+    err = self.cr.updateFileStat(flrp, mtime, size)
+    if err != nil {
+        panic(err)
+    }
+
+    return nil
+}
+
 // Create a new path record for the given path.
 func (self *Catalog) createPath(relPath *string) (pathInfoId int, err error) {
     l := NewLogger("catalog")
@@ -307,12 +393,8 @@ func (self *Catalog) createPath(relPath *string) (pathInfoId int, err error) {
         }
     }()
 
-    if self.reportingChannel != nil {
-        self.reportingChannel <- &ChangeEvent {
-                EntityType: EntityTypePath,
-                ChangeType: UpdateTypeCreate,
-                RelPath: *relPath,
-        }
+    if self.changeSink != nil {
+        self.reportChange(EntityTypePath, UpdateTypeCreate, *relPath, "", "")
     }
 
     // This should never come up.
@@ -339,12 +421,13 @@ func (self *Catalog) updatePath(hash *string) (err error) {
         }
     }()
 
-    if self.reportingChannel != nil {
-        self.reportingChannel <- &ChangeEvent {
-                EntityType: EntityTypePath,
-                ChangeType: UpdateTypeUpdate,
-                RelPath: self.pd.GetRelPath(),
+    if self.changeSink != nil {
+        previousHash := ""
+        if self.lastHash != nil {
+            previousHash = *self.lastHash
         }
+
+        self.reportChange(EntityTypePath, UpdateTypeUpdate, self.pd.GetRelPath(), *hash, previousHash)
     }
 
     if self.allowUpdates == false {
@@ -352,7 +435,7 @@ func (self *Catalog) updatePath(hash *string) (err error) {
         return nil
     }
 
-    err = self.cr.updatePath(&self.pd, hash)
+    err = self.cr.updatePath(&self.pd, hash, self.nowEpoch)
     if err != nil {
         panic(err)
     }
@@ -360,9 +443,21 @@ func (self *Catalog) updatePath(hash *string) (err error) {
     return nil
 }
 
+// Record how many symlinks the path that this catalog object represents has. 
+// They aren't recorded themselves.
+func (self *Catalog) setSymlinkCount(symlinkCount int) (err error) {
+    if self.allowUpdates == false || self.pd.GetPathInfoId() == 0 {
+        return nil
+    }
+
+    return self.cr.updatePathSymlinkCount(&self.pd, symlinkCount)
+}
+
 // Delete all file records that haven't been touched in this run (because all 
 // of the ones that match known files have been updated to a later timestamp 
 // than they had).
+// If we're not allowed to make updates, just report the ones that we didn't 
+// encounter.
 func (self *Catalog) PruneOldFiles() (err error) {
     l := NewLogger("catalog")
 
@@ -374,11 +469,23 @@ func (self *Catalog) PruneOldFiles() (err error) {
     }()
 
     if self.allowUpdates == false {
-        l.Debug("Not checking for FILE deletions since we're not allowed to make updates.")
+        if self.changeSink == nil {
+            l.Debug("Not checking for FILE deletions since we're not allowed to make updates and we're not reporting.")
+            return nil
+        }
+
+        // We can't delete anything, but we can still report what would've 
+        // been deleted.
+
+        err = self.cr.pushUnvisitedFiles(self.visited.fileIds, self.changeSink)
+        if err != nil {
+            panic(err)
+        }
+
         return nil
     }
 
-    err = self.cr.pruneOldFiles(self.nowEpoch, self.reportingChannel)
+    err = self.cr.pruneOldFiles(self.nowEpoch, self.changeSink)
     if err != nil {
         panic(err)
     }
@@ -389,6 +496,8 @@ func (self *Catalog) PruneOldFiles() (err error) {
 // Delete all path records that haven't been touched in this run (because all 
 // of the ones that match known files have been updated to a later timestamp 
 // than they had).
+// If we're not allowed to make updates, just report the ones that we didn't 
+// encounter.
 func (self *Catalog) PruneOldPaths() (err error) {
     l := NewLogger("catalog")
 
@@ -400,11 +509,23 @@ func (self *Catalog) PruneOldPaths() (err error) {
     }()
 
     if self.allowUpdates == false {
-        l.Debug("Not checking for PATH deletions since we're not allowed to make updates.")
+        if self.changeSink == nil {
+            l.Debug("Not checking for PATH deletions since we're not allowed to make updates and we're not reporting.")
+            return nil
+        }
+
+        // We can't delete anything, but we can still report what would've 
+        // been deleted.
+
+        err = self.cr.pushUnvisitedPaths(self.visited.pathIds, self.changeSink)
+        if err != nil {
+            panic(err)
+        }
+
         return nil
     }
 
-    err = self.cr.pruneOldPaths(self.nowEpoch, self.reportingChannel)
+    err = self.cr.pruneOldPaths(self.nowEpoch, self.changeSink)
     if err != nil {
         panic(err)
     }
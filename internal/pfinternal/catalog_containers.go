@@ -34,19 +34,28 @@ func (self *pathDescriptor) GetPathInfoId() int {
     return self.pathInfoId
 }
 
+// The size recorded for files in catalogs from before we recorded sizes.
+const UnknownFileSize = -1
+
+// The symlink count of paths that haven't been scanned since we started 
+// counting them.
+const UnknownSymlinkCount = -1
+
 // Represents a file record in the DB.
 // TODO(dustin): Rename to fileEntry.
 type catalogEntry struct {
     id int
     hash string
     mtime int64
+    size int64
 }
 
-func newCatalogEntry(id int, hash *string, mtime int64) *catalogEntry {
+func newCatalogEntry(id int, hash *string, mtime int64, size int64) *catalogEntry {
     ce := catalogEntry {
             id: id,
             hash: *hash,
             mtime: mtime,
+            size: size,
     }
 
     return &ce
@@ -121,3 +130,20 @@ func newFoundPathLookupResult(relPath *string, entry *pathEntry) *pathLookupResu
 
     return &plr
 }
+
+// Tracks the records that we've encountered during a run in which we're not 
+// allowed to update the catalog. Since we can't bump the check-timestamps, this 
+// is how we determine what was deleted.
+type visitedRecords struct {
+    fileIds map[int]bool
+    pathIds map[int]bool
+}
+
+func newVisitedRecords() *visitedRecords {
+    vr := visitedRecords {
+            fileIds: make(map[int]bool),
+            pathIds: make(map[int]bool),
+    }
+
+    return &vr
+}
@@ -0,0 +1,186 @@
+package pfinternal
+
+import (
+    "sort"
+    "path"
+)
+
+// Compares two catalogs, entirely from what they've recorded.
+type catalogDiff struct {
+    from *catalogResource
+    to *catalogResource
+    cs ChangeSink
+    count int
+}
+
+func (self *catalogDiff) emit(entityType int, changeType int, relPath string) {
+    self.count++
+
+    ce := &ChangeEvent {
+            EntityType: entityType,
+            ChangeType: changeType,
+            RelPath: relPath,
+    }
+
+    err := self.cs.Event(ce)
+    if err != nil {
+        panic(err)
+    }
+}
+
+// Emit an event for the given path and for everything below it.
+func (self *catalogDiff) emitTree(node *CatalogTreeNode, changeType int) {
+    if node.IsPath == true {
+        self.emit(EntityTypePath, changeType, node.RelPath)
+    } else {
+        self.emit(EntityTypeFile, changeType, node.RelPath)
+    }
+
+    for _, child := range node.Children {
+        self.emitTree(child, changeType)
+    }
+}
+
+func (self *catalogDiff) emitSubtree(cr *catalogResource, relPath string, changeType int) {
+    root, err := cr.GetTree(&relPath, 0)
+    if err != nil {
+        panic(err)
+    }
+
+    self.emitTree(root, changeType)
+}
+
+func sortedChildNames(a map[string]*childEntry, b map[string]*childEntry) []string {
+    names := make([]string, 0)
+
+    for name, _ := range a {
+        names = append(names, name)
+    }
+
+    for name, _ := range b {
+        if _, found := a[name]; found == false {
+            names = append(names, name)
+        }
+    }
+
+    sort.Strings(names)
+
+    return names
+}
+
+// Compare a path that's in both catalogs. If the hashes match, nothing below
+// it can be different and we don't have to look any further.
+func (self *catalogDiff) diffPath(relPath string, fromEntry *childEntry, toEntry *childEntry) {
+    if fromEntry.hash == toEntry.hash && fromEntry.hash != "" {
+        return
+    }
+
+    self.emit(EntityTypePath, UpdateTypeUpdate, relPath)
+
+    fromPaths, fromFiles, err := self.from.listImmediateChildren(fromEntry.id, relPath)
+    if err != nil {
+        panic(err)
+    }
+
+    toPaths, toFiles, err := self.to.listImmediateChildren(toEntry.id, relPath)
+    if err != nil {
+        panic(err)
+    }
+
+    for _, filename := range sortedChildNames(fromFiles, toFiles) {
+        childRelPath := path.Join(relPath, filename)
+
+        fromFile, inFrom := fromFiles[filename]
+        toFile, inTo := toFiles[filename]
+
+        if inFrom == false {
+            self.emit(EntityTypeFile, UpdateTypeCreate, childRelPath)
+        } else if inTo == false {
+            self.emit(EntityTypeFile, UpdateTypeDelete, childRelPath)
+        } else if fromFile.hash != toFile.hash {
+            self.emit(EntityTypeFile, UpdateTypeUpdate, childRelPath)
+        }
+    }
+
+    for _, name := range sortedChildNames(fromPaths, toPaths) {
+        childRelPath := path.Join(relPath, name)
+
+        fromPath, inFrom := fromPaths[name]
+        toPath, inTo := toPaths[name]
+
+        if inFrom == false {
+            self.emitSubtree(self.to, childRelPath, UpdateTypeCreate)
+        } else if inTo == false {
+            self.emitSubtree(self.from, childRelPath, UpdateTypeDelete)
+        } else {
+            self.diffPath(childRelPath, fromPath, toPath)
+        }
+    }
+}
+
+// Emit an event to the sink for every path and file that was created,
+// updated, or deleted going from one catalog to the other, at or below the
+// given path. The paths are compared top-down and identical paths aren't
+// descended into, so unchanged trees are cheap. The catalogs must use the
+// same algorithm. Returns the number of events.
+func DiffCatalogs(from *catalogResource, to *catalogResource, relPath *string, cs ChangeSink) (count int, err error) {
+    l := NewLogger("catalog_diff")
+
+    defer func() {
+        if r := recover(); r != nil {
+            count = 0
+            err = r.(error)
+
+            l.Error("Could not diff catalogs", "err", err)
+        }
+    }()
+
+    if *from.cc.HashAlgorithm() != *to.cc.HashAlgorithm() {
+        panic(ErrAlgorithmMismatch)
+    }
+
+    normalRelPath := cleanRelPath(*relPath)
+    relPath = &normalRelPath
+
+    cd := &catalogDiff {
+            from: from,
+            to: to,
+            cs: cs,
+    }
+
+    fromPlr, err := from.lookupPath(relPath)
+    if err != nil {
+        panic(err)
+    }
+
+    toPlr, err := to.lookupPath(relPath)
+    if err != nil {
+        panic(err)
+    }
+
+    if fromPlr.wasFound == false && toPlr.wasFound == false {
+        if *relPath == "" {
+            panic(ErrRootNotRecorded)
+        }
+
+        panic(ErrPathNotFound)
+    } else if fromPlr.wasFound == false {
+        cd.emitSubtree(to, *relPath, UpdateTypeCreate)
+    } else if toPlr.wasFound == false {
+        cd.emitSubtree(from, *relPath, UpdateTypeDelete)
+    } else {
+        fromEntry := &childEntry {
+                id: fromPlr.entry.id,
+                hash: fromPlr.entry.hash,
+        }
+
+        toEntry := &childEntry {
+                id: toPlr.entry.id,
+                hash: toPlr.entry.hash,
+        }
+
+        cd.diffPath(*relPath, fromEntry, toEntry)
+    }
+
+    return cd.count, nil
+}
@@ -0,0 +1,165 @@
+package pfinternal
+
+import (
+    "testing"
+    "os"
+    "path"
+    "bytes"
+    "strings"
+)
+
+func TestDiffCatalogs(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    fromCatalogFilepath := createTempFile(tempPath)
+    toCatalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(fromCatalogFilepath)
+        os.Remove(toCatalogFilepath)
+    }
+
+    defer cleanup()
+
+    dir1Path := path.Join(scanPath, "dir1")
+    dir2Path := path.Join(scanPath, "dir2")
+    dir3Path := path.Join(scanPath, "dir3")
+
+    os.Mkdir(dir1Path, 0755)
+    os.Mkdir(dir2Path, 0755)
+
+    createSpecificFile(scanPath, "aa")
+    createSpecificFile(dir1Path, "bb")
+    createSpecificFile(dir2Path, "cc")
+
+    scanAndCollectChanges(t, fromCatalogFilepath, scanPath, true)
+
+    os.RemoveAll(dir2Path)
+    os.Mkdir(dir3Path, 0755)
+    createSpecificFile(dir3Path, "dd")
+    createSpecificFile(scanPath, "ee")
+
+    scanAndCollectChanges(t, toCatalogFilepath, scanPath, true)
+
+    hashAlgorithm := HashAlgorithm
+
+    from, err := NewCatalogResource(&fromCatalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not create from-catalog resource.")
+    }
+
+    err = from.Open()
+    if err != nil {
+        t.Fatalf("Could not open from-catalog.")
+    }
+
+    defer from.Close()
+
+    to, err := NewCatalogResource(&toCatalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not create to-catalog resource.")
+    }
+
+    err = to.Open()
+    if err != nil {
+        t.Fatalf("Could not open to-catalog.")
+    }
+
+    defer to.Close()
+
+    b := new(bytes.Buffer)
+    rcs := NewReportChangeSink(b, true)
+
+    relPath := ""
+    count, err := DiffCatalogs(from, to, &relPath, rcs)
+    if err != nil {
+        t.Fatalf("Could not diff catalogs.")
+    }
+
+    err = rcs.End()
+    if err != nil {
+        t.Fatalf("Could not end report.")
+    }
+
+    // "dir1" didn't change, so it's not looked into.
+    expected := strings.Join([]string {
+        "update path .",
+        "delete path dir2",
+        "delete file dir2/cc",
+        "create path dir3",
+        "create file dir3/dd",
+        "create file ee",
+    }, "\n") + "\n"
+
+    if b.String() != expected {
+        t.Fatalf("Diff not correct:\n%s", b.String())
+    } else if count != 6 {
+        t.Fatalf("Diff count not correct: (%d)", count)
+    }
+
+    b.Reset()
+    rcs = NewReportChangeSink(b, true)
+
+    count, err = DiffCatalogs(from, from, &relPath, rcs)
+    if err != nil {
+        t.Fatalf("Could not diff catalog against itself.")
+    } else if count != 0 {
+        t.Fatalf("Expected no differences between a catalog and itself: (%d)", count)
+    }
+
+    // The path is cleaned first, like it is for lookups.
+    b.Reset()
+    rcs = NewReportChangeSink(b, true)
+
+    relPath = "./dir3/"
+    count, err = DiffCatalogs(from, to, &relPath, rcs)
+    if err != nil {
+        t.Fatalf("Could not diff catalogs with an unclean path.")
+    }
+
+    err = rcs.End()
+    if err != nil {
+        t.Fatalf("Could not end report.")
+    }
+
+    expected = "create path dir3\ncreate file dir3/dd\n"
+    if b.String() != expected {
+        t.Fatalf("Diff with an unclean path not correct:\n%s", b.String())
+    } else if count != 2 {
+        t.Fatalf("Diff count with an unclean path not correct: (%d)", count)
+    }
+}
+
+func TestOpenWithDifferentAlgorithm(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    createSpecificFile(scanPath, "aa")
+
+    scanAndCollectChanges(t, catalogFilepath, scanPath, true)
+
+    hashAlgorithm := Sha256Algorithm
+
+    cr, err := NewCatalogResource(&catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not create catalog-resource.")
+    }
+
+    err = cr.Open()
+    if err != ErrAlgorithmMismatch {
+        t.Fatalf("Expected algorithm mismatch: %v", err)
+    }
+}
@@ -13,13 +13,78 @@ import (
 )
 
 const (
-    CurrentSchemaVersion = 2
+    CurrentSchemaVersion = 8
 )
 
+// The history tables. These are only written to if history has been enabled 
+// for the catalog, but they always exist.
+var historyTableQueries = []string {
+    "CREATE TABLE IF NOT EXISTS `scans` (\n" +
+        "`scan_id` INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, \n" +
+        "`start_epoch` INTEGER UNSIGNED NOT NULL, \n" +
+        "`end_epoch` INTEGER UNSIGNED NULL, \n" +
+        "`root_hash` VARCHAR(64) NULL, \n" +
+        "`create_count` INTEGER UNSIGNED NOT NULL DEFAULT 0, \n" +
+        "`update_count` INTEGER UNSIGNED NOT NULL DEFAULT 0, \n" +
+        "`delete_count` INTEGER UNSIGNED NOT NULL DEFAULT 0\n" +
+    ")\n",
+    "CREATE TABLE IF NOT EXISTS `scan_changes` (\n" +
+        "`scan_change_id` INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, \n" +
+        "`scan_id` INTEGER NOT NULL, \n" +
+        "`entity_type` VARCHAR(10) NOT NULL, \n" +
+        "`change_type` VARCHAR(10) NOT NULL, \n" +
+        "`rel_path` VARCHAR(1000) NOT NULL, \n" +
+        "`hash` VARCHAR(64) NULL, \n" +
+        "`previous_hash` VARCHAR(64) NULL, \n" +
+        "CONSTRAINT `scan_changes_scan_id_fk` FOREIGN KEY (`scan_id`) REFERENCES `scans` (`scan_id`)\n" +
+    ")\n",
+    "CREATE INDEX IF NOT EXISTS scan_changes_scan_id_idx ON `scan_changes`(`scan_id` ASC)",
+    "CREATE INDEX IF NOT EXISTS scan_changes_rel_path_idx ON `scan_changes`(`rel_path` ASC)",
+}
+
+// Baseline scans record every entry in the catalog rather than what changed, 
+// so that replaying the history can start from them. The first scan that was 
+// ever recorded was always either a baseline or a scan of an empty catalog.
+var scanBaselineQueries = []string {
+    "ALTER TABLE `scans` ADD COLUMN `is_baseline` INTEGER NOT NULL DEFAULT 0",
+    "UPDATE `scans` SET `is_baseline` = 1 WHERE `scan_id` = (SELECT MIN(`scan_id`) FROM `scans`)",
+}
+
+// The statements that bring a catalog up to each schema version from the one 
+// before it. Newly-created catalogs already have the current schema.
+var schemaUpgrades = map[int][]string {
+    3: []string {
+        "ALTER TABLE `files` ADD COLUMN `size` INTEGER UNSIGNED NULL",
+        "ALTER TABLE `files` ADD COLUMN `last_change_epoch` INTEGER UNSIGNED NULL DEFAULT 0",
+        "ALTER TABLE `paths` ADD COLUMN `last_change_epoch` INTEGER UNSIGNED NULL DEFAULT 0",
+    },
+    4: []string {
+        "CREATE INDEX IF NOT EXISTS paths_hash_idx ON `paths`(`hash` ASC)",
+        "CREATE INDEX IF NOT EXISTS files_hash_idx ON `files`(`hash` ASC)",
+    },
+    // File hashes used to include stale data from the read buffer. Clearing 
+    // the mtimes makes the next scan rehash everything.
+    5: []string {
+        "UPDATE `files` SET `mtime_epoch` = 0",
+    },
+    6: historyTableQueries,
+    7: scanBaselineQueries,
+    // Symlinks count towards path hashes but aren't recorded, so we need to 
+    // know which paths have them to know which hashes can be recalculated 
+    // from the catalog. Existing paths are unknown until they're scanned.
+    8: []string {
+        "ALTER TABLE `paths` ADD COLUMN `symlink_count` INTEGER UNSIGNED NULL",
+    },
+}
+
 type catalogResource struct {
     catalogFilepath *string
     db *sql.DB
     cc *catalogCommon
+
+    // Catalogs from before we recorded the algorithm only get it recorded by
+    // a scan that's allowed to update them.
+    isAlgorithmRecorded bool
 }
 
 func NewCatalogResource(catalogFilepath *string, hashAlgorithm *string) (cr *catalogResource, err error) {
@@ -50,7 +115,20 @@ func NewCatalogResource(catalogFilepath *string, hashAlgorithm *string) (cr *cat
 // TODO(dustin): We need to be able to tell Open() to not make any changes (in 
 //               no-updates mode).
 
+// Open the catalog, creating it if it's new and upgrading its schema if it's
+// older.
 func (self *catalogResource) Open() (err error) {
+    return self.open(false)
+}
+
+// Open the catalog without writing anything to it. A catalog with an older
+// schema isn't upgraded (that's left to the tools that write to it), so
+// ErrSchemaOutdated is returned for it.
+func (self *catalogResource) OpenReadOnly() (err error) {
+    return self.open(true)
+}
+
+func (self *catalogResource) open(isReadOnly bool) (err error) {
     l := NewLogger("catalog_resource")
 
     defer func() {
@@ -62,7 +140,7 @@ func (self *catalogResource) Open() (err error) {
 
     var db *sql.DB
 
-    l.Debug("Opening catalog resource.")
+    l.Debug("Opening catalog resource.", "isReadOnly", isReadOnly)
 
     if self.db != nil {
         panic(errors.New("Connection already opened."))
@@ -75,6 +153,30 @@ func (self *catalogResource) Open() (err error) {
         panic(err)
     }
 
+    if isReadOnly == true {
+        version, err := self.getSchemaVersion(db)
+        if err != nil {
+            db.Close()
+            panic(err)
+        } else if version < CurrentSchemaVersion {
+            db.Close()
+            panic(ErrSchemaOutdated)
+        } else if version > CurrentSchemaVersion {
+            db.Close()
+            panic(errors.New(fmt.Sprintf("Catalog schema (%d) is newer than we support (%d).", version, CurrentSchemaVersion)))
+        }
+
+        err = self.checkHashAlgorithm(db)
+        if err != nil {
+            db.Close()
+            panic(err)
+        }
+
+        self.db = db
+
+        return nil
+    }
+
     query := 
         "CREATE TABLE `catalog_info` (\n" +
             "`catalog_info_id` INTEGER NOT NULL PRIMARY KEY, \n" +
@@ -87,6 +189,8 @@ func (self *catalogResource) Open() (err error) {
         panic(err)
     }
 
+    isNewCatalog := wasCreated
+
     if wasCreated == true {
         query := 
             "INSERT INTO `catalog_info` " +
@@ -113,6 +217,8 @@ func (self *catalogResource) Open() (err error) {
             "`rel_path` VARCHAR(1000) NOT NULL, \n" +
             "`hash` VARCHAR(" + strconv.Itoa(h.Size() * 2) + ") NULL, \n" +
             "`last_check_epoch` INTEGER UNSIGNED NULL DEFAULT 0, \n" +
+            "`last_change_epoch` INTEGER UNSIGNED NULL DEFAULT 0, \n" +
+            "`symlink_count` INTEGER UNSIGNED NULL, \n" +
             "CONSTRAINT `paths_rel_path_idx` UNIQUE (`rel_path`)\n" +
         ")\n"
 
@@ -126,6 +232,11 @@ func (self *catalogResource) Open() (err error) {
         if err != nil {
             panic(err)
         }
+
+        err = self.createIndex(db, "paths_hash_idx", "paths", "hash", true)
+        if err != nil {
+            panic(err)
+        }
     }
 
     query = 
@@ -135,7 +246,9 @@ func (self *catalogResource) Open() (err error) {
             "`filename` VARCHAR(255) NOT NULL, \n" +
             "`hash` VARCHAR(" + strconv.Itoa(h.Size() * 2) + ") NOT NULL, \n" +
             "`mtime_epoch` INTEGER UNSIGNED NOT NULL, \n" +
+            "`size` INTEGER UNSIGNED NULL, \n" +
             "`last_check_epoch` INTEGER UNSIGNED NULL DEFAULT 0, \n" +
+            "`last_change_epoch` INTEGER UNSIGNED NULL DEFAULT 0, \n" +
             "CONSTRAINT `files_filename_idx` UNIQUE (`filename`, `path_id`), \n" +
             "CONSTRAINT `files_path_id_fk` FOREIGN KEY (`path_id`) REFERENCES `paths` (`path_id`)\n" +
         ")\n"
@@ -150,10 +263,230 @@ func (self *catalogResource) Open() (err error) {
         if err != nil {
             panic(err)
         }
+
+        err = self.createIndex(db, "files_hash_idx", "files", "hash", true)
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    // Older catalogs get these as schema upgrades.
+    if isNewCatalog == true {
+        queries := append(historyTableQueries, scanBaselineQueries...)
+
+        for _, query := range queries {
+            _, err = db.Exec(query)
+            if err != nil {
+                panic(err)
+            }
+        }
+    }
+
+    err = self.upgradeSchema(db)
+    if err != nil {
+        panic(err)
+    }
+
+    err = self.checkHashAlgorithm(db)
+    if err != nil {
+        panic(err)
     }
 
     self.db = db
 
+    // A new catalog is being written to anyway.
+    if isNewCatalog == true {
+        err = self.recordHashAlgorithm()
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    return nil
+}
+
+// Read the schema version that the catalog is at.
+func (self *catalogResource) getSchemaVersion(db *sql.DB) (version int, err error) {
+    query := 
+        "SELECT " +
+            "`ci`.`value` " +
+        "FROM " +
+            "`catalog_info` `ci` " +
+        "WHERE " +
+            "`ci`.`key` = 'schema_version'"
+
+    var versionRaw string
+
+    err = db.QueryRow(query).Scan(&versionRaw)
+    if err != nil {
+        return 0, err
+    }
+
+    return strconv.Atoi(versionRaw)
+}
+
+// Bring an older catalog up to the current schema.
+func (self *catalogResource) upgradeSchema(db *sql.DB) (err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            err = r.(error)
+            l.Error("Could not upgrade schema", "err", err)
+        }
+    }()
+
+    version, err := self.getSchemaVersion(db)
+    if err != nil {
+        panic(err)
+    }
+
+    if version > CurrentSchemaVersion {
+        panic(errors.New(fmt.Sprintf("Catalog schema (%d) is newer than we support (%d).", version, CurrentSchemaVersion)))
+    }
+
+    for version < CurrentSchemaVersion {
+        version++
+
+        l.Debug("Upgrading schema.", "version", version)
+
+        tx, err := db.Begin()
+        if err != nil {
+            panic(err)
+        }
+
+        for _, statement := range schemaUpgrades[version] {
+            _, err := tx.Exec(statement)
+            if err != nil {
+                tx.Rollback()
+                panic(err)
+            }
+        }
+
+        query := 
+            "UPDATE " +
+                "`catalog_info` " +
+            "SET " +
+                "`value` = ? " +
+            "WHERE " +
+                "`key` = 'schema_version'"
+
+        _, err = tx.Exec(query, version)
+        if err != nil {
+            tx.Rollback()
+            panic(err)
+        }
+
+        err = tx.Commit()
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    return nil
+}
+
+// Make sure that the catalog was built with the algorithm that we were given. 
+// For catalogs from before we recorded the algorithm, it's inferred from the
+// width of the hashes that are already there. Nothing is written, so this
+// works for catalogs that can't be (see recordHashAlgorithm()).
+func (self *catalogResource) checkHashAlgorithm(db *sql.DB) (err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            err = r.(error)
+            l.Error("Could not check hash algorithm", "err", err)
+        }
+    }()
+
+    query := 
+        "SELECT " +
+            "`ci`.`value` " +
+        "FROM " +
+            "`catalog_info` `ci` " +
+        "WHERE " +
+            "`ci`.`key` = 'hash_algorithm'"
+
+    var recordedAlgorithm string
+
+    err = db.QueryRow(query).Scan(&recordedAlgorithm)
+    if err == nil {
+        if recordedAlgorithm != *self.cc.hashAlgorithm {
+            l.Error("Catalog was built with a different algorithm.", 
+                "recorded", recordedAlgorithm, 
+                "given", *self.cc.hashAlgorithm)
+
+            panic(ErrAlgorithmMismatch)
+        }
+
+        self.isAlgorithmRecorded = true
+
+        return nil
+    } else if err != sql.ErrNoRows {
+        panic(err)
+    }
+
+    h, err := self.cc.getHashObject()
+    if err != nil {
+        panic(err)
+    }
+
+    query = 
+        "SELECT " +
+            "`p`.`hash` " +
+        "FROM " +
+            "`paths` `p` " +
+        "WHERE " +
+            "`p`.`hash` IS NOT NULL " +
+        "LIMIT 1"
+
+    var existingHash string
+
+    err = db.QueryRow(query).Scan(&existingHash)
+    if err == nil {
+        if len(existingHash) != h.Size() * 2 {
+            panic(ErrAlgorithmMismatch)
+        }
+    } else if err != sql.ErrNoRows {
+        panic(err)
+    }
+
+    self.isAlgorithmRecorded = false
+
+    return nil
+}
+
+// Record the algorithm in catalogs that don't have it yet. This is only done 
+// when the catalog is being updated, since the catalog might be read-only 
+// otherwise.
+func (self *catalogResource) recordHashAlgorithm() (err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            err = r.(error)
+            l.Error("Could not record hash algorithm", "err", err)
+        }
+    }()
+
+    if self.isAlgorithmRecorded == true {
+        return nil
+    }
+
+    query := 
+        "INSERT INTO `catalog_info` " +
+            "(`key`, `value`) " +
+        "VALUES " +
+            "('hash_algorithm', ?)"
+
+    _, err = self.executeInsert(self.db, &query, *self.cc.hashAlgorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    self.isAlgorithmRecorded = true
+
     return nil
 }
 
@@ -295,7 +628,8 @@ func (self *catalogResource) lookupFile(pd *pathDescriptor, filename *string) (f
             "SELECT " +
                 "`f`.`file_id`, " +
                 "`f`.`hash`, " +
-                "`f`.`mtime_epoch` " +
+                "`f`.`mtime_epoch`, " +
+                "`f`.`size` " +
             "FROM " +
                 "`files` `f` " +
             "WHERE " +
@@ -334,13 +668,19 @@ func (self *catalogResource) lookupFile(pd *pathDescriptor, filename *string) (f
             var catalogEntryId int
             var hash string
             var mtimeEpoch int64
+            var size sql.NullInt64
 
-            err = rows.Scan(&catalogEntryId, &hash, &mtimeEpoch)
+            err = rows.Scan(&catalogEntryId, &hash, &mtimeEpoch, &size)
             if err != nil {
                 panic(err)
             }
 
-            ce := newCatalogEntry(catalogEntryId, &hash, mtimeEpoch)
+            // Catalogs from before we recorded sizes won't have them.
+            if size.Valid == false {
+                size.Int64 = UnknownFileSize
+            }
+
+            ce := newCatalogEntry(catalogEntryId, &hash, mtimeEpoch, size.Int64)
             flr = newFoundFileLookupResult(pd, filename, ce)
         }
     }
@@ -501,7 +841,7 @@ func (self *catalogResource) updateLastPathCheck(plr *pathLookupResult, nowEpoch
     return nil
 }
 
-func (self *catalogResource) setFile(flr *fileLookupResult, mtime int64, hash *string, nowEpoch int64) (err error) {
+func (self *catalogResource) setFile(flr *fileLookupResult, mtime int64, size int64, hash *string, nowEpoch int64) (err error) {
     l := NewLogger("catalog_resource")
 
     defer func() {
@@ -516,6 +856,7 @@ func (self *catalogResource) setFile(flr *fileLookupResult, mtime int64, hash *s
             "filename", flr.filename, 
             "id", flr.entry.id, 
             "mtime", mtime, 
+            "size", size, 
             "hash", *hash)
 
 // TODO(dustin): Can we use an alias on the table here?
@@ -524,7 +865,9 @@ func (self *catalogResource) setFile(flr *fileLookupResult, mtime int64, hash *s
                 "`files` " +
             "SET " +
                 "`hash` = ?, " +
-                "`mtime_epoch` = ? " +
+                "`mtime_epoch` = ?, " +
+                "`size` = ?, " +
+                "`last_change_epoch` = ? " +
             "WHERE " +
                 "`file_id` = ?"
 
@@ -533,7 +876,7 @@ func (self *catalogResource) setFile(flr *fileLookupResult, mtime int64, hash *s
             panic(err)
         }
 
-        r, err := stmt.Exec(*hash, mtime, flr.entry.id)
+        r, err := stmt.Exec(*hash, mtime, size, nowEpoch, flr.entry.id)
         if err != nil {
             panic(err)
         }
@@ -559,16 +902,17 @@ func (self *catalogResource) setFile(flr *fileLookupResult, mtime int64, hash *s
         l.Debug("Inserting entry", 
             "filename", flr.filename, 
             "mtime", mtime, 
+            "size", size, 
             "hash", *hash, 
             "last_check_epoch", nowEpoch)
 
         query := 
             "INSERT INTO `files` " +
-                "(`path_id`, `filename`, `hash`, `mtime_epoch`, `last_check_epoch`) " +
+                "(`path_id`, `filename`, `hash`, `mtime_epoch`, `size`, `last_check_epoch`, `last_change_epoch`) " +
             "VALUES " +
-                "(?, ?, ?, ?, ?)"
+                "(?, ?, ?, ?, ?, ?, ?)"
 
-        _, err := self.executeInsert(self.db, &query, flr.pd.GetPathInfoId(), flr.filename, *hash, mtime, nowEpoch)
+        _, err := self.executeInsert(self.db, &query, flr.pd.GetPathInfoId(), flr.filename, *hash, mtime, size, nowEpoch, nowEpoch)
         if err != nil {
             panic(err)
         }
@@ -577,6 +921,57 @@ func (self *catalogResource) setFile(flr *fileLookupResult, mtime int64, hash *s
     return nil
 }
 
+// Record a new mtime and size for a file whose content didn't change. This 
+// isn't considered to be a change.
+func (self *catalogResource) updateFileStat(flr *fileLookupResult, mtime int64, size int64) (err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            err = r.(error)
+            l.Error("Could not update file stat", "err", err)
+        }
+    }()
+
+    l.Debug("Updating entry stat", 
+        "filename", flr.filename, 
+        "id", flr.entry.id, 
+        "mtime", mtime, 
+        "size", size)
+
+    query := 
+        "UPDATE " +
+            "`files` " +
+        "SET " +
+            "`mtime_epoch` = ?, " +
+            "`size` = ? " +
+        "WHERE " +
+            "`file_id` = ?"
+
+    stmt, err := self.db.Prepare(query)
+    if err != nil {
+        panic(err)
+    }
+
+    r, err := stmt.Exec(mtime, size, flr.entry.id)
+    if err != nil {
+        panic(err)
+    }
+
+    affected, err := r.RowsAffected()
+    if err != nil {
+        panic(err)
+    }
+
+    if affected < 1 {
+        panic(errors.New("No rows were affected by the entry-stat-update query"))
+    } else if affected > 1 {
+        panic(errors.New("Too many rows were affected by the entry-stat-update query"))
+    }
+
+    return nil
+}
+
 func (self *catalogResource) createPath(relPath *string, nowEpoch int64) (id int, err error) {
     l := NewLogger("catalog_resource")
 
@@ -595,11 +990,11 @@ func (self *catalogResource) createPath(relPath *string, nowEpoch int64) (id int
 
     query := 
         "INSERT INTO `paths` " +
-            "(`rel_path`, `last_check_epoch`) " +
+            "(`rel_path`, `last_check_epoch`, `last_change_epoch`, `symlink_count`) " +
         "VALUES " +
-            "(?, ?)"
+            "(?, ?, ?, 0)"
 
-    idInt64, err := self.executeInsert(self.db, &query, *relPath, nowEpoch)
+    idInt64, err := self.executeInsert(self.db, &query, *relPath, nowEpoch, nowEpoch)
     if err != nil {
         panic(err)
     }
@@ -609,7 +1004,7 @@ func (self *catalogResource) createPath(relPath *string, nowEpoch int64) (id int
     return int(idInt64), nil
 }
 
-func (self *catalogResource) updatePath(pd *pathDescriptor, hash *string) (err error) {
+func (self *catalogResource) updatePath(pd *pathDescriptor, hash *string, nowEpoch int64) (err error) {
     l := NewLogger("catalog_resource")
 
     defer func() {
@@ -629,7 +1024,8 @@ func (self *catalogResource) updatePath(pd *pathDescriptor, hash *string) (err e
         "UPDATE " +
             "`paths` " +
         "SET " +
-            "`hash` = ? " +
+            "`hash` = ?, " +
+            "`last_change_epoch` = ? " +
         "WHERE " +
             "`path_id` = ?"
 
@@ -638,7 +1034,7 @@ func (self *catalogResource) updatePath(pd *pathDescriptor, hash *string) (err e
         panic(err)
     }
 
-    r, err := stmt.Exec(*hash, pd.GetPathInfoId())
+    r, err := stmt.Exec(*hash, nowEpoch, pd.GetPathInfoId())
     if err != nil {
         panic(err)
     }
@@ -657,10 +1053,42 @@ func (self *catalogResource) updatePath(pd *pathDescriptor, hash *string) (err e
     return nil
 }
 
+// Record how many symlinks the path has, if that's not what's recorded 
+// already.
+func (self *catalogResource) updatePathSymlinkCount(pd *pathDescriptor, symlinkCount int) (err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            err = r.(error)
+            l.Error("Could not update path symlink count", "err", err)
+        }
+    }()
+
+    query := 
+        "UPDATE " +
+            "`paths` " +
+        "SET " +
+            "`symlink_count` = ? " +
+        "WHERE " +
+            "`path_id` = ? AND " +
+            "(`symlink_count` IS NULL OR `symlink_count` != ?)"
+
+    _, err = self.db.Exec(query, symlinkCount, pd.GetPathInfoId(), symlinkCount)
+    if err != nil {
+        panic(err)
+    }
+
+    return nil
+}
+
+// The number of old entries that are read at a time when reporting them.
+const oldEntryPageSize = 1000
+
 // Get a list of all file records that haven't been touched in this run 
 // (because all of the ones that match known files have been updated to a later 
 // timestamp than they had).
-func (self *catalogResource) pushOldFiles(nowEpoch int64, c chan<- *ChangeEvent) (err error) {
+func (self *catalogResource) pushOldFiles(nowEpoch int64, cs ChangeSink) (err error) {
     l := NewLogger("catalog_resource")
 
     defer func() {
@@ -677,45 +1105,80 @@ func (self *catalogResource) pushOldFiles(nowEpoch int64, c chan<- *ChangeEvent)
 
     query := 
         "SELECT " +
+            "`f`.`file_id`, " +
             "`p`.`rel_path`, " +
-            "`f`.`filename` " +
+            "`f`.`filename`, " +
+            "`f`.`hash` " +
         "FROM " +
             "`files` `f`, " +
             "`paths` `p` " +
         "WHERE " +
             "`f`.`last_check_epoch` < ? AND " +
-            "`p`.`path_id` = `f`.`path_id`"
+            "`p`.`path_id` = `f`.`path_id` AND " +
+            "`f`.`file_id` > ? " +
+        "ORDER BY " +
+            "`f`.`file_id` ASC " +
+        "LIMIT ?"
 
     stmt, err := self.db.Prepare(query)
     if err != nil {
         panic(err)
     }
 
-    rows, err := stmt.Query(nowEpoch)
-    if err != nil {
-        panic(err)
-    }
-
-    defer rows.Close()
+    defer stmt.Close()
 
+    // The events are sent a page at a time, with no rows open, since the sink 
+    // might write to the catalog.
     n := 0
-    for rows.Next() {
-        n++
+    lastFileId := 0
+    for {
+        rows, err := stmt.Query(nowEpoch, lastFileId, oldEntryPageSize)
+        if err != nil {
+            panic(err)
+        }
 
-        var relPath string
-        var filename string
+        page := make([]*ChangeEvent, 0)
+        for rows.Next() {
+            var relPath string
+            var filename string
+            var hash string
+
+            err = rows.Scan(&lastFileId, &relPath, &filename, &hash)
+            if err != nil {
+                rows.Close()
+                panic(err)
+            }
+
+            relFilepath := path.Join(relPath, filename)
+
+            ce := &ChangeEvent { 
+                    EntityType: EntityTypeFile, 
+                    ChangeType: UpdateTypeDelete, 
+                    RelPath: relFilepath,
+                    PreviousHash: hash,
+            }
+
+            page = append(page, ce)
+        }
+
+        err = rows.Err()
+        rows.Close()
 
-        err = rows.Scan(&relPath, &filename)
         if err != nil {
             panic(err)
         }
 
-        relFilepath := path.Join(relPath, filename)
+        for _, ce := range page {
+            err = cs.Event(ce)
+            if err != nil {
+                panic(err)
+            }
+        }
 
-        c <- &ChangeEvent { 
-                EntityType: EntityTypeFile, 
-                ChangeType: UpdateTypeDelete, 
-                RelPath: relFilepath,
+        n += len(page)
+
+        if len(page) < oldEntryPageSize {
+            break
         }
     }
 
@@ -727,7 +1190,7 @@ func (self *catalogResource) pushOldFiles(nowEpoch int64, c chan<- *ChangeEvent)
 // Get a list of all path records that haven't been touched in this run 
 // (because all of the ones that match known files have been updated to a later 
 // timestamp than they had).
-func (self *catalogResource) pushOldPaths(nowEpoch int64, c chan<- *ChangeEvent) (err error) {
+func (self *catalogResource) pushOldPaths(nowEpoch int64, cs ChangeSink) (err error) {
     l := NewLogger("catalog_resource")
 
     defer func() {
@@ -744,18 +1207,115 @@ func (self *catalogResource) pushOldPaths(nowEpoch int64, c chan<- *ChangeEvent)
 
     query := 
         "SELECT " +
-            "`p`.`rel_path` " +
+            "`p`.`path_id`, " +
+            "`p`.`rel_path`, " +
+            "`p`.`hash` " +
+        "FROM " +
+            "`paths` `p` " +
+        "WHERE " +
+            "`p`.`last_check_epoch` < ? AND " +
+            "`p`.`path_id` > ? " +
+        "ORDER BY " +
+            "`p`.`path_id` ASC " +
+        "LIMIT ?"
+
+    stmt, err := self.db.Prepare(query)
+    if err != nil {
+        panic(err)
+    }
+
+    defer stmt.Close()
+
+    // The events are sent a page at a time, with no rows open, since the sink 
+    // might write to the catalog.
+    n := 0
+    lastPathId := 0
+    for {
+        rows, err := stmt.Query(nowEpoch, lastPathId, oldEntryPageSize)
+        if err != nil {
+            panic(err)
+        }
+
+        page := make([]*ChangeEvent, 0)
+        for rows.Next() {
+            var relPath string
+            var hash sql.NullString
+
+            err = rows.Scan(&lastPathId, &relPath, &hash)
+            if err != nil {
+                rows.Close()
+                panic(err)
+            }
+
+            ce := &ChangeEvent { 
+                    EntityType: EntityTypePath, 
+                    ChangeType: UpdateTypeDelete, 
+                    RelPath: relPath,
+                    PreviousHash: hash.String,
+            }
+
+            page = append(page, ce)
+        }
+
+        err = rows.Err()
+        rows.Close()
+
+        if err != nil {
+            panic(err)
+        }
+
+        for _, ce := range page {
+            err = cs.Event(ce)
+            if err != nil {
+                panic(err)
+            }
+        }
+
+        n += len(page)
+
+        if len(page) < oldEntryPageSize {
+            break
+        }
+    }
+
+    l.Debug("Finished reporting old path entries.", "n", n)
+
+    return nil
+}
+
+// Push all file records that weren't encountered in this run. This is what we 
+// do in place of pushOldFiles() when we're not allowed to update the 
+// check-timestamps.
+func (self *catalogResource) pushUnvisitedFiles(visitedFileIds map[int]bool, cs ChangeSink) (err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            err = r.(error)
+            l.Error("Could not push unvisited files", "err", err)
+        }
+    }()
+
+    l.Debug("Pushing unvisited file entries.")
+
+    query := 
+        "SELECT " +
+            "`f`.`file_id`, " +
+            "`p`.`rel_path`, " +
+            "`f`.`filename`, " +
+            "`f`.`hash` " +
         "FROM " +
+            "`files` `f`, " +
             "`paths` `p` " +
         "WHERE " +
-            "`p`.`last_check_epoch` < ?"
+            "`p`.`path_id` = `f`.`path_id`"
 
     stmt, err := self.db.Prepare(query)
     if err != nil {
         panic(err)
     }
 
-    rows, err := stmt.Query(nowEpoch)
+    rows, err := stmt.Query()
     if err != nil {
         panic(err)
     }
@@ -764,23 +1324,118 @@ func (self *catalogResource) pushOldPaths(nowEpoch int64, c chan<- *ChangeEvent)
 
     n := 0
     for rows.Next() {
+        var fileId int
+        var relPath string
+        var filename string
+        var hash string
+
+        err = rows.Scan(&fileId, &relPath, &filename, &hash)
+        if err != nil {
+            panic(err)
+        }
+
+        if visitedFileIds[fileId] == true {
+            continue
+        }
+
         n++
 
+        relFilepath := path.Join(relPath, filename)
+
+        ce := &ChangeEvent { 
+                EntityType: EntityTypeFile, 
+                ChangeType: UpdateTypeDelete, 
+                RelPath: relFilepath,
+                PreviousHash: hash,
+        }
+
+        err = cs.Event(ce)
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    err = rows.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    l.Debug("Finished reporting unvisited file entries.", "n", n)
+
+    return nil
+}
+
+// Push all path records that weren't encountered in this run. This is what we 
+// do in place of pushOldPaths() when we're not allowed to update the 
+// check-timestamps.
+func (self *catalogResource) pushUnvisitedPaths(visitedPathIds map[int]bool, cs ChangeSink) (err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            err = r.(error)
+            l.Error("Could not push unvisited paths", "err", err)
+        }
+    }()
+
+    l.Debug("Pushing unvisited path entries.")
+
+    query := 
+        "SELECT " +
+            "`p`.`path_id`, " +
+            "`p`.`rel_path`, " +
+            "`p`.`hash` " +
+        "FROM " +
+            "`paths` `p`"
+
+    stmt, err := self.db.Prepare(query)
+    if err != nil {
+        panic(err)
+    }
+
+    rows, err := stmt.Query()
+    if err != nil {
+        panic(err)
+    }
+
+    defer rows.Close()
+
+    n := 0
+    for rows.Next() {
+        var pathId int
         var relPath string
+        var hash sql.NullString
 
-        err = rows.Scan(&relPath)
+        err = rows.Scan(&pathId, &relPath, &hash)
         if err != nil {
             panic(err)
         }
 
-        c <- &ChangeEvent { 
+        if visitedPathIds[pathId] == true {
+            continue
+        }
+
+        n++
+
+        ce := &ChangeEvent { 
                 EntityType: EntityTypePath, 
                 ChangeType: UpdateTypeDelete, 
                 RelPath: relPath,
+                PreviousHash: hash.String,
+        }
+
+        err = cs.Event(ce)
+        if err != nil {
+            panic(err)
         }
     }
 
-    l.Debug("Finished reporting old path entries.", "n", n)
+    err = rows.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    l.Debug("Finished reporting unvisited path entries.", "n", n)
 
     return nil
 }
@@ -788,7 +1443,7 @@ func (self *catalogResource) pushOldPaths(nowEpoch int64, c chan<- *ChangeEvent)
 // Delete all records that haven't been touched in this run (because all of the 
 // ones that match known files have been updated to a later timestamp than they 
 // had).
-func (self *catalogResource) pruneOldFiles(nowEpoch int64, c chan<- *ChangeEvent) (err error) {
+func (self *catalogResource) pruneOldFiles(nowEpoch int64, cs ChangeSink) (err error) {
     l := NewLogger("catalog_resource")
 
     defer func() {
@@ -798,8 +1453,8 @@ func (self *catalogResource) pruneOldFiles(nowEpoch int64, c chan<- *ChangeEvent
         }
     }()
 
-    if c != nil {
-        err := self.pushOldFiles(nowEpoch, c)
+    if cs != nil {
+        err := self.pushOldFiles(nowEpoch, cs)
         if err != nil {
             panic(err)
         }
@@ -830,7 +1485,7 @@ func (self *catalogResource) pruneOldFiles(nowEpoch int64, c chan<- *ChangeEvent
     return nil
 }
 
-func (self *catalogResource) pruneOldPaths(nowEpoch int64, c chan<- *ChangeEvent) (err error) {
+func (self *catalogResource) pruneOldPaths(nowEpoch int64, cs ChangeSink) (err error) {
     l := NewLogger("catalog_resource")
 
     defer func() {
@@ -840,8 +1495,8 @@ func (self *catalogResource) pruneOldPaths(nowEpoch int64, c chan<- *ChangeEvent
         }
     }()
 
-    if c != nil {
-        err := self.pushOldPaths(nowEpoch, c)
+    if cs != nil {
+        err := self.pushOldPaths(nowEpoch, cs)
         if err != nil {
             panic(err)
         }
@@ -905,28 +1560,112 @@ func (self *catalogResource) getLastPathHash(relPath *string) (hp *string, err e
     defer rows.Close()
 
     if rows.Next() == false {
-        panic(err)
+        panic(ErrPathNotFound)
     }
 
-    var hash string
+    var hash sql.NullString
 
     err = rows.Scan(&hash)
     if err != nil {
         panic(err)
     }
 
-    return &hash, nil
+    if hash.Valid == false {
+        // The path is recorded but a hash hasn't been calculated for it yet.
+        return nil, nil
+    }
+
+    return &hash.String, nil
 }
 
-type resolveResult struct {
-    RelPath string
-    PathId int
-    Filename string
-    FileId int
-    Hash string
+// Describes a path or a file in the catalog. For paths, Filename is empty, 
+// FileId is (0), and Mtime and Size are nil. Size is also nil for files whose 
+// size hasn't been recorded yet.
+type ResolveResult struct {
+    RelPath string          `json:"rel_path"`
+    PathId int              `json:"path_id"`
+    Filename string         `json:"filename"`
+    FileId int              `json:"file_id"`
+    Hash string             `json:"hash"`
+    Mtime *int64            `json:"mtime"`
+    Size *int64             `json:"size"`
+    LastCheckEpoch int64    `json:"last_check_epoch"`
 }
 
-func (self *catalogResource) ResolvePath(relPath *string) (rr *resolveResult, err error) {
+// Load the stored times and size for the result.
+func (self *catalogResource) loadResolveDetails(rr *ResolveResult) (err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            err = r.(error)
+            l.Error("Could not load resolve details", "err", err)
+        }
+    }()
+
+    if rr.FileId == 0 {
+        query := 
+            "SELECT " +
+                "`p`.`last_check_epoch` " +
+            "FROM " +
+                "`paths` `p` " +
+            "WHERE " +
+                "`p`.`path_id` = ?"
+
+        var lastCheckEpoch sql.NullInt64
+
+        err = self.db.QueryRow(query, rr.PathId).Scan(&lastCheckEpoch)
+        if err != nil {
+            panic(err)
+        }
+
+        rr.LastCheckEpoch = lastCheckEpoch.Int64
+    } else {
+        query := 
+            "SELECT " +
+                "`f`.`mtime_epoch`, " +
+                "`f`.`size`, " +
+                "`f`.`last_check_epoch` " +
+            "FROM " +
+                "`files` `f` " +
+            "WHERE " +
+                "`f`.`file_id` = ?"
+
+        var mtimeEpoch int64
+        var size sql.NullInt64
+        var lastCheckEpoch sql.NullInt64
+
+        err = self.db.QueryRow(query, rr.FileId).Scan(&mtimeEpoch, &size, &lastCheckEpoch)
+        if err != nil {
+            panic(err)
+        }
+
+        rr.Mtime = &mtimeEpoch
+        rr.LastCheckEpoch = lastCheckEpoch.Int64
+
+        if size.Valid == true {
+            rr.Size = &size.Int64
+        }
+    }
+
+    return nil
+}
+
+// Clean a relative path the way that it might have been given to us ("dir/",
+// "./dir"). The root is an empty string.
+func cleanRelPath(relPath string) string {
+    relPath = path.Clean(relPath)
+    if relPath == "." {
+        return ""
+    }
+
+    return relPath
+}
+
+// Find the path or file with the given relative path. ErrPathNotFound, 
+// ErrFileNotFound, or ErrRootNotRecorded is returned if it's not in the 
+// catalog.
+func (self *catalogResource) ResolvePath(relPath *string) (rr *ResolveResult, err error) {
     l := NewLogger("catalog_resource")
 
     defer func() {
@@ -938,6 +1677,9 @@ func (self *catalogResource) ResolvePath(relPath *string) (rr *resolveResult, er
         }
     }()
 
+    normalRelPath := cleanRelPath(*relPath)
+    relPath = &normalRelPath
+
     plr, err := self.lookupPath(relPath)
     if err != nil {
         panic(err)
@@ -947,17 +1689,21 @@ func (self *catalogResource) ResolvePath(relPath *string) (rr *resolveResult, er
         // We weren't given a [valid] path. Try it as a file.
 
         if *relPath == "" {
-            panic(errors.New("We're looking for the root hash but it isn't recorded."))
+            panic(ErrRootNotRecorded)
         }
 
-        parentPath := path.Dir(*relPath)
+        parentPath := parentRelPath(*relPath)
         filename := path.Base(*relPath)
 
         plr, err := self.lookupPath(&parentPath)
         if err != nil {
             panic(err)
         } else if plr.wasFound == false {
-            panic(errors.New("Argument not found as path or file."))
+            if parentPath == "" {
+                panic(ErrRootNotRecorded)
+            }
+
+            panic(ErrPathNotFound)
         }
 
         pd := newRecordedPathDescriptor(&parentPath, plr.entry.id)
@@ -965,11 +1711,12 @@ func (self *catalogResource) ResolvePath(relPath *string) (rr *resolveResult, er
         flr, err := self.lookupFile(pd, &filename)
         if err != nil {
             panic(err)
-        } else if plr.wasFound == false {
-            panic(errors.New("Parent directory found but the argument wasn't found as a file within it."))
+        } else if flr.wasFound == false {
+            // The parent directory exists but the file doesn't.
+            panic(ErrFileNotFound)
         }
 
-        rr = &resolveResult {
+        rr = &ResolveResult {
                 RelPath: parentPath,
                 PathId: plr.entry.id,
                 Filename: filename,
@@ -977,12 +1724,17 @@ func (self *catalogResource) ResolvePath(relPath *string) (rr *resolveResult, er
                 Hash: flr.entry.hash,
         }
     } else {
-        rr = &resolveResult {
+        rr = &ResolveResult {
                 RelPath: *relPath,
                 PathId: plr.entry.id,
                 Hash: plr.entry.hash,
         }
     }
 
+    err = self.loadResolveDetails(rr)
+    if err != nil {
+        panic(err)
+    }
+
     return rr, nil
 }
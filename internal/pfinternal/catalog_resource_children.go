@@ -0,0 +1,355 @@
+package pfinternal
+
+import (
+    "sort"
+    "path"
+    "errors"
+    "strings"
+
+    "database/sql"
+)
+
+// Build a condition (and its arguments) that matches every path below the
+// given path but not the path itself. The rel_path index can be used for this
+// because all descendants of "a/b" sort between "a/b/" and "a/b0" ('0' comes
+// immediately after '/').
+func descendantPathsCondition(alias string, relPath string) (string, []interface{}) {
+    column := "`" + alias + "`.`rel_path`"
+
+    if relPath == "" {
+        return column + " != ''", []interface{} {}
+    }
+
+    condition := column + " > ? AND " + column + " < ?"
+    args := []interface{} { relPath + "/", relPath + "0" }
+
+    return condition, args
+}
+
+// Return the number of levels that the given path is below the base path. The
+// caller must make sure that it actually is below it.
+func relativeDepth(baseRelPath string, relPath string) int {
+    if relPath == baseRelPath {
+        return 0
+    } else if baseRelPath == "" {
+        return strings.Count(relPath, "/") + 1
+    } else {
+        return strings.Count(relPath[len(baseRelPath) + 1:], "/") + 1
+    }
+}
+
+// Orders results the same way that SortChangeEvents() orders events.
+type resolveResultsByPath []*ResolveResult
+
+func (self resolveResultsByPath) Len() int {
+    return len(self)
+}
+
+func (self resolveResultsByPath) Swap(i, j int) {
+    self[i], self[j] = self[j], self[i]
+}
+
+func (self resolveResultsByPath) Less(i, j int) bool {
+    a := path.Join(self[i].RelPath, self[i].Filename)
+    b := path.Join(self[j].RelPath, self[j].Filename)
+
+    if a != b {
+        return compareRelPaths(a, b) < 0
+    }
+
+    // Paths before files.
+    return self[i].FileId < self[j].FileId
+}
+
+// Return the paths (and, optionally, files) at the given depths below the
+// given path. Immediate children are at depth (1). The results are ordered
+// like a tree.
+func (self *catalogResource) ListChildren(relPath *string, levels []int, includeFiles bool) (results []*ResolveResult, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            results = nil
+            err = r.(error)
+
+            l.Error("Could not list children", "err", err)
+        }
+    }()
+
+    normalRelPath := cleanRelPath(*relPath)
+    relPath = &normalRelPath
+
+    plr, err := self.lookupPath(relPath)
+    if err != nil {
+        panic(err)
+    } else if plr.wasFound == false {
+        if *relPath == "" {
+            panic(ErrRootNotRecorded)
+        }
+
+        panic(ErrPathNotFound)
+    }
+
+    wantedLevels := make(map[int]bool)
+    for _, level := range levels {
+        if level < 1 {
+            panic(errors.New("Levels must be at least one."))
+        }
+
+        wantedLevels[level] = true
+    }
+
+    results = make([]*ResolveResult, 0)
+
+    condition, args := descendantPathsCondition("p", *relPath)
+
+    query :=
+        "SELECT " +
+            "`p`.`path_id`, " +
+            "`p`.`rel_path`, " +
+            "`p`.`hash`, " +
+            "`p`.`last_check_epoch` " +
+        "FROM " +
+            "`paths` `p` " +
+        "WHERE " +
+            condition
+
+    stmt, err := self.db.Prepare(query)
+    if err != nil {
+        panic(err)
+    }
+
+    rows, err := stmt.Query(args...)
+    if err != nil {
+        panic(err)
+    }
+
+    defer rows.Close()
+
+    for rows.Next() {
+        var pathId int
+        var childRelPath string
+        var hash sql.NullString
+        var lastCheckEpoch sql.NullInt64
+
+        err = rows.Scan(&pathId, &childRelPath, &hash, &lastCheckEpoch)
+        if err != nil {
+            panic(err)
+        }
+
+        if wantedLevels[relativeDepth(*relPath, childRelPath)] == false {
+            continue
+        }
+
+        rr := &ResolveResult {
+                RelPath: childRelPath,
+                PathId: pathId,
+                Hash: hash.String,
+                LastCheckEpoch: lastCheckEpoch.Int64,
+        }
+
+        results = append(results, rr)
+    }
+
+    err = rows.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    if includeFiles == true {
+        // The path itself can have files at the first level.
+        query :=
+            "SELECT " +
+                "`p`.`path_id`, " +
+                "`p`.`rel_path`, " +
+                "`f`.`file_id`, " +
+                "`f`.`filename`, " +
+                "`f`.`hash`, " +
+                "`f`.`mtime_epoch`, " +
+                "`f`.`size`, " +
+                "`f`.`last_check_epoch` " +
+            "FROM " +
+                "`files` `f`, " +
+                "`paths` `p` " +
+            "WHERE " +
+                "`p`.`path_id` = `f`.`path_id` AND " +
+                "(`p`.`rel_path` = ? OR (" + condition + "))"
+
+        stmt, err := self.db.Prepare(query)
+        if err != nil {
+            panic(err)
+        }
+
+        fileArgs := append([]interface{} { *relPath }, args...)
+
+        rows, err := stmt.Query(fileArgs...)
+        if err != nil {
+            panic(err)
+        }
+
+        defer rows.Close()
+
+        for rows.Next() {
+            var pathId int
+            var parentRelPath string
+            var fileId int
+            var filename string
+            var hash string
+            var mtimeEpoch int64
+            var size sql.NullInt64
+            var lastCheckEpoch sql.NullInt64
+
+            err = rows.Scan(&pathId, &parentRelPath, &fileId, &filename, &hash, &mtimeEpoch, &size, &lastCheckEpoch)
+            if err != nil {
+                panic(err)
+            }
+
+            if wantedLevels[relativeDepth(*relPath, parentRelPath) + 1] == false {
+                continue
+            }
+
+            rr := &ResolveResult {
+                    RelPath: parentRelPath,
+                    PathId: pathId,
+                    Filename: filename,
+                    FileId: fileId,
+                    Hash: hash,
+                    Mtime: &mtimeEpoch,
+                    LastCheckEpoch: lastCheckEpoch.Int64,
+            }
+
+            if size.Valid == true {
+                rr.Size = &size.Int64
+            }
+
+            results = append(results, rr)
+        }
+
+        err = rows.Err()
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    sort.Sort(resolveResultsByPath(results))
+
+    return results, nil
+}
+
+// A path or file directly within another path.
+type childEntry struct {
+    id int
+    hash string
+}
+
+// Return the paths and files directly within the given path, by name.
+func (self *catalogResource) listImmediateChildren(pathId int, relPath string) (paths map[string]*childEntry, files map[string]*childEntry, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            paths = nil
+            files = nil
+            err = r.(error)
+
+            l.Error("Could not list immediate children", "err", err)
+        }
+    }()
+
+    condition, args := descendantPathsCondition("p", relPath)
+
+    // Exclude anything with a separator after the parent's path. SQLite 
+    // counts characters rather than bytes, so we let it measure the prefix 
+    // itself.
+    var nameOffset int
+    if relPath == "" {
+        condition += " AND instr(`p`.`rel_path`, '/') = 0"
+    } else {
+        condition += " AND instr(substr(`p`.`rel_path`, length(?) + 2), '/') = 0"
+        args = append(args, relPath)
+
+        nameOffset = len(relPath) + 1
+    }
+
+    query :=
+        "SELECT " +
+            "`p`.`path_id`, " +
+            "`p`.`rel_path`, " +
+            "`p`.`hash` " +
+        "FROM " +
+            "`paths` `p` " +
+        "WHERE " +
+            condition
+
+    rows, err := self.db.Query(query, args...)
+    if err != nil {
+        panic(err)
+    }
+
+    defer rows.Close()
+
+    paths = make(map[string]*childEntry)
+
+    for rows.Next() {
+        var childPathId int
+        var childRelPath string
+        var hash sql.NullString
+
+        err = rows.Scan(&childPathId, &childRelPath, &hash)
+        if err != nil {
+            panic(err)
+        }
+
+        paths[childRelPath[nameOffset:]] = &childEntry {
+                id: childPathId,
+                hash: hash.String,
+        }
+    }
+
+    err = rows.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    query =
+        "SELECT " +
+            "`f`.`file_id`, " +
+            "`f`.`filename`, " +
+            "`f`.`hash` " +
+        "FROM " +
+            "`files` `f` " +
+        "WHERE " +
+            "`f`.`path_id` = ?"
+
+    fileRows, err := self.db.Query(query, pathId)
+    if err != nil {
+        panic(err)
+    }
+
+    defer fileRows.Close()
+
+    files = make(map[string]*childEntry)
+
+    for fileRows.Next() {
+        var fileId int
+        var filename string
+        var hash string
+
+        err = fileRows.Scan(&fileId, &filename, &hash)
+        if err != nil {
+            panic(err)
+        }
+
+        files[filename] = &childEntry {
+                id: fileId,
+                hash: hash,
+        }
+    }
+
+    err = fileRows.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    return paths, files, nil
+}
@@ -0,0 +1,135 @@
+package pfinternal
+
+import (
+    "testing"
+    "os"
+    "path"
+    "strings"
+
+    "io/ioutil"
+)
+
+// Scan a small tree (the same one that's used in the README) into a new
+// catalog.
+func scanChildrenTestTree(t *testing.T, catalogFilepath string, scanPath string) *catalogResource {
+    os.MkdirAll(path.Join(scanPath, "dir1", "dir1dir1"), 0755)
+    os.MkdirAll(path.Join(scanPath, "dir2"), 0755)
+
+    files := map[string]string {
+        "aa": "",
+        "bb": "",
+        "dir1/cc": "hello",
+        "dir1/dd": "",
+        "dir1/dir1dir1/ee": "",
+        "dir1/dir1dir1/ff": "",
+        "dir2/gg": "",
+        "dir2/hh": "",
+    }
+
+    for relFilepath, content := range files {
+        ioutil.WriteFile(path.Join(scanPath, relFilepath), []byte(content), 0644)
+    }
+
+    hashAlgorithm := HashAlgorithm
+
+    cr, _, err := scanIntoCatalog(&scanPath, &catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not scan.")
+    }
+
+    return cr
+}
+
+// Render results the way that pflookup does: paths get a trailing slash.
+func renderChildren(results []*ResolveResult) string {
+    names := make([]string, len(results))
+    for i, rr := range results {
+        if rr.FileId == 0 {
+            names[i] = rr.RelPath + "/"
+        } else {
+            names[i] = path.Join(rr.RelPath, rr.Filename)
+        }
+    }
+
+    return strings.Join(names, " ")
+}
+
+func TestListChildren(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    cr := scanChildrenTestTree(t, catalogFilepath, scanPath)
+    defer cr.Close()
+
+    cases := []struct {
+        relPath string
+        levels []int
+        includeFiles bool
+        expected string
+    } {
+        { "", []int { 1 }, false, "dir1/ dir2/" },
+        { "", []int { 1 }, true, "aa bb dir1/ dir2/" },
+        { "", []int { 2 }, false, "dir1/dir1dir1/" },
+        { "", []int { 2 }, true, "dir1/cc dir1/dd dir1/dir1dir1/ dir2/gg dir2/hh" },
+        { "", []int { 1, 2 }, true, "aa bb dir1/ dir1/cc dir1/dd dir1/dir1dir1/ dir2/ dir2/gg dir2/hh" },
+        { "", []int { 3 }, true, "dir1/dir1dir1/ee dir1/dir1dir1/ff" },
+        { "", []int { 4 }, true, "" },
+        { "dir1", []int { 1 }, true, "dir1/cc dir1/dd dir1/dir1dir1/" },
+        { "dir1", []int { 1, 2 }, false, "dir1/dir1dir1/" },
+
+        // The path is cleaned the same way that ResolvePath() cleans it.
+        { "dir1/", []int { 1 }, true, "dir1/cc dir1/dd dir1/dir1dir1/" },
+        { "./dir1/dir1dir1", []int { 1 }, true, "dir1/dir1dir1/ee dir1/dir1dir1/ff" },
+        { ".", []int { 1 }, false, "dir1/ dir2/" },
+    }
+
+    for i, c := range cases {
+        results, err := cr.ListChildren(&c.relPath, c.levels, c.includeFiles)
+        if err != nil {
+            t.Fatalf("Could not list children for case (%d): %v", i, err)
+        }
+
+        actual := renderChildren(results)
+        if actual != c.expected {
+            t.Fatalf("Children not correct for case (%d): [%s]", i, actual)
+        }
+    }
+
+    relPath := "dir1/cc"
+    results, err := cr.ListChildren(&relPath, []int { 1 }, true)
+    if err != ErrPathNotFound {
+        t.Fatalf("Expected path-not-found error for a file: %v %v", err, results)
+    }
+
+    relPath = ""
+    _, err = cr.ListChildren(&relPath, []int { 0 }, true)
+    if err == nil {
+        t.Fatalf("Expected error for level (0).")
+    }
+
+    // Files carry their sizes and mtimes; paths don't.
+    results, err = cr.ListChildren(&relPath, []int { 1 }, true)
+    if err != nil {
+        t.Fatalf("Could not list children.")
+    }
+
+    for _, rr := range results {
+        if rr.FileId == 0 && (rr.Size != nil || rr.Mtime != nil) {
+            t.Fatalf("Path has a size or mtime: %v", rr)
+        } else if rr.FileId != 0 && (rr.Size == nil || rr.Mtime == nil) {
+            t.Fatalf("File is missing its size or mtime: %v", rr)
+        } else if rr.Hash == "" {
+            t.Fatalf("Child has no hash: %v", rr)
+        }
+    }
+}
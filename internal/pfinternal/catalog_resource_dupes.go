@@ -0,0 +1,213 @@
+package pfinternal
+
+import (
+    "sort"
+)
+
+// A set of files, or of paths, that all have the same hash.
+type DuplicateGroup struct {
+    Hash string             `json:"hash"`
+    IsPath bool             `json:"is_path"`
+
+    // The size of one copy. For paths, this is the total size of the files
+    // below it. This is nil if the size of a file hasn't been recorded yet.
+    Size *int64             `json:"size"`
+
+    // The bytes that could be reclaimed by keeping only one copy. Copies that
+    // are inside of a duplicated path are already counted for that path.
+    WastedBytes int64       `json:"wasted_bytes"`
+
+    RelPaths []string       `json:"rel_paths"`
+}
+
+type DuplicatesReport struct {
+    Paths []*DuplicateGroup `json:"paths"`
+    Files []*DuplicateGroup `json:"files"`
+    WastedBytes int64       `json:"wasted_bytes"`
+}
+
+type duplicateGroupsByWaste []*DuplicateGroup
+
+func (self duplicateGroupsByWaste) Len() int {
+    return len(self)
+}
+
+func (self duplicateGroupsByWaste) Swap(i, j int) {
+    self[i], self[j] = self[j], self[i]
+}
+
+func (self duplicateGroupsByWaste) Less(i, j int) bool {
+    if self[i].WastedBytes != self[j].WastedBytes {
+        return self[i].WastedBytes > self[j].WastedBytes
+    }
+
+    return compareRelPaths(self[i].RelPaths[0], self[j].RelPaths[0]) < 0
+}
+
+type relPathsInTreeOrder []string
+
+func (self relPathsInTreeOrder) Len() int {
+    return len(self)
+}
+
+func (self relPathsInTreeOrder) Swap(i, j int) {
+    self[i], self[j] = self[j], self[i]
+}
+
+func (self relPathsInTreeOrder) Less(i, j int) bool {
+    return compareRelPaths(self[i], self[j]) < 0
+}
+
+// Collect every node below the given one, by type and hash.
+func collectTreeNodesByHash(node *CatalogTreeNode, paths map[string][]*CatalogTreeNode, files map[string][]*CatalogTreeNode) {
+    for _, child := range node.Children {
+        if child.Hash == "" {
+            // The path hasn't been hashed yet.
+        } else if child.IsPath == true {
+            paths[child.Hash] = append(paths[child.Hash], child)
+        } else {
+            files[child.Hash] = append(files[child.Hash], child)
+        }
+
+        if child.IsPath == true {
+            collectTreeNodesByHash(child, paths, files)
+        }
+    }
+}
+
+// Record every node that is inside of a duplicated path.
+func collectCoveredTreeNodes(node *CatalogTreeNode, isCovered bool, duplicated map[*CatalogTreeNode]bool, covered map[*CatalogTreeNode]bool) {
+    for _, child := range node.Children {
+        if isCovered == true {
+            covered[child] = true
+        }
+
+        if child.IsPath == true {
+            collectCoveredTreeNodes(child, isCovered || duplicated[child], duplicated, covered)
+        }
+    }
+}
+
+// Build the groups for the given nodes. A group is only reported if at least
+// one of its members isn't inside of a duplicated path, otherwise the group
+// would just repeat what's reported for those paths.
+func buildDuplicateGroups(nodesByHash map[string][]*CatalogTreeNode, minSize int64, covered map[*CatalogTreeNode]bool) []*DuplicateGroup {
+    groups := make([]*DuplicateGroup, 0)
+
+    for hash, nodes := range nodesByHash {
+        if len(nodes) < 2 {
+            continue
+        }
+
+        // Identical content has the same size, but it might not be recorded
+        // for every copy yet.
+        size := int64(UnknownFileSize)
+        for _, node := range nodes {
+            if node.Size != UnknownFileSize {
+                size = node.Size
+                break
+            }
+        }
+
+        if size != UnknownFileSize && size < minSize {
+            continue
+        }
+
+        uncoveredCount := 0
+        relPaths := make([]string, len(nodes))
+
+        for i, node := range nodes {
+            if covered[node] == false {
+                uncoveredCount++
+            }
+
+            relPaths[i] = node.RelPath
+        }
+
+        if uncoveredCount == 0 {
+            continue
+        }
+
+        // One copy is kept. If any of the copies are inside of a duplicated
+        // path, it's one of those.
+        wastedCount := uncoveredCount
+        if uncoveredCount == len(nodes) {
+            wastedCount--
+        }
+
+        sort.Sort(relPathsInTreeOrder(relPaths))
+
+        dg := &DuplicateGroup {
+                Hash: hash,
+                IsPath: nodes[0].IsPath,
+                RelPaths: relPaths,
+        }
+
+        if size != UnknownFileSize {
+            dg.Size = &size
+            dg.WastedBytes = size * int64(wastedCount)
+        }
+
+        groups = append(groups, dg)
+    }
+
+    sort.Sort(duplicateGroupsByWaste(groups))
+
+    return groups
+}
+
+// Find the files and paths below the given path that have identical content.
+// When whole paths are duplicated, the files and paths inside of them are
+// only reported if they're also duplicated somewhere else. Groups smaller
+// than minSize bytes are skipped. Everything comes from the catalog.
+func (self *catalogResource) FindDuplicates(relPath *string, minSize int64) (dr *DuplicatesReport, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            dr = nil
+            err = r.(error)
+
+            l.Error("Could not find duplicates", "err", err)
+        }
+    }()
+
+    root, err := self.GetTree(relPath, 0)
+    if err != nil {
+        panic(err)
+    }
+
+    pathsByHash := make(map[string][]*CatalogTreeNode)
+    filesByHash := make(map[string][]*CatalogTreeNode)
+
+    collectTreeNodesByHash(root, pathsByHash, filesByHash)
+
+    duplicated := make(map[*CatalogTreeNode]bool)
+    for _, nodes := range pathsByHash {
+        if len(nodes) < 2 || nodes[0].Size < minSize {
+            continue
+        }
+
+        for _, node := range nodes {
+            duplicated[node] = true
+        }
+    }
+
+    covered := make(map[*CatalogTreeNode]bool)
+    collectCoveredTreeNodes(root, false, duplicated, covered)
+
+    dr = &DuplicatesReport {
+            Paths: buildDuplicateGroups(pathsByHash, minSize, covered),
+            Files: buildDuplicateGroups(filesByHash, minSize, covered),
+    }
+
+    for _, dg := range dr.Paths {
+        dr.WastedBytes += dg.WastedBytes
+    }
+
+    for _, dg := range dr.Files {
+        dr.WastedBytes += dg.WastedBytes
+    }
+
+    return dr, nil
+}
@@ -0,0 +1,166 @@
+package pfinternal
+
+import (
+    "path"
+
+    "database/sql"
+)
+
+// Return the given path relative to the base path.
+func relativeRelPath(baseRelPath string, relPath string) string {
+    if baseRelPath == "" {
+        return relPath
+    } else if relPath == baseRelPath {
+        return ""
+    } else {
+        return relPath[len(baseRelPath) + 1:]
+    }
+}
+
+// Write the paths (if the format has them) and files at and below the given
+// path as a manifest. The entries are relative to that path, so the manifest
+// can be checked against a copy of just that directory. Rows are written as
+// they're read.
+func (self *catalogResource) ExportManifest(relPath *string, mw ManifestWriter) (err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            err = r.(error)
+            l.Error("Could not export manifest", "err", err)
+        }
+    }()
+
+    normalRelPath := cleanRelPath(*relPath)
+    relPath = &normalRelPath
+
+    plr, err := self.lookupPath(relPath)
+    if err != nil {
+        panic(err)
+    } else if plr.wasFound == false {
+        if *relPath == "" {
+            panic(ErrRootNotRecorded)
+        }
+
+        panic(ErrPathNotFound)
+    }
+
+    err = mw.Begin(*self.cc.HashAlgorithm(), plr.entry.hash)
+    if err != nil {
+        panic(err)
+    }
+
+    condition, args := descendantPathsCondition("p", *relPath)
+    allArgs := append([]interface{} { *relPath }, args...)
+
+    if mw.IncludesPaths() == true {
+        query :=
+            "SELECT " +
+                "`p`.`rel_path`, " +
+                "`p`.`hash` " +
+            "FROM " +
+                "`paths` `p` " +
+            "WHERE " +
+                "`p`.`rel_path` = ? OR (" + condition + ") " +
+            "ORDER BY " +
+                "`p`.`rel_path`"
+
+        rows, err := self.db.Query(query, allArgs...)
+        if err != nil {
+            panic(err)
+        }
+
+        defer rows.Close()
+
+        for rows.Next() {
+            var pathRelPath string
+            var hash sql.NullString
+
+            err = rows.Scan(&pathRelPath, &hash)
+            if err != nil {
+                panic(err)
+            }
+
+            me := &ManifestEntry {
+                    Type: ManifestEntryTypePath,
+                    RelPath: relativeRelPath(*relPath, pathRelPath),
+                    Hash: hash.String,
+            }
+
+            err = mw.Write(me)
+            if err != nil {
+                panic(err)
+            }
+        }
+
+        err = rows.Err()
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    query :=
+        "SELECT " +
+            "`p`.`rel_path`, " +
+            "`f`.`filename`, " +
+            "`f`.`hash`, " +
+            "`f`.`size`, " +
+            "`f`.`mtime_epoch` " +
+        "FROM " +
+            "`files` `f`, " +
+            "`paths` `p` " +
+        "WHERE " +
+            "`p`.`path_id` = `f`.`path_id` AND " +
+            "(`p`.`rel_path` = ? OR (" + condition + ")) " +
+        "ORDER BY " +
+            "`p`.`rel_path`, " +
+            "`f`.`filename`"
+
+    rows, err := self.db.Query(query, allArgs...)
+    if err != nil {
+        panic(err)
+    }
+
+    defer rows.Close()
+
+    for rows.Next() {
+        var parentRelPath string
+        var filename string
+        var hash string
+        var size sql.NullInt64
+        var mtimeEpoch int64
+
+        err = rows.Scan(&parentRelPath, &filename, &hash, &size, &mtimeEpoch)
+        if err != nil {
+            panic(err)
+        }
+
+        me := &ManifestEntry {
+                Type: ManifestEntryTypeFile,
+                RelPath: path.Join(relativeRelPath(*relPath, parentRelPath), filename),
+                Hash: hash,
+                Mtime: &mtimeEpoch,
+        }
+
+        if size.Valid == true {
+            me.Size = &size.Int64
+        }
+
+        err = mw.Write(me)
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    err = rows.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    err = mw.End()
+    if err != nil {
+        panic(err)
+    }
+
+    return nil
+}
@@ -0,0 +1,70 @@
+package pfinternal
+
+// Copy the given path and everything below it into another (empty) catalog,
+// with the path as the root. The hash of the new root is the hash that this
+// catalog has for the path, so a scan of a copy of just that directory with
+// the new catalog will produce the same hash. The catalogs must use the same
+// algorithm. Returns the number of paths and files that were copied.
+func (self *catalogResource) ExtractSubtree(relPath *string, target *catalogResource) (pathCount int, fileCount int, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            pathCount = 0
+            fileCount = 0
+            err = r.(error)
+
+            l.Error("Could not extract subtree", "err", err)
+        }
+    }()
+
+    if *self.cc.HashAlgorithm() != *target.cc.HashAlgorithm() {
+        panic(ErrAlgorithmMismatch)
+    }
+
+    extractRelPath, err := normalizeManifestRelPath(*relPath)
+    if err != nil {
+        panic(err)
+    }
+
+    plr, err := self.lookupPath(&extractRelPath)
+    if err != nil {
+        panic(err)
+    } else if plr.wasFound == false {
+        if extractRelPath == "" {
+            panic(ErrRootNotRecorded)
+        }
+
+        panic(ErrPathNotFound)
+    }
+
+    query := "SELECT COUNT(*) FROM `paths`"
+
+    var existingCount int
+    err = target.db.QueryRow(query).Scan(&existingCount)
+    if err != nil {
+        panic(err)
+    } else if existingCount > 0 {
+        panic(ErrCatalogNotEmpty)
+    }
+
+    tx, err := target.db.Begin()
+    if err != nil {
+        panic(err)
+    }
+
+    pathCount, fileCount, err = target.copyCatalogRows(tx, self, extractRelPath, "")
+    if err != nil {
+        tx.Rollback()
+        panic(err)
+    }
+
+    err = tx.Commit()
+    if err != nil {
+        panic(err)
+    }
+
+    l.Debug("Subtree extracted.", "relPath", extractRelPath, "paths", pathCount, "files", fileCount)
+
+    return pathCount, fileCount, nil
+}
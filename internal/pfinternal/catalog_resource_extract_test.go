@@ -0,0 +1,83 @@
+package pfinternal
+
+import (
+    "testing"
+    "os"
+    "path"
+    "strings"
+
+    "io/ioutil"
+)
+
+func TestExtractSubtree(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    extractedCatalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+        os.Remove(extractedCatalogFilepath)
+    }
+
+    defer cleanup()
+
+    subtreePath := path.Join(scanPath, "photos", "2019")
+    os.MkdirAll(path.Join(subtreePath, "june"), 0755)
+
+    // This sorts right next to the subtree but isn't part of it.
+    os.MkdirAll(path.Join(scanPath, "photos", "2019x"), 0755)
+
+    ioutil.WriteFile(path.Join(subtreePath, "aa"), []byte("aa"), 0644)
+    ioutil.WriteFile(path.Join(subtreePath, "june", "bb"), []byte("bb"), 0644)
+    ioutil.WriteFile(path.Join(scanPath, "photos", "2019x", "cc"), []byte("cc"), 0644)
+
+    scanAndCollectChanges(t, catalogFilepath, scanPath, true)
+
+    source := openTestCatalog(t, catalogFilepath, HashAlgorithm)
+    defer source.Close()
+
+    target := openTestCatalog(t, extractedCatalogFilepath, HashAlgorithm)
+
+    relPath := "photos/2019"
+    pathCount, fileCount, err := source.ExtractSubtree(&relPath, target)
+    if err != nil {
+        target.Close()
+        t.Fatalf("Could not extract subtree.")
+    } else if pathCount != 2 || fileCount != 2 {
+        target.Close()
+        t.Fatalf("Counts not correct: (%d) (%d)", pathCount, fileCount)
+    }
+
+    // It can only be done once.
+    _, _, err = source.ExtractSubtree(&relPath, target)
+    if err != ErrCatalogNotEmpty {
+        target.Close()
+        t.Fatalf("Expected not-empty error: %v", err)
+    }
+
+    expected, err := source.ResolvePath(&relPath)
+    if err != nil {
+        t.Fatalf("Could not resolve source path.")
+    }
+
+    rootRelPath := ""
+    actual, err := target.ResolvePath(&rootRelPath)
+    if err != nil {
+        t.Fatalf("Could not resolve extracted root.")
+    }
+
+    target.Close()
+
+    if actual.Hash != expected.Hash {
+        t.Fatalf("Extracted root hash not correct: [%s] != [%s]", actual.Hash, expected.Hash)
+    }
+
+    changes := scanAndCollectChanges(t, extractedCatalogFilepath, subtreePath, true)
+    if strings.Join(changes, "\n") != "" {
+        t.Fatalf("Scan of the subtree found changes: %v", changes)
+    }
+}
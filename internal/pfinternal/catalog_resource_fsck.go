@@ -0,0 +1,423 @@
+package pfinternal
+
+import (
+    "fmt"
+    "sort"
+)
+
+// The kinds of problems that CheckCatalog() finds.
+const (
+    FsckProblemIntegrity = "integrity"
+    FsckProblemOrphanFile = "orphan-file"
+    FsckProblemOrphanPath = "orphan-path"
+    FsckProblemFileHash = "bad-file-hash"
+    FsckProblemPathHash = "bad-path-hash"
+    FsckProblemPathHashMismatch = "path-hash-mismatch"
+)
+
+// A problem found in the catalog.
+type FsckProblem struct {
+    Kind string
+    RelPath string
+    Detail string
+    WasRepaired bool
+}
+
+func (self *FsckProblem) String() string {
+    return fmt.Sprintf("%s [%s]: %s", self.Kind, self.RelPath, self.Detail)
+}
+
+// Whether the hash has the width of the catalog's algorithm and is lowercase
+// hex.
+func isValidHash(hash string, width int) bool {
+    if len(hash) != width {
+        return false
+    }
+
+    for _, c := range hash {
+        if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+            return false
+        }
+    }
+
+    return true
+}
+
+// Run SQLite's own integrity check. Returns the messages, if there were any
+// problems.
+func (self *catalogResource) checkIntegrity() (messages []string, err error) {
+    rows, err := self.db.Query("PRAGMA integrity_check")
+    if err != nil {
+        return nil, err
+    }
+
+    defer rows.Close()
+
+    messages = make([]string, 0)
+
+    for rows.Next() {
+        var message string
+
+        err = rows.Scan(&message)
+        if err != nil {
+            return nil, err
+        }
+
+        if message != "ok" {
+            messages = append(messages, message)
+        }
+    }
+
+    err = rows.Err()
+    if err != nil {
+        return nil, err
+    }
+
+    return messages, nil
+}
+
+// Find the files whose paths aren't recorded. Returns file IDs.
+func (self *catalogResource) findOrphanFiles() (fileIds []int, problems []*FsckProblem, err error) {
+    query :=
+        "SELECT " +
+            "`f`.`file_id`, " +
+            "`f`.`path_id`, " +
+            "`f`.`filename` " +
+        "FROM " +
+            "`files` `f` " +
+            "LEFT JOIN `paths` `p` ON " +
+                "`p`.`path_id` = `f`.`path_id` " +
+        "WHERE " +
+            "`p`.`path_id` IS NULL " +
+        "ORDER BY " +
+            "`f`.`file_id`"
+
+    rows, err := self.db.Query(query)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    defer rows.Close()
+
+    fileIds = make([]int, 0)
+    problems = make([]*FsckProblem, 0)
+
+    for rows.Next() {
+        var fileId int
+        var pathId int
+        var filename string
+
+        err = rows.Scan(&fileId, &pathId, &filename)
+        if err != nil {
+            return nil, nil, err
+        }
+
+        fileIds = append(fileIds, fileId)
+        problems = append(problems, &FsckProblem {
+                Kind: FsckProblemOrphanFile,
+                RelPath: filename,
+                Detail: fmt.Sprintf("file is in path (%d), which isn't recorded", pathId),
+        })
+    }
+
+    err = rows.Err()
+    if err != nil {
+        return nil, nil, err
+    }
+
+    return fileIds, problems, nil
+}
+
+// Find the files whose hashes aren't the right width or aren't hex. Returns
+// file IDs.
+func (self *catalogResource) findBadFileHashes(width int) (fileIds []int, problems []*FsckProblem, err error) {
+    query :=
+        "SELECT " +
+            "`f`.`file_id`, " +
+            "`p`.`rel_path`, " +
+            "`f`.`filename`, " +
+            "`f`.`hash` " +
+        "FROM " +
+            "`files` `f` " +
+            "JOIN `paths` `p` ON " +
+                "`p`.`path_id` = `f`.`path_id` " +
+        "ORDER BY " +
+            "`f`.`file_id`"
+
+    rows, err := self.db.Query(query)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    defer rows.Close()
+
+    fileIds = make([]int, 0)
+    problems = make([]*FsckProblem, 0)
+
+    for rows.Next() {
+        var fileId int
+        var relPath string
+        var filename string
+        var hash string
+
+        err = rows.Scan(&fileId, &relPath, &filename, &hash)
+        if err != nil {
+            return nil, nil, err
+        }
+
+        if isValidHash(hash, width) == true {
+            continue
+        }
+
+        if relPath != "" {
+            filename = relPath + "/" + filename
+        }
+
+        fileIds = append(fileIds, fileId)
+        problems = append(problems, &FsckProblem {
+                Kind: FsckProblemFileHash,
+                RelPath: filename,
+                Detail: fmt.Sprintf("hash is not (%d) hex characters: [%s]", width, hash),
+        })
+    }
+
+    err = rows.Err()
+    if err != nil {
+        return nil, nil, err
+    }
+
+    return fileIds, problems, nil
+}
+
+func (self *catalogResource) deleteFilesById(fileIds []int) (err error) {
+    tx, err := self.db.Begin()
+    if err != nil {
+        return err
+    }
+
+    query :=
+        "DELETE FROM `files` " +
+        "WHERE " +
+            "`file_id` = ?"
+
+    for _, fileId := range fileIds {
+        _, err = tx.Exec(query, fileId)
+        if err != nil {
+            tx.Rollback()
+            return err
+        }
+    }
+
+    return tx.Commit()
+}
+
+// Drop the given paths, and the files in them.
+func (self *catalogResource) deletePathsById(pathIds []int) (err error) {
+    tx, err := self.db.Begin()
+    if err != nil {
+        return err
+    }
+
+    queries := []string {
+        "DELETE FROM `files` " +
+        "WHERE " +
+            "`path_id` = ?",
+
+        "DELETE FROM `paths` " +
+        "WHERE " +
+            "`path_id` = ?",
+    }
+
+    for _, pathId := range pathIds {
+        for _, query := range queries {
+            _, err = tx.Exec(query, pathId)
+            if err != nil {
+                tx.Rollback()
+                return err
+            }
+        }
+    }
+
+    return tx.Commit()
+}
+
+// Check the catalog: SQLite's integrity check, files whose paths aren't
+// recorded, paths whose parents aren't recorded, hashes that don't fit the
+// algorithm, and every path hash recalculated from the catalog alone. If
+// repair is true, fix what we can: files that have no path or bad hashes are
+// dropped (the next scan will hash them again), paths whose parents aren't
+// recorded are dropped along with everything below them, and path hashes are
+// rewritten. The same problems are found whether or not they're repaired. If
+// SQLite finds a problem, nothing else is checked or repaired, since the rows
+// can't be trusted.
+//
+// Symlinks aren't recorded in the catalog, so the hashes of paths that have
+// them (or that haven't been scanned since we started counting them) can't be
+// recalculated. Those are taken as they are and are neither reported as
+// mismatches nor repaired.
+func (self *catalogResource) CheckCatalog(repair bool) (problems []*FsckProblem, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            problems = nil
+            err = r.(error)
+
+            l.Error("Could not check catalog", "err", err)
+        }
+    }()
+
+    problems = make([]*FsckProblem, 0)
+
+    messages, err := self.checkIntegrity()
+    if err != nil {
+        panic(err)
+    }
+
+    if len(messages) > 0 {
+        for _, message := range messages {
+            problems = append(problems, &FsckProblem {
+                    Kind: FsckProblemIntegrity,
+                    Detail: message,
+            })
+        }
+
+        return problems, nil
+    }
+
+    h, err := self.cc.getHashObject()
+    if err != nil {
+        panic(err)
+    }
+
+    width := h.Size() * 2
+
+    orphanFileIds, orphanFileProblems, err := self.findOrphanFiles()
+    if err != nil {
+        panic(err)
+    }
+
+    badFileIds, badFileProblems, err := self.findBadFileHashes(width)
+    if err != nil {
+        panic(err)
+    }
+
+    fileProblems := append(orphanFileProblems, badFileProblems...)
+
+    if repair == true && len(fileProblems) > 0 {
+        err = self.deleteFilesById(append(orphanFileIds, badFileIds...))
+        if err != nil {
+            panic(err)
+        }
+
+        for _, fp := range fileProblems {
+            fp.WasRepaired = true
+        }
+    }
+
+    problems = append(problems, fileProblems...)
+
+    paths, err := self.loadRebuildPaths()
+    if err != nil {
+        panic(err)
+    }
+
+    // Every path but the root needs its parent. A path whose parent isn't
+    // recorded can't be reached from the root, and neither can anything 
+    // below it.
+    orphanRelPaths := make([]string, 0)
+    for relPath, _ := range paths {
+        if relPath == "" {
+            continue
+        }
+
+        if _, found := paths[parentRelPath(relPath)]; found == false {
+            orphanRelPaths = append(orphanRelPaths, relPath)
+        }
+    }
+
+    sort.Strings(orphanRelPaths)
+
+    orphanPathIds := make([]int, 0)
+    for relPath, rp := range paths {
+        for _, orphanRelPath := range orphanRelPaths {
+            if isRelPathAtOrBelow(orphanRelPath, relPath) == true {
+                orphanPathIds = append(orphanPathIds, rp.id)
+                delete(paths, relPath)
+
+                break
+            }
+        }
+    }
+
+    orphanPathProblems := make([]*FsckProblem, 0, len(orphanRelPaths))
+    for _, relPath := range orphanRelPaths {
+        orphanPathProblems = append(orphanPathProblems, &FsckProblem {
+                Kind: FsckProblemOrphanPath,
+                RelPath: relPath,
+                Detail: fmt.Sprintf("path's parent [%s] isn't recorded", parentRelPath(relPath)),
+        })
+    }
+
+    if repair == true && len(orphanPathIds) > 0 {
+        err = self.deletePathsById(orphanPathIds)
+        if err != nil {
+            panic(err)
+        }
+
+        for _, pp := range orphanPathProblems {
+            pp.WasRepaired = true
+        }
+    }
+
+    problems = append(problems, orphanPathProblems...)
+
+    ordered, err := self.calculatePathHashes(paths)
+    if err != nil {
+        panic(err)
+    }
+
+    relPaths := make([]string, 0, len(paths))
+    for relPath, _ := range paths {
+        relPaths = append(relPaths, relPath)
+    }
+
+    sort.Strings(relPaths)
+
+    hashProblems := make([]*FsckProblem, 0)
+    for _, relPath := range relPaths {
+        rp := paths[relPath]
+
+        if rp.hash != "" && isValidHash(rp.hash, width) == false {
+            hashProblems = append(hashProblems, &FsckProblem {
+                    Kind: FsckProblemPathHash,
+                    RelPath: rp.relPath,
+                    Detail: fmt.Sprintf("hash is not (%d) hex characters: [%s]", width, rp.hash),
+            })
+        } else if rp.hash != rp.calculatedHash {
+            hashProblems = append(hashProblems, &FsckProblem {
+                    Kind: FsckProblemPathHashMismatch,
+                    RelPath: rp.relPath,
+                    Detail: fmt.Sprintf("recorded [%s] but calculated [%s]", rp.hash, rp.calculatedHash),
+            })
+        }
+    }
+
+    if repair == true && len(hashProblems) > 0 {
+        _, err = self.writeCalculatedPathHashes(ordered)
+        if err != nil {
+            panic(err)
+        }
+
+        // A bad hash that had to be kept is still there.
+        for _, hp := range hashProblems {
+            if paths[hp.RelPath].isKept == false {
+                hp.WasRepaired = true
+            }
+        }
+    }
+
+    problems = append(problems, hashProblems...)
+
+    return problems, nil
+}
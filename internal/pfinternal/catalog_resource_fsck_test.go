@@ -0,0 +1,249 @@
+package pfinternal
+
+import (
+    "testing"
+    "os"
+    "path"
+    "fmt"
+    "strings"
+
+    "io/ioutil"
+)
+
+func TestCheckCatalog(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    os.MkdirAll(path.Join(scanPath, "dir"), 0755)
+
+    ioutil.WriteFile(path.Join(scanPath, "aa"), []byte("aa"), 0644)
+    ioutil.WriteFile(path.Join(scanPath, "dir", "bb"), []byte("bb"), 0644)
+    ioutil.WriteFile(path.Join(scanPath, "dir", "cc"), []byte("cc"), 0644)
+
+    hashAlgorithm := HashAlgorithm
+
+    cr, rootHash, err := scanIntoCatalog(&scanPath, &catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not scan.")
+    }
+
+    problems, err := cr.CheckCatalog(false)
+    if err != nil {
+        t.Fatalf("Could not check clean catalog.")
+    } else if len(problems) != 0 {
+        t.Fatalf("Clean catalog has problems: %v", problems)
+    }
+
+    queries := []string {
+        // A file whose path is gone.
+        "INSERT INTO `files` (`path_id`, `filename`, `hash`, `mtime_epoch`) VALUES (9999, 'lost', '" + strings.Repeat("0", 40) + "', 0)",
+        // A path whose parent is gone, with a path and a file below it.
+        "INSERT INTO `paths` (`rel_path`, `hash`) VALUES ('gone/sub', NULL)",
+        "INSERT INTO `paths` (`rel_path`, `hash`) VALUES ('gone/sub/deeper', NULL)",
+        "INSERT INTO `files` (`path_id`, `filename`, `hash`, `mtime_epoch`) SELECT `path_id`, 'inside', '" + strings.Repeat("0", 40) + "', 0 FROM `paths` WHERE `rel_path` = 'gone/sub'",
+        // Hashes that don't fit the algorithm.
+        "UPDATE `files` SET `hash` = 'not-a-hash' WHERE `filename` = 'cc'",
+        "UPDATE `paths` SET `hash` = 'ABC' WHERE `rel_path` = 'dir'",
+        // A path hash that doesn't match its children.
+        "UPDATE `paths` SET `hash` = '" + strings.Repeat("0", 40) + "' WHERE `rel_path` = ''",
+    }
+
+    for _, query := range queries {
+        _, err = cr.db.Exec(query)
+        if err != nil {
+            t.Fatalf("Could not damage catalog: %s", err)
+        }
+    }
+
+    problems, err = cr.CheckCatalog(false)
+    if err != nil {
+        t.Fatalf("Could not check damaged catalog.")
+    }
+
+    actual := make([]string, len(problems))
+    for i, fp := range problems {
+        actual[i] = fmt.Sprintf("%s [%s] %v", fp.Kind, fp.RelPath, fp.WasRepaired)
+    }
+
+    expected := []string {
+        "orphan-file [lost] false",
+        "bad-file-hash [dir/cc] false",
+        "orphan-path [gone/sub] false",
+        "path-hash-mismatch [] false",
+        "bad-path-hash [dir] false",
+    }
+
+    if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+        t.Fatalf("Problems not correct:\n%s", strings.Join(actual, "\n"))
+    }
+
+    problems, err = cr.CheckCatalog(true)
+    if err != nil {
+        t.Fatalf("Could not repair catalog.")
+    }
+
+    // The same problems are found when repairing.
+    for i, fp := range problems {
+        if i >= len(expected) || fmt.Sprintf("%s [%s] true", fp.Kind, fp.RelPath) != strings.Replace(expected[i], "false", "true", 1) {
+            t.Fatalf("Problem found when repairing not correct: %s", fp)
+        }
+    }
+
+    if len(problems) != len(expected) {
+        t.Fatalf("Expected the same problems when repairing: (%d)", len(problems))
+    }
+
+    // The orphan was dropped, with everything below it, rather than given a
+    // parent.
+    for _, relPath := range []string { "gone", "gone/sub", "gone/sub/deeper", "gone/sub/inside" } {
+        _, err = cr.ResolvePath(&relPath)
+        if err != ErrPathNotFound && err != ErrFileNotFound {
+            t.Fatalf("Orphan [%s] not dropped: %v", relPath, err)
+        }
+    }
+
+    problems, err = cr.CheckCatalog(false)
+    if err != nil {
+        t.Fatalf("Could not check repaired catalog.")
+    } else if len(problems) != 0 {
+        t.Fatalf("Repaired catalog still has problems: %v", problems)
+    }
+
+    cr.Close()
+
+    // The file with the bad hash was dropped, so a scan puts it, and the
+    // original hashes, back.
+    cr, secondRootHash, err := scanIntoCatalog(&scanPath, &catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not rescan.")
+    }
+
+    defer cr.Close()
+
+    if secondRootHash != rootHash {
+        t.Fatalf("Root hash not restored by scan: [%s] != [%s]", secondRootHash, rootHash)
+    }
+}
+
+func TestCheckCatalogSymlinks(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    os.MkdirAll(path.Join(scanPath, "dir"), 0755)
+    os.MkdirAll(path.Join(scanPath, "other"), 0755)
+
+    ioutil.WriteFile(path.Join(scanPath, "aa"), []byte("aa"), 0644)
+    ioutil.WriteFile(path.Join(scanPath, "dir", "bb"), []byte("bb"), 0644)
+    ioutil.WriteFile(path.Join(scanPath, "other", "cc"), []byte("cc"), 0644)
+
+    err := os.Symlink("bb", path.Join(scanPath, "dir", "link"))
+    if err != nil {
+        t.Fatalf("Could not create symlink: %s", err)
+    }
+
+    hashAlgorithm := HashAlgorithm
+
+    cr, rootHash, err := scanIntoCatalog(&scanPath, &catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not scan.")
+    }
+
+    var symlinkCount int
+    err = cr.db.QueryRow("SELECT `symlink_count` FROM `paths` WHERE `rel_path` = 'dir'").Scan(&symlinkCount)
+    if err != nil {
+        t.Fatalf("Could not read symlink count: %s", err)
+    } else if symlinkCount != 1 {
+        t.Fatalf("Symlink count not correct: (%d)", symlinkCount)
+    }
+
+    // The hash of the path with the symlink can't be recalculated, so it's
+    // neither reported nor repaired.
+    problems, err := cr.CheckCatalog(false)
+    if err != nil {
+        t.Fatalf("Could not check catalog.")
+    } else if len(problems) != 0 {
+        t.Fatalf("Catalog with symlinks has problems: %v", problems)
+    }
+
+    problems, err = cr.CheckCatalog(true)
+    if err != nil {
+        t.Fatalf("Could not repair catalog.")
+    } else if len(problems) != 0 {
+        t.Fatalf("Catalog with symlinks was repaired: %v", problems)
+    }
+
+    // Paths that haven't been counted (from before we counted) are taken as
+    // they are, too. A bad hash is still reported, but can't be repaired.
+    queries := []string {
+        "UPDATE `paths` SET `symlink_count` = NULL WHERE `rel_path` = 'other'",
+        "UPDATE `files` SET `hash` = '" + strings.Repeat("0", 40) + "', `mtime_epoch` = 0 WHERE `filename` = 'cc'",
+        "UPDATE `paths` SET `hash` = 'ABC' WHERE `rel_path` = 'dir'",
+    }
+
+    for _, query := range queries {
+        _, err = cr.db.Exec(query)
+        if err != nil {
+            t.Fatalf("Could not change catalog: %s", err)
+        }
+    }
+
+    problems, err = cr.CheckCatalog(true)
+    if err != nil {
+        t.Fatalf("Could not repair catalog.")
+    }
+
+    actual := make([]string, len(problems))
+    for i, fp := range problems {
+        actual[i] = fmt.Sprintf("%s [%s] %v", fp.Kind, fp.RelPath, fp.WasRepaired)
+    }
+
+    expected := []string {
+        "path-hash-mismatch [] true",
+        "bad-path-hash [dir] false",
+    }
+
+    if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+        t.Fatalf("Problems not correct:\n%s", strings.Join(actual, "\n"))
+    }
+
+    cr.Close()
+
+    // A scan puts the original hashes back and counts the symlinks again.
+    cr, secondRootHash, err := scanIntoCatalog(&scanPath, &catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not rescan.")
+    }
+
+    defer cr.Close()
+
+    if secondRootHash != rootHash {
+        t.Fatalf("Root hash not restored by scan: [%s] != [%s]", secondRootHash, rootHash)
+    }
+
+    problems, err = cr.CheckCatalog(false)
+    if err != nil {
+        t.Fatalf("Could not check rescanned catalog.")
+    } else if len(problems) != 0 {
+        t.Fatalf("Rescanned catalog has problems: %v", problems)
+    }
+}
@@ -0,0 +1,137 @@
+package pfinternal
+
+import (
+    "sort"
+    "errors"
+    "strings"
+
+    "database/sql"
+)
+
+// Return every path and file in the catalog that has the given hash. The
+// results are ordered like a tree and will be empty if nothing matches.
+func (self *catalogResource) FindByHash(hash string) (results []*ResolveResult, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            results = nil
+            err = r.(error)
+
+            l.Error("Could not find by hash", "err", err)
+        }
+    }()
+
+    // We store hex-digests in lowercase.
+    hash = strings.ToLower(strings.TrimSpace(hash))
+    if hash == "" {
+        panic(errors.New("Hash is empty."))
+    }
+
+    results = make([]*ResolveResult, 0)
+
+    query :=
+        "SELECT " +
+            "`p`.`path_id`, " +
+            "`p`.`rel_path`, " +
+            "`p`.`last_check_epoch` " +
+        "FROM " +
+            "`paths` `p` " +
+        "WHERE " +
+            "`p`.`hash` = ?"
+
+    rows, err := self.db.Query(query, hash)
+    if err != nil {
+        panic(err)
+    }
+
+    defer rows.Close()
+
+    for rows.Next() {
+        var pathId int
+        var relPath string
+        var lastCheckEpoch sql.NullInt64
+
+        err = rows.Scan(&pathId, &relPath, &lastCheckEpoch)
+        if err != nil {
+            panic(err)
+        }
+
+        rr := &ResolveResult {
+                RelPath: relPath,
+                PathId: pathId,
+                Hash: hash,
+                LastCheckEpoch: lastCheckEpoch.Int64,
+        }
+
+        results = append(results, rr)
+    }
+
+    err = rows.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    query =
+        "SELECT " +
+            "`p`.`path_id`, " +
+            "`p`.`rel_path`, " +
+            "`f`.`file_id`, " +
+            "`f`.`filename`, " +
+            "`f`.`mtime_epoch`, " +
+            "`f`.`size`, " +
+            "`f`.`last_check_epoch` " +
+        "FROM " +
+            "`files` `f`, " +
+            "`paths` `p` " +
+        "WHERE " +
+            "`p`.`path_id` = `f`.`path_id` AND " +
+            "`f`.`hash` = ?"
+
+    fileRows, err := self.db.Query(query, hash)
+    if err != nil {
+        panic(err)
+    }
+
+    defer fileRows.Close()
+
+    for fileRows.Next() {
+        var pathId int
+        var relPath string
+        var fileId int
+        var filename string
+        var mtimeEpoch int64
+        var size sql.NullInt64
+        var lastCheckEpoch sql.NullInt64
+
+        err = fileRows.Scan(&pathId, &relPath, &fileId, &filename, &mtimeEpoch, &size, &lastCheckEpoch)
+        if err != nil {
+            panic(err)
+        }
+
+        rr := &ResolveResult {
+                RelPath: relPath,
+                PathId: pathId,
+                Filename: filename,
+                FileId: fileId,
+                Hash: hash,
+                Mtime: &mtimeEpoch,
+                LastCheckEpoch: lastCheckEpoch.Int64,
+        }
+
+        if size.Valid == true {
+            rr.Size = &size.Int64
+        }
+
+        results = append(results, rr)
+    }
+
+    err = fileRows.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    sort.Sort(resolveResultsByPath(results))
+
+    return results, nil
+}
@@ -0,0 +1,707 @@
+package pfinternal
+
+import (
+    "time"
+    "sort"
+
+    "database/sql"
+)
+
+// A scan that was recorded while history was enabled. EndEpoch is nil and
+// RootHash is empty if the scan never finished. A baseline records every entry
+// in the catalog (as created) rather than what changed.
+type ScanRecord struct {
+    ScanId int              `json:"scan_id"`
+    StartEpoch int64        `json:"start_epoch"`
+    EndEpoch *int64         `json:"end_epoch"`
+    RootHash string         `json:"root_hash"`
+    Creates int             `json:"creates"`
+    Updates int             `json:"updates"`
+    Deletes int             `json:"deletes"`
+    IsBaseline bool         `json:"is_baseline"`
+}
+
+// Whether the scan finished. The changes of scans that didn't aren't replayed.
+func (self *ScanRecord) IsComplete() bool {
+    return self.EndEpoch != nil
+}
+
+// A change that was recorded during a scan.
+type ScanChange struct {
+    ScanId int              `json:"scan_id"`
+    StartEpoch int64        `json:"start_epoch"`
+    EntityType int          `json:"-"`
+    ChangeType int          `json:"-"`
+    RelPath string          `json:"rel_path"`
+    Hash string             `json:"hash"`
+    PreviousHash string     `json:"previous_hash"`
+}
+
+// Return the change as an event.
+func (self *ScanChange) ChangeEvent() *ChangeEvent {
+    return &ChangeEvent {
+            EntityType: self.EntityType,
+            ChangeType: self.ChangeType,
+            RelPath: self.RelPath,
+            Hash: self.Hash,
+            PreviousHash: self.PreviousHash,
+    }
+}
+
+type scanChangesInTreeOrder []*ScanChange
+
+func (self scanChangesInTreeOrder) Len() int {
+    return len(self)
+}
+
+func (self scanChangesInTreeOrder) Swap(i, j int) {
+    self[i], self[j] = self[j], self[i]
+}
+
+func (self scanChangesInTreeOrder) Less(i, j int) bool {
+    return changeEventsByPath { self[i].ChangeEvent(), self[j].ChangeEvent() }.Less(0, 1)
+}
+
+// Whether scans of this catalog are recorded.
+func (self *catalogResource) HistoryEnabled() (isEnabled bool, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            isEnabled = false
+            err = r.(error)
+
+            l.Error("Could not check whether history is enabled", "err", err)
+        }
+    }()
+
+    query :=
+        "SELECT " +
+            "`ci`.`value` " +
+        "FROM " +
+            "`catalog_info` `ci` " +
+        "WHERE " +
+            "`ci`.`key` = 'history_enabled'"
+
+    var value string
+
+    err = self.db.QueryRow(query).Scan(&value)
+    if err == sql.ErrNoRows {
+        return false, nil
+    } else if err != nil {
+        panic(err)
+    }
+
+    return value == "1", nil
+}
+
+// Turn the recording of scans on or off. Whenever it's turned on, the current
+// entries are recorded as a baseline scan, so that later changes have
+// something to be compared to and whatever changed while it was off isn't
+// lost.
+func (self *catalogResource) SetHistoryEnabled(isEnabled bool) (err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            err = r.(error)
+            l.Error("Could not set history", "err", err)
+        }
+    }()
+
+    wasEnabled, err := self.HistoryEnabled()
+    if err != nil {
+        panic(err)
+    }
+
+    value := "0"
+    if isEnabled == true {
+        value = "1"
+    }
+
+    query :=
+        "INSERT OR REPLACE INTO `catalog_info` " +
+            "(`key`, `value`) " +
+        "VALUES " +
+            "('history_enabled', ?)"
+
+    _, err = self.db.Exec(query, value)
+    if err != nil {
+        panic(err)
+    }
+
+    if isEnabled == false || wasEnabled == true {
+        return nil
+    }
+
+    var scanCount int
+
+    query = "SELECT COUNT(*) FROM `scans`"
+
+    err = self.db.QueryRow(query).Scan(&scanCount)
+    if err != nil {
+        panic(err)
+    }
+
+    rootRelPath := ""
+    rootHash, err := self.getLastPathHash(&rootRelPath)
+    if err == ErrPathNotFound {
+        // Nothing has been scanned yet, so the first scan will be the
+        // baseline, unless there are older scans that it would be replayed
+        // on top of.
+        if scanCount == 0 {
+            return nil
+        }
+
+        rootHash = nil
+    } else if err != nil {
+        panic(err)
+    }
+
+    err = self.recordBaselineScan(rootHash)
+    if err != nil {
+        panic(err)
+    }
+
+    return nil
+}
+
+// Record every path and file currently in the catalog as having been created
+// by a single (baseline) scan.
+func (self *catalogResource) recordBaselineScan(rootHash *string) (err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            err = r.(error)
+            l.Error("Could not record baseline scan", "err", err)
+        }
+    }()
+
+    nowEpoch := time.Now().Unix()
+
+    tx, err := self.db.Begin()
+    if err != nil {
+        panic(err)
+    }
+
+    query :=
+        "INSERT INTO `scans` " +
+            "(`start_epoch`, `end_epoch`, `root_hash`, `is_baseline`) " +
+        "VALUES " +
+            "(?, ?, ?, 1)"
+
+    r, err := tx.Exec(query, nowEpoch, nowEpoch, rootHash)
+    if err != nil {
+        tx.Rollback()
+        panic(err)
+    }
+
+    scanId, err := r.LastInsertId()
+    if err != nil {
+        tx.Rollback()
+        panic(err)
+    }
+
+    query =
+        "INSERT INTO `scan_changes` " +
+            "(`scan_id`, `entity_type`, `change_type`, `rel_path`, `hash`) " +
+        "SELECT " +
+            "?, 'path', 'create', `p`.`rel_path`, `p`.`hash` " +
+        "FROM " +
+            "`paths` `p`"
+
+    r, err = tx.Exec(query, scanId)
+    if err != nil {
+        tx.Rollback()
+        panic(err)
+    }
+
+    pathCount, err := r.RowsAffected()
+    if err != nil {
+        tx.Rollback()
+        panic(err)
+    }
+
+    query =
+        "INSERT INTO `scan_changes` " +
+            "(`scan_id`, `entity_type`, `change_type`, `rel_path`, `hash`) " +
+        "SELECT " +
+            "?, 'file', 'create', " +
+            "CASE WHEN `p`.`rel_path` = '' THEN `f`.`filename` ELSE `p`.`rel_path` || '/' || `f`.`filename` END, " +
+            "`f`.`hash` " +
+        "FROM " +
+            "`files` `f`, " +
+            "`paths` `p` " +
+        "WHERE " +
+            "`p`.`path_id` = `f`.`path_id`"
+
+    r, err = tx.Exec(query, scanId)
+    if err != nil {
+        tx.Rollback()
+        panic(err)
+    }
+
+    fileCount, err := r.RowsAffected()
+    if err != nil {
+        tx.Rollback()
+        panic(err)
+    }
+
+    query =
+        "UPDATE " +
+            "`scans` " +
+        "SET " +
+            "`create_count` = ? " +
+        "WHERE " +
+            "`scan_id` = ?"
+
+    _, err = tx.Exec(query, pathCount + fileCount, scanId)
+    if err != nil {
+        tx.Rollback()
+        panic(err)
+    }
+
+    err = tx.Commit()
+    if err != nil {
+        panic(err)
+    }
+
+    l.Debug("Recorded baseline scan.", "scanId", scanId, "paths", pathCount, "files", fileCount)
+
+    return nil
+}
+
+// The number of changes that a HistoryChangeSink holds before writing them.
+const historyBatchSize = 1000
+
+// Records a scan and its changes in the catalog. The scan is recorded when
+// the sink begins and the changes are written in batches as they arrive, so
+// the catalog has to stay open until the sink has been ended. A scan that
+// fails (or never ends) is left without an end-time or root hash, and its
+// changes aren't replayed. Since the catalog might have been partly updated
+// by it, the next scan starts with a baseline.
+type HistoryChangeSink struct {
+    cr *catalogResource
+    batchSize int
+    changes []*ChangeEvent
+    counts map[int]int
+    scanId int64
+    isFinished bool
+}
+
+func (self *catalogResource) NewHistoryChangeSink() *HistoryChangeSink {
+    hcs := HistoryChangeSink {
+            cr: self,
+            batchSize: historyBatchSize,
+            changes: make([]*ChangeEvent, 0),
+            counts: make(map[int]int),
+    }
+
+    return &hcs
+}
+
+// The ID of the recorded scan, or (0) if the sink hasn't begun.
+func (self *HistoryChangeSink) ScanId() int {
+    return int(self.scanId)
+}
+
+func (self *HistoryChangeSink) Begin() (err error) {
+    l := NewLogger("history_change_sink")
+
+    defer func() {
+        if r := recover(); r != nil {
+            err = r.(error)
+            l.Error("Could not begin scan", "err", err)
+        }
+    }()
+
+    self.changes = self.changes[:0]
+    self.counts = make(map[int]int)
+    self.scanId = 0
+    self.isFinished = false
+
+    query :=
+        "SELECT " +
+            "`s`.`end_epoch` " +
+        "FROM " +
+            "`scans` `s` " +
+        "ORDER BY " +
+            "`s`.`scan_id` DESC " +
+        "LIMIT 1"
+
+    var endEpoch sql.NullInt64
+
+    err = self.cr.db.QueryRow(query).Scan(&endEpoch)
+    if err != nil && err != sql.ErrNoRows {
+        panic(err)
+    }
+
+    // The last scan didn't finish, so we don't know what it changed.
+    if err == nil && endEpoch.Valid == false {
+        rootRelPath := ""
+        rootHash, err := self.cr.getLastPathHash(&rootRelPath)
+        if err == ErrPathNotFound {
+            rootHash = nil
+        } else if err != nil {
+            panic(err)
+        }
+
+        err = self.cr.recordBaselineScan(rootHash)
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    query =
+        "INSERT INTO `scans` " +
+            "(`start_epoch`, `create_count`, `update_count`, `delete_count`) " +
+        "VALUES " +
+            "(?, 0, 0, 0)"
+
+    r, err := self.cr.db.Exec(query, time.Now().Unix())
+    if err != nil {
+        panic(err)
+    }
+
+    self.scanId, err = r.LastInsertId()
+    if err != nil {
+        panic(err)
+    }
+
+    return nil
+}
+
+func (self *HistoryChangeSink) Event(ce *ChangeEvent) error {
+    self.changes = append(self.changes, ce)
+    self.counts[ce.ChangeType]++
+
+    if len(self.changes) >= self.batchSize {
+        return self.flush()
+    }
+
+    return nil
+}
+
+// Write the changes that are being held.
+func (self *HistoryChangeSink) flush() (err error) {
+    l := NewLogger("history_change_sink")
+
+    defer func() {
+        if r := recover(); r != nil {
+            err = r.(error)
+            l.Error("Could not write scan changes", "err", err)
+        }
+    }()
+
+    if len(self.changes) == 0 {
+        return nil
+    }
+
+    tx, err := self.cr.db.Begin()
+    if err != nil {
+        panic(err)
+    }
+
+    query :=
+        "INSERT INTO `scan_changes` " +
+            "(`scan_id`, `entity_type`, `change_type`, `rel_path`, `hash`, `previous_hash`) " +
+        "VALUES " +
+            "(?, ?, ?, ?, ?, ?)"
+
+    stmt, err := tx.Prepare(query)
+    if err != nil {
+        tx.Rollback()
+        panic(err)
+    }
+
+    defer stmt.Close()
+
+    for _, ce := range self.changes {
+        var hash *string
+        if ce.Hash != "" {
+            hash = &ce.Hash
+        }
+
+        var previousHash *string
+        if ce.PreviousHash != "" {
+            previousHash = &ce.PreviousHash
+        }
+
+        _, err = stmt.Exec(self.scanId, EntityTypeName(ce.EntityType), UpdateTypeName(ce.ChangeType), ce.RelPath, hash, previousHash)
+        if err != nil {
+            tx.Rollback()
+            panic(err)
+        }
+    }
+
+    err = tx.Commit()
+    if err != nil {
+        panic(err)
+    }
+
+    l.Debug("Wrote scan changes.", "scanId", self.scanId, "changes", len(self.changes))
+
+    self.changes = self.changes[:0]
+
+    return nil
+}
+
+// Write whatever changes are still held and the counts, and, if the scan is
+// complete, the end-time and root hash.
+func (self *HistoryChangeSink) finish(isComplete bool) (err error) {
+    l := NewLogger("history_change_sink")
+
+    defer func() {
+        if r := recover(); r != nil {
+            err = r.(error)
+            l.Error("Could not record scan", "err", err)
+        }
+    }()
+
+    err = self.flush()
+    if err != nil {
+        panic(err)
+    }
+
+    var endEpoch *int64
+    var rootHash *string
+
+    if isComplete == true {
+        nowEpoch := time.Now().Unix()
+        endEpoch = &nowEpoch
+
+        rootRelPath := ""
+        rootHash, err = self.cr.getLastPathHash(&rootRelPath)
+        if err != nil && err != ErrPathNotFound {
+            panic(err)
+        }
+    }
+
+    query :=
+        "UPDATE " +
+            "`scans` " +
+        "SET " +
+            "`end_epoch` = ?, " +
+            "`root_hash` = ?, " +
+            "`create_count` = ?, " +
+            "`update_count` = ?, " +
+            "`delete_count` = ? " +
+        "WHERE " +
+            "`scan_id` = ?"
+
+    _, err = self.cr.db.Exec(query,
+                endEpoch,
+                rootHash,
+                self.counts[UpdateTypeCreate],
+                self.counts[UpdateTypeUpdate],
+                self.counts[UpdateTypeDelete],
+                self.scanId)
+
+    if err != nil {
+        panic(err)
+    }
+
+    self.isFinished = true
+
+    l.Debug("Recorded scan.", "scanId", self.scanId, "isComplete", isComplete)
+
+    return nil
+}
+
+// Record the end of the scan, unless it was already recorded as having 
+// failed.
+func (self *HistoryChangeSink) End() error {
+    if self.isFinished == true {
+        return nil
+    }
+
+    return self.finish(true)
+}
+
+// Record what we saw of the scan. There's nowhere to report a failure to. 
+// This can be called before the sink is ended if the scan didn't fail but its 
+// changes can't be trusted.
+func (self *HistoryChangeSink) Error(err error) {
+    if self.isFinished == true {
+        return
+    }
+
+    self.finish(false)
+}
+
+// Load the scans that match the given condition, oldest first.
+func (self *catalogResource) loadScans(condition string, args ...interface{}) (scans []*ScanRecord, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            scans = nil
+            err = r.(error)
+
+            l.Error("Could not load scans", "err", err)
+        }
+    }()
+
+    query :=
+        "SELECT " +
+            "`s`.`scan_id`, " +
+            "`s`.`start_epoch`, " +
+            "`s`.`end_epoch`, " +
+            "`s`.`root_hash`, " +
+            "`s`.`create_count`, " +
+            "`s`.`update_count`, " +
+            "`s`.`delete_count`, " +
+            "`s`.`is_baseline` " +
+        "FROM " +
+            "`scans` `s` " +
+        "WHERE " +
+            condition + " " +
+        "ORDER BY " +
+            "`s`.`scan_id` ASC"
+
+    rows, err := self.db.Query(query, args...)
+    if err != nil {
+        panic(err)
+    }
+
+    defer rows.Close()
+
+    scans = make([]*ScanRecord, 0)
+
+    for rows.Next() {
+        sr := &ScanRecord {}
+
+        var endEpoch sql.NullInt64
+        var rootHash sql.NullString
+
+        err = rows.Scan(&sr.ScanId, &sr.StartEpoch, &endEpoch, &rootHash, &sr.Creates, &sr.Updates, &sr.Deletes, &sr.IsBaseline)
+        if err != nil {
+            panic(err)
+        }
+
+        if endEpoch.Valid == true {
+            sr.EndEpoch = &endEpoch.Int64
+        }
+
+        sr.RootHash = rootHash.String
+
+        scans = append(scans, sr)
+    }
+
+    err = rows.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    return scans, nil
+}
+
+// Return every recorded scan, oldest first.
+func (self *catalogResource) ListScans() (scans []*ScanRecord, err error) {
+    return self.loadScans("1 = 1")
+}
+
+// Load the changes that match the given condition, scan by scan and in the
+// order that they were recorded within each scan. Folding scans (see
+// ApplyRetention) gives the changes new IDs, so the IDs alone don't order
+// them across scans.
+func (self *catalogResource) loadScanChanges(condition string, args ...interface{}) (changes []*ScanChange, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            changes = nil
+            err = r.(error)
+
+            l.Error("Could not load scan changes", "err", err)
+        }
+    }()
+
+    query :=
+        "SELECT " +
+            "`sc`.`scan_id`, " +
+            "`s`.`start_epoch`, " +
+            "`sc`.`entity_type`, " +
+            "`sc`.`change_type`, " +
+            "`sc`.`rel_path`, " +
+            "`sc`.`hash`, " +
+            "`sc`.`previous_hash` " +
+        "FROM " +
+            "`scan_changes` `sc`, " +
+            "`scans` `s` " +
+        "WHERE " +
+            "`s`.`scan_id` = `sc`.`scan_id` AND " +
+            "(" + condition + ") " +
+        "ORDER BY " +
+            "`sc`.`scan_id` ASC, " +
+            "`sc`.`scan_change_id` ASC"
+
+    rows, err := self.db.Query(query, args...)
+    if err != nil {
+        panic(err)
+    }
+
+    defer rows.Close()
+
+    changes = make([]*ScanChange, 0)
+
+    for rows.Next() {
+        sc := &ScanChange {}
+
+        var entityTypeName string
+        var changeTypeName string
+        var hash sql.NullString
+        var previousHash sql.NullString
+
+        err = rows.Scan(&sc.ScanId, &sc.StartEpoch, &entityTypeName, &changeTypeName, &sc.RelPath, &hash, &previousHash)
+        if err != nil {
+            panic(err)
+        }
+
+        sc.EntityType, err = EntityTypeFromName(entityTypeName)
+        if err != nil {
+            panic(err)
+        }
+
+        sc.ChangeType, err = UpdateTypeFromName(changeTypeName)
+        if err != nil {
+            panic(err)
+        }
+
+        sc.Hash = hash.String
+        sc.PreviousHash = previousHash.String
+
+        changes = append(changes, sc)
+    }
+
+    err = rows.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    return changes, nil
+}
+
+// Return the changes that were recorded for the given scan, in tree order.
+func (self *catalogResource) GetScanChanges(scanId int) (changes []*ScanChange, err error) {
+    changes, err = self.loadScanChanges("`sc`.`scan_id` = ?", scanId)
+    if err != nil {
+        return nil, err
+    }
+
+    sort.Stable(scanChangesInTreeOrder(changes))
+
+    return changes, nil
+}
+
+// Return every recorded change to the given path or file, oldest first. 
+// Baseline scans show up as creates.
+func (self *catalogResource) GetTimeline(relPath *string) (changes []*ScanChange, err error) {
+    normalRelPath, err := normalizeManifestRelPath(*relPath)
+    if err != nil {
+        return nil, err
+    }
+
+    return self.loadScanChanges("`sc`.`rel_path` = ?", normalRelPath)
+}
@@ -0,0 +1,304 @@
+package pfinternal
+
+import (
+    "fmt"
+    "time"
+    "errors"
+    "strconv"
+    "strings"
+
+    "database/sql"
+)
+
+// The ways that a time can be given to FindScan(). Times without a zone are
+// local.
+var scanTimeLayouts = []string {
+    time.RFC3339,
+    "2006-01-02T15:04:05",
+    "2006-01-02 15:04:05",
+    "2006-01-02T15:04",
+    "2006-01-02 15:04",
+    "2006-01-02",
+}
+
+func parseScanTime(value string) (t time.Time, err error) {
+    for _, layout := range scanTimeLayouts {
+        t, err = time.ParseInLocation(layout, value, time.Local)
+        if err == nil {
+            return t, nil
+        }
+    }
+
+    return time.Time {}, errors.New(fmt.Sprintf("Not a scan ID or a time: [%s]", value))
+}
+
+// Find a recorded scan. This is either a scan ID or a time (like "2016-01-31"
+// or "2016-01-31 12:00:00"), in which case it's the last scan that started at
+// or before that time. Scans that didn't finish aren't found.
+func (self *catalogResource) FindScan(asOf string) (sr *ScanRecord, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            sr = nil
+            err = r.(error)
+
+            l.Error("Could not find scan", "asOf", asOf, "err", err)
+        }
+    }()
+
+    var scans []*ScanRecord
+
+    if scanId, err := strconv.Atoi(asOf); err == nil {
+        scans, err = self.loadScans("`s`.`scan_id` = ? AND `s`.`end_epoch` IS NOT NULL", scanId)
+        if err != nil {
+            panic(err)
+        }
+    } else {
+        t, err := parseScanTime(asOf)
+        if err != nil {
+            panic(err)
+        }
+
+        scans, err = self.loadScans("`s`.`start_epoch` <= ? AND `s`.`end_epoch` IS NOT NULL", t.Unix())
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    if len(scans) == 0 {
+        panic(ErrScanNotFound)
+    }
+
+    return scans[len(scans) - 1], nil
+}
+
+// Whether the given path is the base path or below it.
+func isRelPathAtOrBelow(baseRelPath string, relPath string) bool {
+    if baseRelPath == "" || relPath == baseRelPath {
+        return true
+    }
+
+    return strings.HasPrefix(relPath, baseRelPath + "/")
+}
+
+// Return the ID of the last baseline scan at or before the given scan, which
+// is where replaying the history for it starts. This is (0) if there isn't
+// one.
+func (self *catalogResource) findBaselineScanId(scanId int) (baselineScanId int, err error) {
+    query :=
+        "SELECT " +
+            "MAX(`s`.`scan_id`) " +
+        "FROM " +
+            "`scans` `s` " +
+        "WHERE " +
+            "`s`.`is_baseline` = 1 AND " +
+            "`s`.`end_epoch` IS NOT NULL AND " +
+            "`s`.`scan_id` <= ?"
+
+    var found sql.NullInt64
+
+    err = self.db.QueryRow(query, scanId).Scan(&found)
+    if err != nil {
+        return 0, err
+    }
+
+    return int(found.Int64), nil
+}
+
+// The condition for the changes that are replayed to get to the given scan:
+// those of the finished scans from the last baseline up to it.
+func (self *catalogResource) replayedChangesCondition(scanId int) (condition string, args []interface{}, err error) {
+    baselineScanId, err := self.findBaselineScanId(scanId)
+    if err != nil {
+        return "", nil, err
+    }
+
+    condition =
+        "`sc`.`scan_id` >= ? AND " +
+        "`sc`.`scan_id` <= ? AND " +
+        "`s`.`end_epoch` IS NOT NULL"
+
+    return condition, []interface{} { baselineScanId, scanId }, nil
+}
+
+// Replay the recorded changes to get the hashes of every path and file, at or
+// below the given path, as of the given scan.
+func (self *catalogResource) loadHistoricalHashes(scanId int, baseRelPath string) (paths map[string]string, files map[string]string, err error) {
+    condition, args, err := self.replayedChangesCondition(scanId)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    changes, err := self.loadScanChanges(condition, args...)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    paths = make(map[string]string)
+    files = make(map[string]string)
+
+    for _, sc := range changes {
+        if isRelPathAtOrBelow(baseRelPath, sc.RelPath) == false {
+            continue
+        }
+
+        hashes := files
+        if sc.EntityType == EntityTypePath {
+            hashes = paths
+        }
+
+        if sc.ChangeType == UpdateTypeDelete {
+            delete(hashes, sc.RelPath)
+        } else {
+            hashes[sc.RelPath] = sc.Hash
+        }
+    }
+
+    return paths, files, nil
+}
+
+// Return the change that gave the path or file the hash that it had as of the
+// given scan. This says what the hash was and when it last changed.
+func (self *catalogResource) ResolvePathAsOf(relPath *string, scanId int) (sc *ScanChange, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            sc = nil
+            err = r.(error)
+
+            l.Error("Could not resolve historical path", "relPath", *relPath, "scanId", scanId, "err", err)
+        }
+    }()
+
+    normalRelPath, err := normalizeManifestRelPath(*relPath)
+    if err != nil {
+        panic(err)
+    }
+
+    condition, args, err := self.replayedChangesCondition(scanId)
+    if err != nil {
+        panic(err)
+    }
+
+    changes, err := self.loadScanChanges("`sc`.`rel_path` = ? AND " + condition, append([]interface{} { normalRelPath }, args...)...)
+    if err != nil {
+        panic(err)
+    }
+
+    // A path and a file might have had the same name at different times, so
+    // we track them separately.
+    last := make(map[int]*ScanChange)
+    for _, change := range changes {
+        last[change.EntityType] = change
+    }
+
+    for _, entityType := range []int { EntityTypePath, EntityTypeFile } {
+        change, found := last[entityType]
+        if found == true && change.ChangeType != UpdateTypeDelete {
+            return change, nil
+        }
+    }
+
+    if normalRelPath == "" {
+        panic(ErrRootNotRecorded)
+    }
+
+    panic(ErrPathNotFound)
+}
+
+// Return an event for every entry that's only in one of the sets or that has
+// a different hash in each of them.
+func diffHistoricalHashes(entityType int, from map[string]string, to map[string]string) []*ChangeEvent {
+    events := make([]*ChangeEvent, 0)
+
+    for relPath, fromHash := range from {
+        toHash, found := to[relPath]
+        if found == false {
+            events = append(events, &ChangeEvent {
+                    EntityType: entityType,
+                    ChangeType: UpdateTypeDelete,
+                    RelPath: relPath,
+                    PreviousHash: fromHash,
+            })
+        } else if toHash != fromHash {
+            events = append(events, &ChangeEvent {
+                    EntityType: entityType,
+                    ChangeType: UpdateTypeUpdate,
+                    RelPath: relPath,
+                    Hash: toHash,
+                    PreviousHash: fromHash,
+            })
+        }
+    }
+
+    for relPath, toHash := range to {
+        if _, found := from[relPath]; found == false {
+            events = append(events, &ChangeEvent {
+                    EntityType: entityType,
+                    ChangeType: UpdateTypeCreate,
+                    RelPath: relPath,
+                    Hash: toHash,
+            })
+        }
+    }
+
+    return events
+}
+
+// Emit an event to the sink, in tree order, for every path and file that was
+// created, updated, or deleted between two recorded scans, at or below the
+// given path. Returns the number of events.
+func (self *catalogResource) DiffScans(fromScanId int, toScanId int, relPath *string, cs ChangeSink) (count int, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            count = 0
+            err = r.(error)
+
+            l.Error("Could not diff scans", "err", err)
+        }
+    }()
+
+    normalRelPath, err := normalizeManifestRelPath(*relPath)
+    if err != nil {
+        panic(err)
+    }
+
+    fromPaths, fromFiles, err := self.loadHistoricalHashes(fromScanId, normalRelPath)
+    if err != nil {
+        panic(err)
+    }
+
+    toPaths, toFiles, err := self.loadHistoricalHashes(toScanId, normalRelPath)
+    if err != nil {
+        panic(err)
+    }
+
+    _, isInFrom := fromPaths[normalRelPath]
+    _, isInTo := toPaths[normalRelPath]
+
+    if isInFrom == false && isInTo == false {
+        if normalRelPath == "" {
+            panic(ErrRootNotRecorded)
+        }
+
+        panic(ErrPathNotFound)
+    }
+
+    events := diffHistoricalHashes(EntityTypePath, fromPaths, toPaths)
+    events = append(events, diffHistoricalHashes(EntityTypeFile, fromFiles, toFiles)...)
+
+    SortChangeEvents(events)
+
+    for _, ce := range events {
+        err = cs.Event(ce)
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    return len(events), nil
+}
@@ -0,0 +1,204 @@
+package pfinternal
+
+import (
+    "testing"
+    "os"
+    "path"
+    "time"
+    "bytes"
+
+    "io/ioutil"
+)
+
+// Record two scans: the baseline, and then one where "aa" was changed and
+// "dir/bb" was replaced with "dir/cc".
+func recordTestHistory(t *testing.T, catalogFilepath string, scanPath string) *catalogResource {
+    hashAlgorithm := HashAlgorithm
+
+    os.MkdirAll(path.Join(scanPath, "dir"), 0755)
+
+    ioutil.WriteFile(path.Join(scanPath, "aa"), []byte("aa"), 0644)
+    ioutil.WriteFile(path.Join(scanPath, "dir", "bb"), []byte("bb"), 0644)
+
+    cr, _, err := scanIntoCatalog(&scanPath, &catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not do first scan.")
+    }
+
+    err = cr.SetHistoryEnabled(true)
+    if err != nil {
+        cr.Close()
+        t.Fatalf("Could not enable history.")
+    }
+
+    cr.Close()
+
+    ioutil.WriteFile(path.Join(scanPath, "aa"), []byte("aa2"), 0644)
+    os.Remove(path.Join(scanPath, "dir", "bb"))
+    ioutil.WriteFile(path.Join(scanPath, "dir", "cc"), []byte("cc"), 0644)
+
+    time.Sleep(time.Second * 1)
+
+    cr, _, err = scanIntoCatalog(&scanPath, &catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not do second scan.")
+    }
+
+    return cr
+}
+
+func TestResolvePathAsOf(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    cr := recordTestHistory(t, catalogFilepath, scanPath)
+    defer cr.Close()
+
+    relPath := "aa"
+
+    before, err := cr.ResolvePathAsOf(&relPath, 1)
+    if err != nil {
+        t.Fatalf("Could not resolve file as of the first scan.")
+    }
+
+    after, err := cr.ResolvePathAsOf(&relPath, 2)
+    if err != nil {
+        t.Fatalf("Could not resolve file as of the second scan.")
+    }
+
+    current, err := cr.ResolvePath(&relPath)
+    if err != nil {
+        t.Fatalf("Could not resolve current file.")
+    }
+
+    if before.ScanId != 1 || after.ScanId != 2 {
+        t.Fatalf("Changes are from the wrong scans: (%d) (%d)", before.ScanId, after.ScanId)
+    } else if after.Hash != current.Hash || before.Hash == after.Hash {
+        t.Fatalf("Historical hashes not correct: [%s] [%s]", before.Hash, after.Hash)
+    }
+
+    relPath = "dir/bb"
+
+    _, err = cr.ResolvePathAsOf(&relPath, 1)
+    if err != nil {
+        t.Fatalf("Could not resolve deleted file as of the first scan.")
+    }
+
+    _, err = cr.ResolvePathAsOf(&relPath, 2)
+    if err != ErrPathNotFound {
+        t.Fatalf("Expected deleted file to not be found: %v", err)
+    }
+
+    relPath = "dir/cc"
+
+    _, err = cr.ResolvePathAsOf(&relPath, 1)
+    if err != ErrPathNotFound {
+        t.Fatalf("Expected new file to not be found: %v", err)
+    }
+}
+
+func TestFindScan(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    cr := recordTestHistory(t, catalogFilepath, scanPath)
+    defer cr.Close()
+
+    sr, err := cr.FindScan("2")
+    if err != nil || sr.ScanId != 2 {
+        t.Fatalf("Could not find scan by ID.")
+    }
+
+    _, err = cr.FindScan("3")
+    if err != ErrScanNotFound {
+        t.Fatalf("Expected scan to not be found: %v", err)
+    }
+
+    _, err = cr.FindScan("2000-01-01")
+    if err != ErrScanNotFound {
+        t.Fatalf("Expected no scan before the time: %v", err)
+    }
+
+    sr, err = cr.FindScan(time.Now().Add(time.Hour).Format(time.RFC3339))
+    if err != nil || sr.ScanId != 2 {
+        t.Fatalf("Could not find last scan by time.")
+    }
+
+    _, err = cr.FindScan("yesterday")
+    if err == nil {
+        t.Fatalf("Expected error for invalid time.")
+    }
+}
+
+func TestDiffScans(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    cr := recordTestHistory(t, catalogFilepath, scanPath)
+    defer cr.Close()
+
+    cases := []struct {
+        fromScanId int
+        toScanId int
+        relPath string
+        expected string
+    } {
+        { 1, 2, "", "update path .\nupdate file aa\nupdate path dir\ndelete file dir/bb\ncreate file dir/cc\n" },
+        { 2, 1, "dir", "update path dir\ncreate file dir/bb\ndelete file dir/cc\n" },
+        { 2, 2, "", "" },
+    }
+
+    for i, c := range cases {
+        b := new(bytes.Buffer)
+        rcs := NewReportChangeSink(b, false)
+
+        count, err := cr.DiffScans(c.fromScanId, c.toScanId, &c.relPath, rcs)
+        if err != nil {
+            t.Fatalf("Could not diff scans for case (%d).", i)
+        }
+
+        rcs.End()
+
+        if b.String() != c.expected {
+            t.Fatalf("Diff not correct for case (%d):\n%s", i, b.String())
+        } else if count != bytes.Count(b.Bytes(), []byte("\n")) {
+            t.Fatalf("Count not correct for case (%d): (%d)", i, count)
+        }
+    }
+
+    relPath := "nothere"
+    _, err := cr.DiffScans(1, 2, &relPath, NewReportChangeSink(new(bytes.Buffer), false))
+    if err != ErrPathNotFound {
+        t.Fatalf("Expected path to not be found: %v", err)
+    }
+}
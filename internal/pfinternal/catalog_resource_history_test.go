@@ -0,0 +1,449 @@
+package pfinternal
+
+import (
+    "testing"
+    "os"
+    "path"
+    "time"
+    "fmt"
+    "strings"
+    "reflect"
+    "bytes"
+
+    "io/ioutil"
+)
+
+// Make sure that replaying the history up to the given scan gets us what the
+// catalog has now.
+func checkReplayMatchesCatalog(t *testing.T, cr *catalogResource, scanId int) {
+    historicalPaths, historicalFiles, err := cr.loadHistoricalHashes(scanId, "")
+    if err != nil {
+        t.Fatalf("Could not replay history.")
+    }
+
+    currentPaths, currentFiles, err := cr.loadGraftHashes("")
+    if err != nil {
+        t.Fatalf("Could not load current hashes.")
+    }
+
+    if reflect.DeepEqual(historicalPaths, currentPaths) == false {
+        t.Fatalf("Replayed paths not correct: %v != %v", historicalPaths, currentPaths)
+    } else if reflect.DeepEqual(historicalFiles, currentFiles) == false {
+        t.Fatalf("Replayed files not correct: %v != %v", historicalFiles, currentFiles)
+    }
+}
+
+// Record a scan that fails after seeing part of what changed (a file named
+// "partial" that isn't really there).
+func recordFailedTestScan(t *testing.T, cr *catalogResource) {
+    hcs := cr.NewHistoryChangeSink()
+
+    err := hcs.Begin()
+    if err != nil {
+        t.Fatalf("Could not begin failed scan.")
+    }
+
+    hcs.Event(&ChangeEvent {
+        EntityType: EntityTypeFile,
+        ChangeType: UpdateTypeCreate,
+        RelPath: "partial",
+        Hash: "da39a3ee5e6b4b0d3255bfef95601890afd80709",
+    })
+
+    hcs.Error(ErrFileChanged)
+
+    // Ending the sink after the failure doesn't record it again.
+    err = hcs.End()
+    if err != nil {
+        t.Fatalf("Could not end failed scan.")
+    }
+}
+
+func TestScanHistory(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    os.MkdirAll(path.Join(scanPath, "dir"), 0755)
+
+    ioutil.WriteFile(path.Join(scanPath, "aa"), []byte("aa"), 0644)
+    ioutil.WriteFile(path.Join(scanPath, "dir", "bb"), []byte("bb"), 0644)
+
+    hashAlgorithm := HashAlgorithm
+
+    cr, _, err := scanIntoCatalog(&scanPath, &catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not do first scan.")
+    }
+
+    // What was already there is recorded as the first scan.
+    err = cr.SetHistoryEnabled(true)
+    if err != nil {
+        cr.Close()
+        t.Fatalf("Could not enable history.")
+    }
+
+    cr.Close()
+
+    ioutil.WriteFile(path.Join(scanPath, "aa"), []byte("aa2"), 0644)
+    os.Remove(path.Join(scanPath, "dir", "bb"))
+
+    // Deletions are found by their check-times, which have a resolution of a
+    // second.
+    time.Sleep(time.Second * 1)
+
+    cr, rootHash, err := scanIntoCatalog(&scanPath, &catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not do second scan.")
+    }
+
+    defer cr.Close()
+
+    scans, err := cr.ListScans()
+    if err != nil {
+        t.Fatalf("Could not list scans.")
+    } else if len(scans) != 2 {
+        t.Fatalf("Expected two scans: (%d)", len(scans))
+    }
+
+    if scans[0].Creates != 4 || scans[0].Updates != 0 || scans[0].Deletes != 0 || scans[0].IsBaseline == false {
+        t.Fatalf("Baseline counts not correct: %v", scans[0])
+    } else if scans[1].IsBaseline == true {
+        t.Fatalf("Scan shouldn't be a baseline: %v", scans[1])
+    } else if scans[1].Creates != 0 || scans[1].Updates != 3 || scans[1].Deletes != 1 {
+        t.Fatalf("Scan counts not correct: %v", scans[1])
+    } else if scans[1].EndEpoch == nil || scans[1].RootHash != rootHash {
+        t.Fatalf("Scan not closed correctly: %v", scans[1])
+    }
+
+    relPath := "aa"
+    timeline, err := cr.GetTimeline(&relPath)
+    if err != nil {
+        t.Fatalf("Could not get timeline.")
+    } else if len(timeline) != 2 {
+        t.Fatalf("Expected two changes in the timeline: (%d)", len(timeline))
+    }
+
+    created := timeline[0]
+    updated := timeline[1]
+
+    if created.ChangeType != UpdateTypeCreate || created.ScanId != scans[0].ScanId {
+        t.Fatalf("First change not correct: %v", created)
+    } else if updated.ChangeType != UpdateTypeUpdate || updated.ScanId != scans[1].ScanId {
+        t.Fatalf("Second change not correct: %v", updated)
+    } else if updated.PreviousHash != created.Hash || updated.Hash == created.Hash {
+        t.Fatalf("Timeline hashes not correct: %v %v", created, updated)
+    }
+
+    changes, err := cr.GetScanChanges(scans[1].ScanId)
+    if err != nil {
+        t.Fatalf("Could not get scan changes.")
+    }
+
+    actual := make([]string, len(changes))
+    for i, sc := range changes {
+        actual[i] = fmt.Sprintf("%s %s %s %v %v",
+                        UpdateTypeName(sc.ChangeType),
+                        EntityTypeName(sc.EntityType),
+                        sc.RelPath,
+                        sc.PreviousHash != "",
+                        sc.Hash != "")
+    }
+
+    expected := []string {
+        "update path  true true",
+        "update file aa true true",
+        "update path dir true true",
+        "delete file dir/bb true false",
+    }
+
+    if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+        t.Fatalf("Scan changes not correct:\n%s", strings.Join(actual, "\n"))
+    }
+}
+
+func TestScanHistoryDisabled(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    ioutil.WriteFile(path.Join(scanPath, "aa"), []byte("aa"), 0644)
+
+    hashAlgorithm := HashAlgorithm
+
+    cr, _, err := scanIntoCatalog(&scanPath, &catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not scan.")
+    }
+
+    defer cr.Close()
+
+    isEnabled, err := cr.HistoryEnabled()
+    if err != nil {
+        t.Fatalf("Could not check history.")
+    } else if isEnabled == true {
+        t.Fatalf("History should be disabled by default.")
+    }
+
+    scans, err := cr.ListScans()
+    if err != nil {
+        t.Fatalf("Could not list scans.")
+    } else if len(scans) != 0 {
+        t.Fatalf("Scans were recorded without history: (%d)", len(scans))
+    }
+}
+
+func TestScanHistoryGap(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    // Scans (1) and (2).
+    cr := recordTestHistory(t, catalogFilepath, scanPath)
+
+    err := cr.SetHistoryEnabled(false)
+    if err != nil {
+        cr.Close()
+        t.Fatalf("Could not disable history.")
+    }
+
+    cr.Close()
+
+    // Changed while nothing was being recorded.
+    ioutil.WriteFile(path.Join(scanPath, "gap"), []byte("gap"), 0644)
+    os.Remove(path.Join(scanPath, "dir", "cc"))
+
+    time.Sleep(time.Second * 1)
+
+    hashAlgorithm := HashAlgorithm
+
+    cr, _, err = scanIntoCatalog(&scanPath, &catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not scan without history.")
+    }
+
+    // Scan (3) is the baseline. Turning it on again doesn't record another.
+    for i := 0; i < 2; i++ {
+        err = cr.SetHistoryEnabled(true)
+        if err != nil {
+            cr.Close()
+            t.Fatalf("Could not enable history.")
+        }
+    }
+
+    cr.Close()
+
+    ioutil.WriteFile(path.Join(scanPath, "aa"), []byte("aa3"), 0644)
+
+    time.Sleep(time.Second * 1)
+
+    // Scan (4).
+    cr, _, err = scanIntoCatalog(&scanPath, &catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not scan with history.")
+    }
+
+    defer cr.Close()
+
+    scans, err := cr.ListScans()
+    if err != nil {
+        t.Fatalf("Could not list scans.")
+    } else if len(scans) != 4 {
+        t.Fatalf("Expected four scans: (%d)", len(scans))
+    } else if scans[2].IsBaseline == false || scans[3].IsBaseline == true {
+        t.Fatalf("Baseline not recorded when history was turned on again.")
+    }
+
+    checkReplayMatchesCatalog(t, cr, 4)
+
+    relPath := "gap"
+    _, err = cr.ResolvePathAsOf(&relPath, 4)
+    if err != nil {
+        t.Fatalf("File created while history was off not found.")
+    }
+
+    _, err = cr.ResolvePathAsOf(&relPath, 2)
+    if err != ErrPathNotFound {
+        t.Fatalf("File created while history was off found too early: %v", err)
+    }
+
+    relPath = "dir/cc"
+    _, err = cr.ResolvePathAsOf(&relPath, 4)
+    if err != ErrPathNotFound {
+        t.Fatalf("File deleted while history was off still found: %v", err)
+    }
+
+    b := new(bytes.Buffer)
+    rcs := NewReportChangeSink(b, false)
+
+    rootRelPath := ""
+    _, err = cr.DiffScans(2, 4, &rootRelPath, rcs)
+    if err != nil {
+        t.Fatalf("Could not diff across the gap.")
+    }
+
+    rcs.End()
+
+    expected := "update path .\nupdate file aa\nupdate path dir\ndelete file dir/cc\ncreate file gap\n"
+    if b.String() != expected {
+        t.Fatalf("Diff across the gap not correct:\n%s", b.String())
+    }
+}
+
+func TestScanHistoryFailedScan(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    // Scans (1) and (2).
+    cr := recordTestHistory(t, catalogFilepath, scanPath)
+
+    // Scan (3).
+    recordFailedTestScan(t, cr)
+
+    cr.Close()
+
+    ioutil.WriteFile(path.Join(scanPath, "ee"), []byte("ee"), 0644)
+
+    time.Sleep(time.Second * 1)
+
+    // Scans (4), the baseline, and (5).
+    hashAlgorithm := HashAlgorithm
+
+    cr, _, err := scanIntoCatalog(&scanPath, &catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not scan after failure.")
+    }
+
+    defer cr.Close()
+
+    scans, err := cr.ListScans()
+    if err != nil {
+        t.Fatalf("Could not list scans.")
+    } else if len(scans) != 5 {
+        t.Fatalf("Expected five scans: (%d)", len(scans))
+    } else if scans[2].IsComplete() == true || scans[2].Creates != 1 {
+        t.Fatalf("Failed scan not recorded correctly: %v", scans[2])
+    } else if scans[3].IsBaseline == false || scans[4].IsBaseline == true || scans[4].Creates != 1 {
+        t.Fatalf("Baseline not recorded after failed scan.")
+    }
+
+    _, err = cr.FindScan("3")
+    if err != ErrScanNotFound {
+        t.Fatalf("Failed scan should not be found: %v", err)
+    }
+
+    checkReplayMatchesCatalog(t, cr, 5)
+
+    relPath := "partial"
+    _, err = cr.ResolvePathAsOf(&relPath, 5)
+    if err != ErrPathNotFound {
+        t.Fatalf("Change from failed scan was replayed: %v", err)
+    }
+}
+
+func TestHistoryChangeSinkBatches(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+
+    defer os.Remove(catalogFilepath)
+
+    cr := openTestCatalog(t, catalogFilepath, HashAlgorithm)
+    defer cr.Close()
+
+    hcs := cr.NewHistoryChangeSink()
+    hcs.batchSize = 2
+
+    err := hcs.Begin()
+    if err != nil {
+        t.Fatalf("Could not begin scan.")
+    }
+
+    for i := 0; i < 5; i++ {
+        err = hcs.Event(&ChangeEvent {
+            EntityType: EntityTypeFile,
+            ChangeType: UpdateTypeCreate,
+            RelPath: fmt.Sprintf("file%d", i),
+            Hash: "da39a3ee5e6b4b0d3255bfef95601890afd80709",
+        })
+
+        if err != nil {
+            t.Fatalf("Could not send event (%d).", i)
+        }
+    }
+
+    // Two full batches were written while the scan was still going, and the
+    // scan isn't complete yet.
+    changes, err := cr.GetScanChanges(hcs.ScanId())
+    if err != nil {
+        t.Fatalf("Could not get changes during scan.")
+    } else if len(changes) != 4 {
+        t.Fatalf("Expected two batches to be written: (%d)", len(changes))
+    }
+
+    scans, err := cr.ListScans()
+    if err != nil {
+        t.Fatalf("Could not list scans during scan.")
+    } else if len(scans) != 1 || scans[0].IsComplete() == true {
+        t.Fatalf("Scan should be recorded as incomplete until it ends.")
+    }
+
+    err = hcs.End()
+    if err != nil {
+        t.Fatalf("Could not end scan.")
+    }
+
+    changes, err = cr.GetScanChanges(hcs.ScanId())
+    if err != nil {
+        t.Fatalf("Could not get changes after scan.")
+    } else if len(changes) != 5 {
+        t.Fatalf("Expected every change to be written: (%d)", len(changes))
+    } else if changes[4].RelPath != "file4" {
+        t.Fatalf("Changes not in order.")
+    }
+
+    scans, err = cr.ListScans()
+    if err != nil {
+        t.Fatalf("Could not list scans after scan.")
+    } else if len(scans) != 1 || scans[0].IsComplete() == false || scans[0].Creates != 5 {
+        t.Fatalf("Scan not recorded as complete: %v", scans)
+    }
+}
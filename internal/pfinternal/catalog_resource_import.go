@@ -0,0 +1,180 @@
+package pfinternal
+
+import (
+    "os"
+    "fmt"
+    "path"
+    "time"
+    "errors"
+    "strings"
+)
+
+// Why a manifest entry wasn't imported.
+const (
+    ImportSkipMissing = "missing"
+    ImportSkipNotFile = "not a regular file"
+    ImportSkipSizeMismatch = "size mismatch"
+)
+
+type ImportSkip struct {
+    RelPath string
+    Reason string
+}
+
+type ImportResult struct {
+    Imported int
+    Skipped []*ImportSkip
+}
+
+// Make sure that the path and every path above it are recorded. Returns the
+// ID of the path.
+func (self *catalogResource) ensurePathRecorded(relPath string, pathIds map[string]int, nowEpoch int64) (pathId int, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            pathId = 0
+            err = r.(error)
+
+            l.Error("Could not ensure path is recorded", "relPath", relPath, "err", err)
+        }
+    }()
+
+    if pathId, found := pathIds[relPath]; found == true {
+        return pathId, nil
+    }
+
+    if relPath != "" {
+        _, err := self.ensurePathRecorded(parentRelPath(relPath), pathIds, nowEpoch)
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    plr, err := self.lookupPath(&relPath)
+    if err != nil {
+        panic(err)
+    }
+
+    if plr.wasFound == true {
+        pathId = plr.entry.id
+    } else {
+        pathId, err = self.createPath(&relPath, nowEpoch)
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    pathIds[relPath] = pathId
+
+    return pathId, nil
+}
+
+// Seed the catalog with the file hashes from a manifest, so that the files
+// don't have to be read by the next scan. Every file must still exist under
+// the scan path (and have the same size, if the manifest has it). The current
+// mtimes and sizes are recorded. Path entries are ignored and the path hashes
+// are rebuilt from the catalog once the files are in. File hashes are
+// lowercased, and the manifest is rejected (before anything is imported) if
+// any of them isn't hex of the algorithm's width.
+func (self *catalogResource) ImportManifest(scanPath *string, m *Manifest) (ir *ImportResult, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            ir = nil
+            err = r.(error)
+
+            l.Error("Could not import manifest", "err", err)
+        }
+    }()
+
+    if m.Algorithm != *self.cc.HashAlgorithm() {
+        panic(ErrAlgorithmMismatch)
+    }
+
+    h, err := self.cc.getHashObject()
+    if err != nil {
+        panic(err)
+    }
+
+    width := h.Size() * 2
+
+    for _, me := range m.Entries {
+        if me.Type != ManifestEntryTypeFile {
+            continue
+        }
+
+        me.Hash = strings.ToLower(me.Hash)
+        if isValidHash(me.Hash, width) == false {
+            panic(errors.New(fmt.Sprintf("Manifest hash is not (%d) hex characters: [%s] [%s]", width, me.RelPath, me.Hash)))
+        }
+    }
+
+    ir = &ImportResult {
+            Skipped: make([]*ImportSkip, 0),
+    }
+
+    nowEpoch := time.Now().Unix()
+    pathIds := make(map[string]int)
+
+    skip := func(relPath string, reason string) {
+        l.Warn("Not importing file.", "relPath", relPath, "reason", reason)
+
+        is := &ImportSkip {
+                RelPath: relPath,
+                Reason: reason,
+        }
+
+        ir.Skipped = append(ir.Skipped, is)
+    }
+
+    for _, me := range m.Entries {
+        if me.Type != ManifestEntryTypeFile {
+            continue
+        }
+
+        s, err := os.Stat(path.Join(*scanPath, me.RelPath))
+        if os.IsNotExist(err) == true {
+            skip(me.RelPath, ImportSkipMissing)
+            continue
+        } else if err != nil {
+            panic(err)
+        } else if s.Mode().IsRegular() == false {
+            skip(me.RelPath, ImportSkipNotFile)
+            continue
+        } else if me.Size != nil && *me.Size != s.Size() {
+            skip(me.RelPath, ImportSkipSizeMismatch)
+            continue
+        }
+
+        parentPath := parentRelPath(me.RelPath)
+        filename := path.Base(me.RelPath)
+
+        pathId, err := self.ensurePathRecorded(parentPath, pathIds, nowEpoch)
+        if err != nil {
+            panic(err)
+        }
+
+        pd := newRecordedPathDescriptor(&parentPath, pathId)
+
+        flr, err := self.lookupFile(pd, &filename)
+        if err != nil {
+            panic(err)
+        }
+
+        err = self.setFile(flr, s.ModTime().Unix(), s.Size(), &me.Hash, nowEpoch)
+        if err != nil {
+            panic(err)
+        }
+
+        ir.Imported++
+    }
+
+    _, _, err = self.RebuildPathHashes()
+    if err != nil {
+        panic(err)
+    }
+
+    return ir, nil
+}
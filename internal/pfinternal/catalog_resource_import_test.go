@@ -0,0 +1,263 @@
+package pfinternal
+
+import (
+    "testing"
+    "os"
+    "path"
+    "bytes"
+    "strings"
+
+    "io/ioutil"
+)
+
+func TestImportManifest(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    scannedCatalogFilepath := createTempFile(tempPath)
+    importedCatalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(scannedCatalogFilepath)
+        os.Remove(importedCatalogFilepath)
+    }
+
+    defer cleanup()
+
+    subPath := path.Join(scanPath, "dir1", "sub")
+    os.MkdirAll(subPath, 0755)
+
+    ioutil.WriteFile(path.Join(scanPath, "aa"), []byte("aa"), 0644)
+    ioutil.WriteFile(path.Join(scanPath, "dir1", "bb"), []byte("bb"), 0644)
+    ioutil.WriteFile(path.Join(subPath, "cc"), []byte("cc"), 0644)
+
+    scanAndCollectChanges(t, scannedCatalogFilepath, scanPath, true)
+
+    hashAlgorithm := HashAlgorithm
+
+    scanned, err := NewCatalogResource(&scannedCatalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not create catalog-resource.")
+    }
+
+    err = scanned.Open()
+    if err != nil {
+        t.Fatalf("Could not open scanned catalog.")
+    }
+
+    defer scanned.Close()
+
+    b := new(bytes.Buffer)
+
+    mw, err := NewManifestWriter(b, ManifestFormatGnu)
+    if err != nil {
+        t.Fatalf("Could not create manifest writer.")
+    }
+
+    rootRelPath := ""
+    err = scanned.ExportManifest(&rootRelPath, mw)
+    if err != nil {
+        t.Fatalf("Could not export manifest.")
+    }
+
+    // A file that's no longer there.
+    b.WriteString("da39a3ee5e6b4b0d3255bfef95601890afd80709  dir1/gone\n")
+
+    m, err := ReadManifest(b)
+    if err != nil {
+        t.Fatalf("Could not read manifest.")
+    }
+
+    imported, err := NewCatalogResource(&importedCatalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not create catalog-resource.")
+    }
+
+    err = imported.Open()
+    if err != nil {
+        t.Fatalf("Could not open imported catalog.")
+    }
+
+    ir, err := imported.ImportManifest(&scanPath, m)
+    if err != nil {
+        imported.Close()
+        t.Fatalf("Could not import manifest.")
+    }
+
+    if ir.Imported != 3 {
+        t.Fatalf("Imported count not correct: (%d)", ir.Imported)
+    } else if len(ir.Skipped) != 1 || ir.Skipped[0].RelPath != "dir1/gone" || ir.Skipped[0].Reason != ImportSkipMissing {
+        t.Fatalf("Skipped files not correct.")
+    }
+
+    // The rebuilt path hashes should be what a scan produces.
+    for _, relPath := range []string { "", "dir1", "dir1/sub" } {
+        expected, err := scanned.ResolvePath(&relPath)
+        if err != nil {
+            t.Fatalf("Could not resolve scanned path: [%s]", relPath)
+        }
+
+        actual, err := imported.ResolvePath(&relPath)
+        if err != nil {
+            t.Fatalf("Could not resolve imported path: [%s]", relPath)
+        }
+
+        if actual.Hash != expected.Hash {
+            t.Fatalf("Imported path hash not correct: [%s] [%s] != [%s]", relPath, actual.Hash, expected.Hash)
+        }
+    }
+
+    imported.Close()
+
+    // The mtimes were recorded, so nothing should be rehashed or changed.
+    changes := scanAndCollectChanges(t, importedCatalogFilepath, scanPath, true)
+    if strings.Join(changes, "\n") != "" {
+        t.Fatalf("Scan after import found changes: %v", changes)
+    }
+}
+
+func TestImportManifestAlgorithmMismatch(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    hashAlgorithm := Sha1Algorithm
+
+    cr, err := NewCatalogResource(&catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not create catalog-resource.")
+    }
+
+    err = cr.Open()
+    if err != nil {
+        t.Fatalf("Could not open catalog.")
+    }
+
+    defer cr.Close()
+
+    m := &Manifest {
+            Algorithm: Sha256Algorithm,
+            Entries: make([]*ManifestEntry, 0),
+    }
+
+    _, err = cr.ImportManifest(&scanPath, m)
+    if err != ErrAlgorithmMismatch {
+        t.Fatalf("Expected algorithm mismatch: %v", err)
+    }
+}
+
+func TestImportManifestBadHash(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    ioutil.WriteFile(path.Join(scanPath, "aa"), []byte("aa"), 0644)
+    ioutil.WriteFile(path.Join(scanPath, "bb"), []byte("bb"), 0644)
+
+    hashAlgorithm := Sha1Algorithm
+
+    cr, err := NewCatalogResource(&catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not create catalog-resource.")
+    }
+
+    err = cr.Open()
+    if err != nil {
+        t.Fatalf("Could not open catalog.")
+    }
+
+    defer cr.Close()
+
+    badHashes := []string {
+        "e0c9035898dd52fc65c41454cec9c4d2611bfb3",
+        "e0c9035898dd52fc65c41454cec9c4d2611bfb37e",
+        "z0c9035898dd52fc65c41454cec9c4d2611bfb37",
+    }
+
+    for _, badHash := range badHashes {
+        m := &Manifest {
+                Algorithm: Sha1Algorithm,
+                Entries: []*ManifestEntry {
+                    &ManifestEntry {
+                        Type: ManifestEntryTypeFile,
+                        RelPath: "aa",
+                        Hash: "E0C9035898DD52FC65C41454CEC9C4D2611BFB37",
+                    },
+                    &ManifestEntry {
+                        Type: ManifestEntryTypeFile,
+                        RelPath: "bb",
+                        Hash: badHash,
+                    },
+                },
+        }
+
+        _, err = cr.ImportManifest(&scanPath, m)
+        if err == nil {
+            t.Fatalf("Expected bad hash to be rejected: [%s]", badHash)
+        }
+    }
+
+    // Nothing was imported from the rejected manifests.
+    var count int
+
+    err = cr.db.QueryRow("SELECT COUNT(*) FROM `files`").Scan(&count)
+    if err != nil {
+        t.Fatalf("Could not count files.")
+    } else if count != 0 {
+        t.Fatalf("Files imported from rejected manifest: (%d)", count)
+    }
+
+    m := &Manifest {
+            Algorithm: Sha1Algorithm,
+            Entries: []*ManifestEntry {
+                &ManifestEntry {
+                    Type: ManifestEntryTypeFile,
+                    RelPath: "aa",
+                    Hash: "E0C9035898DD52FC65C41454CEC9C4D2611BFB37",
+                },
+            },
+    }
+
+    _, err = cr.ImportManifest(&scanPath, m)
+    if err != nil {
+        t.Fatalf("Could not import manifest with uppercase hash.")
+    }
+
+    rootRelPath := ""
+
+    lr, err := cr.ResolvePath(&rootRelPath)
+    if err != nil {
+        t.Fatalf("Could not resolve root.")
+    }
+
+    var hash string
+
+    err = cr.db.QueryRow("SELECT `hash` FROM `files` WHERE `filename` = 'aa'").Scan(&hash)
+    if err != nil {
+        t.Fatalf("Could not read imported hash.")
+    } else if hash != "e0c9035898dd52fc65c41454cec9c4d2611bfb37" {
+        t.Fatalf("Imported hash not normalized: [%s]", hash)
+    } else if lr.Hash == "" {
+        t.Fatalf("Root hash not rebuilt.")
+    }
+}
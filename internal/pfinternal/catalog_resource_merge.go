@@ -0,0 +1,546 @@
+package pfinternal
+
+import (
+    "os"
+    "path"
+    "time"
+    "strings"
+
+    "database/sql"
+)
+
+// Recalculate the hashes of the paths above the given one from what the
+// catalog has for their children. The IDs of the ancestors must be in the
+// given map. Like RebuildPathHashes(), ancestors whose hashes can't be
+// recalculated (because of symlinks) keep them until the next scan, and the
+// paths above them are calculated from the kept hashes.
+func (self *catalogResource) rebuildAncestorHashes(relPath string, pathIds map[string]int, nowEpoch int64) (err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            err = r.(error)
+            l.Error("Could not rebuild ancestor hashes", "relPath", relPath, "err", err)
+        }
+    }()
+
+    current := relPath
+    for current != "" {
+        current = parentRelPath(current)
+
+        pathId, found := pathIds[current]
+        if found == false {
+            panic(ErrPathNotFound)
+        }
+
+        query :=
+            "SELECT " +
+                "`p`.`hash`, " +
+                "`p`.`symlink_count` " +
+            "FROM " +
+                "`paths` `p` " +
+            "WHERE " +
+                "`p`.`path_id` = ?"
+
+        var currentHash sql.NullString
+        var symlinkCount sql.NullInt64
+
+        err = self.db.QueryRow(query, pathId).Scan(&currentHash, &symlinkCount)
+        if err != nil {
+            panic(err)
+        }
+
+        rp := &rebuildPath {
+                relPath: current,
+                hash: currentHash.String,
+                symlinkCount: UnknownSymlinkCount,
+        }
+
+        if symlinkCount.Valid == true {
+            rp.symlinkCount = int(symlinkCount.Int64)
+        }
+
+        if rp.mustKeepHash() == true {
+            l.Warn("Path has symlinks, so its hash was kept. The next scan will update it.", "relPath", current)
+            continue
+        }
+
+        paths, files, err := self.listImmediateChildren(pathId, current)
+        if err != nil {
+            panic(err)
+        }
+
+        children := make(map[string]string)
+
+        for name, ce := range paths {
+            children[name] = ce.hash
+        }
+
+        for name, ce := range files {
+            children[name] = ce.hash
+        }
+
+        hash, err := self.hashPathChildren(children)
+        if err != nil {
+            panic(err)
+        }
+
+        pd := newRecordedPathDescriptor(&current, pathId)
+
+        err = self.updatePath(pd, &hash, nowEpoch)
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    return nil
+}
+
+// Copy the paths and files at and below a path in another catalog into this
+// one, within the given transaction. They'll be below the given path here.
+func (self *catalogResource) copyCatalogRows(tx *sql.Tx, source *catalogResource, sourceRelPath string, relPath string) (pathCount int, fileCount int, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            pathCount = 0
+            fileCount = 0
+            err = r.(error)
+
+            l.Error("Could not copy catalog rows", "err", err)
+        }
+    }()
+
+    condition, args := descendantPathsCondition("p", sourceRelPath)
+    allArgs := append([]interface{} { sourceRelPath }, args...)
+
+    query :=
+        "SELECT " +
+            "`p`.`path_id`, " +
+            "`p`.`rel_path`, " +
+            "`p`.`hash`, " +
+            "`p`.`last_check_epoch`, " +
+            "`p`.`last_change_epoch`, " +
+            "`p`.`symlink_count` " +
+        "FROM " +
+            "`paths` `p` " +
+        "WHERE " +
+            "`p`.`rel_path` = ? OR (" + condition + ")"
+
+    rows, err := source.db.Query(query, allArgs...)
+    if err != nil {
+        panic(err)
+    }
+
+    defer rows.Close()
+
+    query =
+        "INSERT INTO `paths` " +
+            "(`rel_path`, `hash`, `last_check_epoch`, `last_change_epoch`, `symlink_count`) " +
+        "VALUES " +
+            "(?, ?, ?, ?, ?)"
+
+    pathStmt, err := tx.Prepare(query)
+    if err != nil {
+        panic(err)
+    }
+
+    defer pathStmt.Close()
+
+    // The source's path IDs mean nothing here.
+    pathIds := make(map[int]int64)
+
+    for rows.Next() {
+        var sourcePathId int
+        var pathRelPath string
+        var hash sql.NullString
+        var lastCheckEpoch sql.NullInt64
+        var lastChangeEpoch sql.NullInt64
+        var symlinkCount sql.NullInt64
+
+        err = rows.Scan(&sourcePathId, &pathRelPath, &hash, &lastCheckEpoch, &lastChangeEpoch, &symlinkCount)
+        if err != nil {
+            panic(err)
+        }
+
+        r, err := pathStmt.Exec(path.Join(relPath, relativeRelPath(sourceRelPath, pathRelPath)), hash, lastCheckEpoch, lastChangeEpoch, symlinkCount)
+        if err != nil {
+            panic(err)
+        }
+
+        pathIds[sourcePathId], err = r.LastInsertId()
+        if err != nil {
+            panic(err)
+        }
+
+        pathCount++
+    }
+
+    err = rows.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    query =
+        "SELECT " +
+            "`f`.`path_id`, " +
+            "`f`.`filename`, " +
+            "`f`.`hash`, " +
+            "`f`.`mtime_epoch`, " +
+            "`f`.`size`, " +
+            "`f`.`last_check_epoch`, " +
+            "`f`.`last_change_epoch` " +
+        "FROM " +
+            "`files` `f`, " +
+            "`paths` `p` " +
+        "WHERE " +
+            "`p`.`path_id` = `f`.`path_id` AND " +
+            "(`p`.`rel_path` = ? OR (" + condition + "))"
+
+    fileRows, err := source.db.Query(query, allArgs...)
+    if err != nil {
+        panic(err)
+    }
+
+    defer fileRows.Close()
+
+    query =
+        "INSERT INTO `files` " +
+            "(`path_id`, `filename`, `hash`, `mtime_epoch`, `size`, `last_check_epoch`, `last_change_epoch`) " +
+        "VALUES " +
+            "(?, ?, ?, ?, ?, ?, ?)"
+
+    fileStmt, err := tx.Prepare(query)
+    if err != nil {
+        panic(err)
+    }
+
+    defer fileStmt.Close()
+
+    for fileRows.Next() {
+        var sourcePathId int
+        var filename string
+        var hash string
+        var mtimeEpoch int64
+        var size sql.NullInt64
+        var lastCheckEpoch sql.NullInt64
+        var lastChangeEpoch sql.NullInt64
+
+        err = fileRows.Scan(&sourcePathId, &filename, &hash, &mtimeEpoch, &size, &lastCheckEpoch, &lastChangeEpoch)
+        if err != nil {
+            panic(err)
+        }
+
+        pathId := pathIds[sourcePathId]
+
+        _, err = fileStmt.Exec(pathId, filename, hash, mtimeEpoch, size, lastCheckEpoch, lastChangeEpoch)
+        if err != nil {
+            panic(err)
+        }
+
+        fileCount++
+    }
+
+    err = fileRows.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    return pathCount, fileCount, nil
+}
+
+// Load the hashes of the paths and files at or below the graft path, and of
+// the paths above it, from the catalog as it is now. These are the only
+// entries that a graft changes.
+func (self *catalogResource) loadGraftHashes(graftRelPath string) (paths map[string]string, files map[string]string, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            paths = nil
+            files = nil
+            err = r.(error)
+
+            l.Error("Could not load graft hashes", "relPath", graftRelPath, "err", err)
+        }
+    }()
+
+    isAffected := func(relPath string) bool {
+        if isRelPathAtOrBelow(graftRelPath, relPath) == true {
+            return true
+        }
+
+        return relPath == "" || strings.HasPrefix(graftRelPath, relPath + "/") == true
+    }
+
+    query :=
+        "SELECT " +
+            "`p`.`rel_path`, " +
+            "`p`.`hash` " +
+        "FROM " +
+            "`paths` `p`"
+
+    rows, err := self.db.Query(query)
+    if err != nil {
+        panic(err)
+    }
+
+    defer rows.Close()
+
+    paths = make(map[string]string)
+
+    for rows.Next() {
+        var relPath string
+        var hash sql.NullString
+
+        err = rows.Scan(&relPath, &hash)
+        if err != nil {
+            panic(err)
+        }
+
+        if isAffected(relPath) == true {
+            paths[relPath] = hash.String
+        }
+    }
+
+    err = rows.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    query =
+        "SELECT " +
+            "CASE WHEN `p`.`rel_path` = '' THEN `f`.`filename` ELSE `p`.`rel_path` || '/' || `f`.`filename` END, " +
+            "`f`.`hash` " +
+        "FROM " +
+            "`files` `f`, " +
+            "`paths` `p` " +
+        "WHERE " +
+            "`p`.`path_id` = `f`.`path_id`"
+
+    fileRows, err := self.db.Query(query)
+    if err != nil {
+        panic(err)
+    }
+
+    defer fileRows.Close()
+
+    files = make(map[string]string)
+
+    for fileRows.Next() {
+        var relPath string
+        var hash string
+
+        err = fileRows.Scan(&relPath, &hash)
+        if err != nil {
+            panic(err)
+        }
+
+        // Only a file at the graft path itself (which the graft replaces) or
+        // below it is affected.
+        if isRelPathAtOrBelow(graftRelPath, relPath) == true {
+            files[relPath] = hash
+        }
+    }
+
+    err = fileRows.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    return paths, files, nil
+}
+
+// Record a graft in the history as a scan whose changes are the differences
+// between the hashes from before and after it. The sink was begun before the
+// graft.
+func (self *catalogResource) recordGraftScan(hcs *HistoryChangeSink, beforePaths map[string]string, beforeFiles map[string]string, graftRelPath string) (err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            err = r.(error)
+            l.Error("Could not record graft scan", "err", err)
+        }
+    }()
+
+    afterPaths, afterFiles, err := self.loadGraftHashes(graftRelPath)
+    if err != nil {
+        panic(err)
+    }
+
+    events := diffHistoricalHashes(EntityTypePath, beforePaths, afterPaths)
+    events = append(events, diffHistoricalHashes(EntityTypeFile, beforeFiles, afterFiles)...)
+
+    SortChangeEvents(events)
+
+    for _, ce := range events {
+        err = hcs.Event(ce)
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    err = hcs.End()
+    if err != nil {
+        panic(err)
+    }
+
+    return nil
+}
+
+// Graft another catalog (for example, one that was built for a subdirectory
+// on another machine) into this one at the given path. Whatever this catalog
+// had at that path is replaced. The catalogs must use the same algorithm.
+// The hashes of the paths above the graft are then recalculated from the
+// catalog, so the root hash is what a scan of the whole tree would produce
+// (as long as those paths don't have symlinks, which aren't recorded).
+// If history is enabled, the graft is recorded as a scan. Returns the number
+// of paths and files that were copied.
+func (self *catalogResource) GraftCatalog(source *catalogResource, relPath *string) (pathCount int, fileCount int, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            pathCount = 0
+            fileCount = 0
+            err = r.(error)
+
+            l.Error("Could not graft catalog", "err", err)
+        }
+    }()
+
+    if source == self {
+        panic(ErrGraftIntoSelf)
+    }
+
+    // The same file might have been opened twice.
+    if targetInfo, err := os.Stat(*self.catalogFilepath); err == nil {
+        if sourceInfo, err := os.Stat(*source.catalogFilepath); err == nil && os.SameFile(targetInfo, sourceInfo) == true {
+            panic(ErrGraftIntoSelf)
+        }
+    }
+
+    if *self.cc.HashAlgorithm() != *source.cc.HashAlgorithm() {
+        panic(ErrAlgorithmMismatch)
+    }
+
+    graftRelPath, err := normalizeManifestRelPath(*relPath)
+    if err != nil {
+        panic(err)
+    }
+
+    isHistoryEnabled, err := self.HistoryEnabled()
+    if err != nil {
+        panic(err)
+    }
+
+    sourceRootRelPath := ""
+    plr, err := source.lookupPath(&sourceRootRelPath)
+    if err != nil {
+        panic(err)
+    } else if plr.wasFound == false {
+        panic(ErrRootNotRecorded)
+    }
+
+    nowEpoch := time.Now().Unix()
+    pathIds := make(map[string]int)
+
+    var hcs *HistoryChangeSink
+    var beforePaths map[string]string
+    var beforeFiles map[string]string
+
+    if isHistoryEnabled == true {
+        hcs = self.NewHistoryChangeSink()
+
+        err = hcs.Begin()
+        if err != nil {
+            panic(err)
+        }
+
+        beforePaths, beforeFiles, err = self.loadGraftHashes(graftRelPath)
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    // The paths above the graft have to be there before we start the
+    // transaction, since SQLite only allows one writer at a time.
+    if graftRelPath != "" {
+        _, err := self.ensurePathRecorded(parentRelPath(graftRelPath), pathIds, nowEpoch)
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    tx, err := self.db.Begin()
+    if err != nil {
+        panic(err)
+    }
+
+    condition, args := descendantPathsCondition("p", graftRelPath)
+    allArgs := append([]interface{} { graftRelPath }, args...)
+
+    pathsQuery :=
+        "SELECT " +
+            "`p`.`path_id` " +
+        "FROM " +
+            "`paths` `p` " +
+        "WHERE " +
+            "`p`.`rel_path` = ? OR (" + condition + ")"
+
+    statements := []string {
+        "DELETE FROM `files` WHERE `path_id` IN (" + pathsQuery + ")",
+        "DELETE FROM `paths` WHERE `path_id` IN (" + pathsQuery + ")",
+    }
+
+    for _, statement := range statements {
+        _, err := tx.Exec(statement, allArgs...)
+        if err != nil {
+            tx.Rollback()
+            panic(err)
+        }
+    }
+
+    // A file with the same name as the graft would be replaced by it, too.
+    if graftRelPath != "" {
+        query :=
+            "DELETE FROM `files` " +
+            "WHERE " +
+                "`path_id` = ? AND " +
+                "`filename` = ?"
+
+        _, err := tx.Exec(query, pathIds[parentRelPath(graftRelPath)], path.Base(graftRelPath))
+        if err != nil {
+            tx.Rollback()
+            panic(err)
+        }
+    }
+
+    pathCount, fileCount, err = self.copyCatalogRows(tx, source, "", graftRelPath)
+    if err != nil {
+        tx.Rollback()
+        panic(err)
+    }
+
+    err = tx.Commit()
+    if err != nil {
+        panic(err)
+    }
+
+    l.Debug("Catalog grafted.", "relPath", graftRelPath, "paths", pathCount, "files", fileCount)
+
+    err = self.rebuildAncestorHashes(graftRelPath, pathIds, nowEpoch)
+    if err != nil {
+        panic(err)
+    }
+
+    if isHistoryEnabled == true {
+        err = self.recordGraftScan(hcs, beforePaths, beforeFiles, graftRelPath)
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    return pathCount, fileCount, nil
+}
@@ -0,0 +1,309 @@
+package pfinternal
+
+import (
+    "testing"
+    "os"
+    "path"
+    "strings"
+
+    "io/ioutil"
+)
+
+func openTestCatalog(t *testing.T, catalogFilepath string, hashAlgorithm string) *catalogResource {
+    cr, err := NewCatalogResource(&catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not create catalog-resource.")
+    }
+
+    err = cr.Open()
+    if err != nil {
+        t.Fatalf("Could not open catalog.")
+    }
+
+    return cr
+}
+
+func TestGraftCatalog(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    targetCatalogFilepath := createTempFile(tempPath)
+    sourceCatalogFilepath := createTempFile(tempPath)
+    fullCatalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(targetCatalogFilepath)
+        os.Remove(sourceCatalogFilepath)
+        os.Remove(fullCatalogFilepath)
+    }
+
+    defer cleanup()
+
+    graftPath := path.Join(scanPath, "b", "sub")
+    os.MkdirAll(path.Join(scanPath, "a"), 0755)
+    os.MkdirAll(graftPath, 0755)
+
+    ioutil.WriteFile(path.Join(scanPath, "a", "aa"), []byte("aa"), 0644)
+    ioutil.WriteFile(path.Join(graftPath, "old"), []byte("old"), 0644)
+
+    // The target knows about the subdirectory as it was.
+    scanAndCollectChanges(t, targetCatalogFilepath, scanPath, true)
+
+    os.Remove(path.Join(graftPath, "old"))
+    os.MkdirAll(path.Join(graftPath, "deeper"), 0755)
+
+    ioutil.WriteFile(path.Join(graftPath, "new"), []byte("new"), 0644)
+    ioutil.WriteFile(path.Join(graftPath, "deeper", "dd"), []byte("dd"), 0644)
+
+    // The subdirectory is cataloged on its own.
+    scanAndCollectChanges(t, sourceCatalogFilepath, graftPath, true)
+    scanAndCollectChanges(t, fullCatalogFilepath, scanPath, true)
+
+    target := openTestCatalog(t, targetCatalogFilepath, HashAlgorithm)
+    source := openTestCatalog(t, sourceCatalogFilepath, HashAlgorithm)
+
+    defer source.Close()
+
+    err := target.SetHistoryEnabled(true)
+    if err != nil {
+        target.Close()
+        t.Fatalf("Could not enable history.")
+    }
+
+    relPath := "b/sub"
+    pathCount, fileCount, err := target.GraftCatalog(source, &relPath)
+    if err != nil {
+        target.Close()
+        t.Fatalf("Could not graft catalog.")
+    } else if pathCount != 2 || fileCount != 2 {
+        target.Close()
+        t.Fatalf("Counts not correct: (%d) (%d)", pathCount, fileCount)
+    }
+
+    full := openTestCatalog(t, fullCatalogFilepath, HashAlgorithm)
+    defer full.Close()
+
+    for _, relPath := range []string { "", "b", "b/sub", "b/sub/deeper" } {
+        expected, err := full.ResolvePath(&relPath)
+        if err != nil {
+            t.Fatalf("Could not resolve full path: [%s]", relPath)
+        }
+
+        actual, err := target.ResolvePath(&relPath)
+        if err != nil {
+            t.Fatalf("Could not resolve grafted path: [%s]", relPath)
+        }
+
+        if actual.Hash != expected.Hash {
+            t.Fatalf("Grafted hash not correct: [%s] [%s] != [%s]", relPath, actual.Hash, expected.Hash)
+        }
+    }
+
+    // The graft was recorded as a scan, so replaying the history gets us
+    // what the catalog has now.
+    scans, err := target.ListScans()
+    if err != nil {
+        t.Fatalf("Could not list scans.")
+    } else if len(scans) != 2 {
+        t.Fatalf("Graft not recorded as a scan: (%d)", len(scans))
+    }
+
+    graftScan := scans[1]
+    if graftScan.Creates != 3 || graftScan.Updates != 3 || graftScan.Deletes != 1 {
+        t.Fatalf("Graft scan counts not correct: (%d) (%d) (%d)", graftScan.Creates, graftScan.Updates, graftScan.Deletes)
+    }
+
+    rootRelPath := ""
+    rr, err := target.ResolvePath(&rootRelPath)
+    if err != nil {
+        t.Fatalf("Could not resolve root.")
+    } else if graftScan.RootHash != rr.Hash {
+        t.Fatalf("Graft scan root hash not correct: [%s] != [%s]", graftScan.RootHash, rr.Hash)
+    }
+
+    checkReplayMatchesCatalog(t, target, graftScan.ScanId)
+
+    target.Close()
+
+    // The old file is gone and the new ones were brought in, so a scan
+    // shouldn't find anything.
+    changes := scanAndCollectChanges(t, targetCatalogFilepath, scanPath, true)
+    if strings.Join(changes, "\n") != "" {
+        t.Fatalf("Scan after graft found changes: %v", changes)
+    }
+}
+
+func TestGraftCatalogAlgorithmMismatch(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    targetCatalogFilepath := createTempFile(tempPath)
+    sourceCatalogFilepath := createTempFile(tempPath)
+
+    cleanup := func() {
+        os.Remove(targetCatalogFilepath)
+        os.Remove(sourceCatalogFilepath)
+    }
+
+    defer cleanup()
+
+    target := openTestCatalog(t, targetCatalogFilepath, Sha1Algorithm)
+    defer target.Close()
+
+    source := openTestCatalog(t, sourceCatalogFilepath, Sha256Algorithm)
+    defer source.Close()
+
+    relPath := "sub"
+    _, _, err := target.GraftCatalog(source, &relPath)
+    if err != ErrAlgorithmMismatch {
+        t.Fatalf("Expected algorithm mismatch: %v", err)
+    }
+}
+
+func TestGraftCatalogIntoSelf(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+
+    defer os.Remove(catalogFilepath)
+
+    target := openTestCatalog(t, catalogFilepath, HashAlgorithm)
+    defer target.Close()
+
+    relPath := "sub"
+    _, _, err := target.GraftCatalog(target, &relPath)
+    if err != ErrGraftIntoSelf {
+        t.Fatalf("Expected graft into the same resource to fail: %v", err)
+    }
+
+    // The same file, opened separately.
+    source := openTestCatalog(t, catalogFilepath, HashAlgorithm)
+    defer source.Close()
+
+    _, _, err = target.GraftCatalog(source, &relPath)
+    if err != ErrGraftIntoSelf {
+        t.Fatalf("Expected graft from the same file to fail: %v", err)
+    }
+}
+
+func TestGraftCatalogUnderSymlinks(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    targetCatalogFilepath := createTempFile(tempPath)
+    sourceCatalogFilepath := createTempFile(tempPath)
+    fullCatalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(targetCatalogFilepath)
+        os.Remove(sourceCatalogFilepath)
+        os.Remove(fullCatalogFilepath)
+    }
+
+    defer cleanup()
+
+    dirPath := path.Join(scanPath, "dir")
+    os.MkdirAll(dirPath, 0755)
+
+    ioutil.WriteFile(path.Join(scanPath, "aa"), []byte("aa"), 0644)
+    ioutil.WriteFile(path.Join(dirPath, "bb"), []byte("bb"), 0644)
+
+    err := os.Symlink("bb", path.Join(dirPath, "link"))
+    if err != nil {
+        t.Fatalf("Could not create symlink: %s", err)
+    }
+
+    scanAndCollectChanges(t, targetCatalogFilepath, scanPath, true)
+
+    graftPath := path.Join(dirPath, "sub")
+    os.MkdirAll(graftPath, 0755)
+
+    ioutil.WriteFile(path.Join(graftPath, "new"), []byte("new"), 0644)
+
+    scanAndCollectChanges(t, sourceCatalogFilepath, graftPath, true)
+
+    target := openTestCatalog(t, targetCatalogFilepath, HashAlgorithm)
+    source := openTestCatalog(t, sourceCatalogFilepath, HashAlgorithm)
+
+    defer source.Close()
+
+    rootRelPath := ""
+    dirRelPath := "dir"
+
+    rootBefore, err := target.ResolvePath(&rootRelPath)
+    if err != nil {
+        target.Close()
+        t.Fatalf("Could not resolve root before graft.")
+    }
+
+    dirBefore, err := target.ResolvePath(&dirRelPath)
+    if err != nil {
+        target.Close()
+        t.Fatalf("Could not resolve [%s] before graft.", dirRelPath)
+    }
+
+    relPath := "dir/sub"
+    _, _, err = target.GraftCatalog(source, &relPath)
+    if err != nil {
+        target.Close()
+        t.Fatalf("Could not graft catalog.")
+    }
+
+    // The path with the symlink can't be recalculated, so it keeps its hash,
+    // and so does the root, which is calculated from it.
+    dirAfter, err := target.ResolvePath(&dirRelPath)
+    if err != nil {
+        target.Close()
+        t.Fatalf("Could not resolve [%s] after graft.", dirRelPath)
+    } else if dirAfter.Hash != dirBefore.Hash {
+        target.Close()
+        t.Fatalf("Hash of path with symlink was recalculated: [%s] != [%s]", dirAfter.Hash, dirBefore.Hash)
+    }
+
+    rootAfter, err := target.ResolvePath(&rootRelPath)
+    if err != nil {
+        target.Close()
+        t.Fatalf("Could not resolve root after graft.")
+    } else if rootAfter.Hash != rootBefore.Hash {
+        target.Close()
+        t.Fatalf("Root hash not calculated from the kept hash: [%s] != [%s]", rootAfter.Hash, rootBefore.Hash)
+    }
+
+    problems, err := target.CheckCatalog(false)
+    if err != nil {
+        target.Close()
+        t.Fatalf("Could not check grafted catalog.")
+    } else if len(problems) != 0 {
+        target.Close()
+        t.Fatalf("Grafted catalog has problems: %v", problems)
+    }
+
+    target.Close()
+
+    // The next scan updates the kept hashes.
+    scanAndCollectChanges(t, fullCatalogFilepath, scanPath, true)
+
+    hashAlgorithm := HashAlgorithm
+
+    target, rootHash, err := scanIntoCatalog(&scanPath, &targetCatalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not rescan target.")
+    }
+
+    defer target.Close()
+
+    full := openTestCatalog(t, fullCatalogFilepath, HashAlgorithm)
+    defer full.Close()
+
+    rr, err := full.ResolvePath(&rootRelPath)
+    if err != nil {
+        t.Fatalf("Could not resolve root of full catalog.")
+    } else if rootHash != rr.Hash {
+        t.Fatalf("Rescan didn't update the kept hashes: [%s] != [%s]", rootHash, rr.Hash)
+    }
+}
@@ -0,0 +1,306 @@
+package pfinternal
+
+import (
+    "io"
+    "fmt"
+    "path"
+    "sort"
+    "time"
+    "strings"
+
+    "database/sql"
+)
+
+// A path, with the names and hashes of what's directly within it.
+type rebuildPath struct {
+    id int
+    relPath string
+    hash string
+    depth int
+    symlinkCount int
+    children map[string]string
+
+    // Set by calculatePathHashes().
+    calculatedHash string
+    isKept bool
+}
+
+// Whether the path's hash can't be recalculated from the catalog. Symlinks
+// aren't recorded, so paths that have them (or that might, since they haven't
+// been scanned since we started counting them) keep the hash that the scan
+// gave them. A path without a hash has nothing to keep.
+func (self *rebuildPath) mustKeepHash() bool {
+    if self.hash == "" {
+        return false
+    }
+
+    return self.symlinkCount > 0 || self.symlinkCount == UnknownSymlinkCount
+}
+
+type rebuildPathsByDepth []*rebuildPath
+
+func (self rebuildPathsByDepth) Len() int {
+    return len(self)
+}
+
+func (self rebuildPathsByDepth) Swap(i, j int) {
+    self[i], self[j] = self[j], self[i]
+}
+
+func (self rebuildPathsByDepth) Less(i, j int) bool {
+    return self[i].depth > self[j].depth
+}
+
+// Load every path along with the names and hashes of the files directly
+// within it.
+func (self *catalogResource) loadRebuildPaths() (paths map[string]*rebuildPath, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            paths = nil
+            err = r.(error)
+
+            l.Error("Could not load paths for rebuild", "err", err)
+        }
+    }()
+
+    query :=
+        "SELECT " +
+            "`p`.`path_id`, " +
+            "`p`.`rel_path`, " +
+            "`p`.`hash`, " +
+            "`p`.`symlink_count` " +
+        "FROM " +
+            "`paths` `p`"
+
+    rows, err := self.db.Query(query)
+    if err != nil {
+        panic(err)
+    }
+
+    defer rows.Close()
+
+    paths = make(map[string]*rebuildPath)
+    pathsById := make(map[int]*rebuildPath)
+
+    for rows.Next() {
+        var pathId int
+        var relPath string
+        var hash sql.NullString
+        var symlinkCount sql.NullInt64
+
+        err = rows.Scan(&pathId, &relPath, &hash, &symlinkCount)
+        if err != nil {
+            panic(err)
+        }
+
+        depth := 0
+        if relPath != "" {
+            depth = strings.Count(relPath, "/") + 1
+        }
+
+        rp := &rebuildPath {
+                id: pathId,
+                relPath: relPath,
+                hash: hash.String,
+                depth: depth,
+                symlinkCount: UnknownSymlinkCount,
+                children: make(map[string]string),
+        }
+
+        if symlinkCount.Valid == true {
+            rp.symlinkCount = int(symlinkCount.Int64)
+        }
+
+        paths[relPath] = rp
+        pathsById[pathId] = rp
+    }
+
+    err = rows.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    query =
+        "SELECT " +
+            "`f`.`path_id`, " +
+            "`f`.`filename`, " +
+            "`f`.`hash` " +
+        "FROM " +
+            "`files` `f`"
+
+    fileRows, err := self.db.Query(query)
+    if err != nil {
+        panic(err)
+    }
+
+    defer fileRows.Close()
+
+    for fileRows.Next() {
+        var pathId int
+        var filename string
+        var hash string
+
+        err = fileRows.Scan(&pathId, &filename, &hash)
+        if err != nil {
+            panic(err)
+        }
+
+        rp, found := pathsById[pathId]
+        if found == false {
+            l.Warn("File has no path in the catalog.", "pathId", pathId, "filename", filename)
+            continue
+        }
+
+        rp.children[filename] = hash
+    }
+
+    err = fileRows.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    return paths, nil
+}
+
+// Calculate a path hash from the names and hashes of the files and paths
+// directly within it, the same way that GeneratePathHash() does.
+func (self *catalogResource) hashPathChildren(children map[string]string) (hash string, err error) {
+    names := make([]string, 0, len(children))
+    for name, _ := range children {
+        names = append(names, name)
+    }
+
+    // ioutil.ReadDir() returns entries sorted by name.
+    sort.Strings(names)
+
+    h, err := self.cc.getHashObject()
+    if err != nil {
+        return "", err
+    }
+
+    for _, name := range names {
+        io.WriteString(h, name)
+        io.WriteString(h, "\000")
+        io.WriteString(h, children[name])
+        io.WriteString(h, "\000")
+    }
+
+    return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// Calculate every path hash, bottom-up, from the names and hashes of the
+// files and paths directly within it, without writing anything. Paths whose
+// hashes can't be calculated keep the ones that they have (which is what
+// their parents are calculated from). Returns the paths deepest-first.
+func (self *catalogResource) calculatePathHashes(paths map[string]*rebuildPath) (ordered []*rebuildPath, err error) {
+    l := NewLogger("catalog_resource")
+
+    ordered = make([]*rebuildPath, 0, len(paths))
+    for _, rp := range paths {
+        ordered = append(ordered, rp)
+    }
+
+    // The deepest paths first, so that every path's children are done before
+    // it is.
+    sort.Sort(rebuildPathsByDepth(ordered))
+
+    for _, rp := range ordered {
+        hash := rp.hash
+        rp.isKept = rp.mustKeepHash()
+
+        if rp.isKept == false {
+            hash, err = self.hashPathChildren(rp.children)
+            if err != nil {
+                return nil, err
+            }
+        }
+
+        if rp.relPath != "" {
+            parent, found := paths[parentRelPath(rp.relPath)]
+            if found == false {
+                l.Warn("Path has no parent in the catalog.", "relPath", rp.relPath)
+            } else {
+                parent.children[path.Base(rp.relPath)] = hash
+            }
+        }
+
+        rp.calculatedHash = hash
+    }
+
+    return ordered, nil
+}
+
+// Store the calculated hashes of the given paths where they differ from what
+// was recorded. Returns the number of paths that were updated.
+func (self *catalogResource) writeCalculatedPathHashes(ordered []*rebuildPath) (updated int, err error) {
+    l := NewLogger("catalog_resource")
+
+    nowEpoch := time.Now().Unix()
+
+    for _, rp := range ordered {
+        if rp.calculatedHash == rp.hash {
+            continue
+        }
+
+        l.Debug("Path hash changed.", "relPath", rp.relPath, "old", rp.hash, "new", rp.calculatedHash)
+
+        pd := newRecordedPathDescriptor(&rp.relPath, rp.id)
+
+        err = self.updatePath(pd, &rp.calculatedHash, nowEpoch)
+        if err != nil {
+            return updated, err
+        }
+
+        rp.hash = rp.calculatedHash
+        updated++
+    }
+
+    return updated, nil
+}
+
+// Recalculate every path hash, bottom-up, from the names and hashes of the
+// files and paths directly within it (the same way that GeneratePathHash()
+// does), without touching the filesystem. This is useful after the catalog
+// has been changed directly (imports, merges) or to bring it back in line
+// with itself. Symlinks aren't recorded in the catalog, so paths that have
+// them (or that haven't been scanned since we started counting them) keep
+// their hashes. Returns the number of paths whose hashes changed and the
+// number that were kept.
+func (self *catalogResource) RebuildPathHashes() (updated int, kept int, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            updated = 0
+            kept = 0
+            err = r.(error)
+
+            l.Error("Could not rebuild path hashes", "err", err)
+        }
+    }()
+
+    paths, err := self.loadRebuildPaths()
+    if err != nil {
+        panic(err)
+    }
+
+    ordered, err := self.calculatePathHashes(paths)
+    if err != nil {
+        panic(err)
+    }
+
+    updated, err = self.writeCalculatedPathHashes(ordered)
+    if err != nil {
+        panic(err)
+    }
+
+    for _, rp := range ordered {
+        if rp.isKept == true {
+            kept++
+        }
+    }
+
+    return updated, kept, nil
+}
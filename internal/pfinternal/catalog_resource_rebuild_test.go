@@ -0,0 +1,177 @@
+package pfinternal
+
+import (
+    "testing"
+    "os"
+    "path"
+    "strings"
+
+    "io/ioutil"
+)
+
+func TestRebuildPathHashes(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    os.MkdirAll(path.Join(scanPath, "dir", "subdir"), 0755)
+    os.MkdirAll(path.Join(scanPath, "empty"), 0755)
+
+    ioutil.WriteFile(path.Join(scanPath, "aa"), []byte("aa"), 0644)
+    ioutil.WriteFile(path.Join(scanPath, "dir", "bb"), []byte("bb"), 0644)
+    ioutil.WriteFile(path.Join(scanPath, "dir", "subdir", "cc"), []byte("cc"), 0644)
+
+    hashAlgorithm := HashAlgorithm
+
+    cr, rootHash, err := scanIntoCatalog(&scanPath, &catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not scan.")
+    }
+
+    defer cr.Close()
+
+    // A catalog that's consistent with itself doesn't change.
+    updated, kept, err := cr.RebuildPathHashes()
+    if err != nil {
+        t.Fatalf("Could not rebuild consistent catalog.")
+    } else if updated != 0 {
+        t.Fatalf("Consistent catalog was changed: (%d)", updated)
+    } else if kept != 0 {
+        t.Fatalf("Catalog without symlinks had hashes kept: (%d)", kept)
+    }
+
+    relPaths := []string { "dir", "dir/subdir", "empty" }
+
+    expected := make(map[string]string)
+    for _, relPath := range relPaths {
+        rr, err := cr.ResolvePath(&relPath)
+        if err != nil {
+            t.Fatalf("Could not resolve [%s] before rebuild.", relPath)
+        }
+
+        expected[relPath] = rr.Hash
+    }
+
+    _, err = cr.db.Exec("UPDATE `paths` SET `hash` = NULL")
+    if err != nil {
+        t.Fatalf("Could not clear path hashes.")
+    }
+
+    updated, kept, err = cr.RebuildPathHashes()
+    if err != nil {
+        t.Fatalf("Could not rebuild path hashes.")
+    } else if updated != 4 {
+        t.Fatalf("Expected every path to be updated: (%d)", updated)
+    } else if kept != 0 {
+        t.Fatalf("Paths without hashes were kept: (%d)", kept)
+    }
+
+    rootRelPath := ""
+    rr, err := cr.ResolvePath(&rootRelPath)
+    if err != nil {
+        t.Fatalf("Could not resolve root.")
+    } else if rr.Hash != rootHash {
+        t.Fatalf("Rebuilt root hash doesn't match scan: [%s] != [%s]", rr.Hash, rootHash)
+    }
+
+    for _, relPath := range relPaths {
+        rr, err := cr.ResolvePath(&relPath)
+        if err != nil {
+            t.Fatalf("Could not resolve [%s] after rebuild.", relPath)
+        } else if rr.Hash != expected[relPath] {
+            t.Fatalf("Rebuilt hash for [%s] not correct: [%s] != [%s]", relPath, rr.Hash, expected[relPath])
+        }
+    }
+}
+
+func TestRebuildPathHashesSymlinks(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    os.MkdirAll(path.Join(scanPath, "dir", "subdir"), 0755)
+
+    ioutil.WriteFile(path.Join(scanPath, "aa"), []byte("aa"), 0644)
+    ioutil.WriteFile(path.Join(scanPath, "dir", "bb"), []byte("bb"), 0644)
+    ioutil.WriteFile(path.Join(scanPath, "dir", "subdir", "cc"), []byte("cc"), 0644)
+
+    err := os.Symlink("bb", path.Join(scanPath, "dir", "link"))
+    if err != nil {
+        t.Fatalf("Could not create symlink: %s", err)
+    }
+
+    hashAlgorithm := HashAlgorithm
+
+    cr, rootHash, err := scanIntoCatalog(&scanPath, &catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not scan.")
+    }
+
+    defer cr.Close()
+
+    dirRelPath := "dir"
+    rr, err := cr.ResolvePath(&dirRelPath)
+    if err != nil {
+        t.Fatalf("Could not resolve [%s] before rebuild.", dirRelPath)
+    }
+
+    dirHash := rr.Hash
+
+    // The path with the symlink keeps its hash, and the root is calculated
+    // from it.
+    updated, kept, err := cr.RebuildPathHashes()
+    if err != nil {
+        t.Fatalf("Could not rebuild catalog with symlinks.")
+    } else if updated != 0 {
+        t.Fatalf("Catalog with symlinks was changed: (%d)", updated)
+    } else if kept != 1 {
+        t.Fatalf("Expected the path with the symlink to be kept: (%d)", kept)
+    }
+
+    _, err = cr.db.Exec("UPDATE `paths` SET `hash` = '" + strings.Repeat("0", 40) + "' WHERE `rel_path` IN ('', 'dir/subdir')")
+    if err != nil {
+        t.Fatalf("Could not change path hashes.")
+    }
+
+    updated, kept, err = cr.RebuildPathHashes()
+    if err != nil {
+        t.Fatalf("Could not rebuild path hashes.")
+    } else if updated != 2 {
+        t.Fatalf("Expected the changed paths to be updated: (%d)", updated)
+    } else if kept != 1 {
+        t.Fatalf("Expected the path with the symlink to be kept: (%d)", kept)
+    }
+
+    rr, err = cr.ResolvePath(&dirRelPath)
+    if err != nil {
+        t.Fatalf("Could not resolve [%s] after rebuild.", dirRelPath)
+    } else if rr.Hash != dirHash {
+        t.Fatalf("Kept hash was changed: [%s] != [%s]", rr.Hash, dirHash)
+    }
+
+    rootRelPath := ""
+    rr, err = cr.ResolvePath(&rootRelPath)
+    if err != nil {
+        t.Fatalf("Could not resolve root.")
+    } else if rr.Hash != rootHash {
+        t.Fatalf("Rebuilt root hash doesn't match scan: [%s] != [%s]", rr.Hash, rootHash)
+    }
+}
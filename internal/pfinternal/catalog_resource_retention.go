@@ -0,0 +1,404 @@
+package pfinternal
+
+import (
+    "fmt"
+    "time"
+)
+
+// Which recorded scans to keep. A scan is kept if any of the rules keeps it.
+// If none of the rules are set, every scan is kept.
+type RetentionPolicy struct {
+    // Keep this many of the most recent scans.
+    KeepLast int
+
+    // Keep the last scan of each of this many of the most recent days that
+    // have scans.
+    KeepDaily int
+
+    // Keep the last scan of each of this many of the most recent weeks that
+    // have scans.
+    KeepWeekly int
+}
+
+func (self *RetentionPolicy) isEmpty() bool {
+    return self.KeepLast <= 0 && self.KeepDaily <= 0 && self.KeepWeekly <= 0
+}
+
+// Keep the newest scan of each of the newest (count) periods. The scans must
+// be newest-first.
+func keepScansByPeriod(scans []*ScanRecord, count int, period func(t time.Time) string, keep map[int]bool) {
+    lastPeriod := ""
+    kept := 0
+
+    for _, sr := range scans {
+        if kept >= count {
+            break
+        }
+
+        currentPeriod := period(time.Unix(sr.StartEpoch, 0))
+        if currentPeriod == lastPeriod {
+            continue
+        }
+
+        keep[sr.ScanId] = true
+        lastPeriod = currentPeriod
+        kept++
+    }
+}
+
+// Return the IDs of the scans that the policy keeps. Days and weeks are in
+// local time.
+func selectScansToKeep(scans []*ScanRecord, policy *RetentionPolicy) map[int]bool {
+    keep := make(map[int]bool)
+
+    if policy.isEmpty() == true {
+        for _, sr := range scans {
+            keep[sr.ScanId] = true
+        }
+
+        return keep
+    }
+
+    newestFirst := make([]*ScanRecord, len(scans))
+    for i, sr := range scans {
+        newestFirst[len(scans) - 1 - i] = sr
+    }
+
+    for i := 0; i < policy.KeepLast && i < len(newestFirst); i++ {
+        keep[newestFirst[i].ScanId] = true
+    }
+
+    keepScansByPeriod(newestFirst, policy.KeepDaily, func(t time.Time) string {
+        return t.Format("2006-01-02")
+    }, keep)
+
+    keepScansByPeriod(newestFirst, policy.KeepWeekly, func(t time.Time) string {
+        year, week := t.ISOWeek()
+        return fmt.Sprintf("%d-%d", year, week)
+    }, keep)
+
+    return keep
+}
+
+type historyKey struct {
+    entityType int
+    relPath string
+}
+
+// Reduce a run of changes to the net change for each entry. The changes must
+// be in the order that they were recorded. Entries that ended up the way that
+// they started are dropped.
+func netScanChanges(changes []*ScanChange) []*ScanChange {
+    first := make(map[historyKey]*ScanChange)
+    last := make(map[historyKey]*ScanChange)
+    order := make([]historyKey, 0)
+
+    for _, sc := range changes {
+        key := historyKey { sc.EntityType, sc.RelPath }
+
+        if _, found := first[key]; found == false {
+            first[key] = sc
+            order = append(order, key)
+        }
+
+        last[key] = sc
+    }
+
+    net := make([]*ScanChange, 0)
+
+    for _, key := range order {
+        existedBefore := first[key].ChangeType != UpdateTypeCreate
+        existsAfter := last[key].ChangeType != UpdateTypeDelete
+
+        sc := &ScanChange {
+                EntityType: key.entityType,
+                RelPath: key.relPath,
+        }
+
+        if existedBefore == false && existsAfter == false {
+            continue
+        } else if existedBefore == false {
+            sc.ChangeType = UpdateTypeCreate
+            sc.Hash = last[key].Hash
+        } else if existsAfter == false {
+            sc.ChangeType = UpdateTypeDelete
+            sc.PreviousHash = first[key].PreviousHash
+        } else {
+            sc.ChangeType = UpdateTypeUpdate
+            sc.Hash = last[key].Hash
+            sc.PreviousHash = first[key].PreviousHash
+
+            if sc.Hash == sc.PreviousHash {
+                continue
+            }
+        }
+
+        net = append(net, sc)
+    }
+
+    return net
+}
+
+// Fold the changes of the given scans into the scan that follows them and
+// remove them. This keeps the history of the later scans intact. The scan that
+// they're folded into must have finished. The changes of removed scans that
+// didn't finish are dropped (they were never replayed), and if any of the 
+// removed scans was a baseline, the scan that they're folded into becomes one.
+func (self *catalogResource) foldScans(removed []*ScanRecord, into *ScanRecord) (err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            err = r.(error)
+            l.Error("Could not fold scans", "into", into.ScanId, "err", err)
+        }
+    }()
+
+    // Nothing before the last baseline matters to the scans after it.
+    firstScanId := removed[0].ScanId
+    isBaseline := into.IsBaseline
+
+    folded := make([]*ScanRecord, 0, len(removed) + 1)
+    folded = append(folded, removed...)
+    folded = append(folded, into)
+
+    replayed := make(map[int]bool)
+    for _, sr := range folded {
+        if sr.IsComplete() == false {
+            continue
+        } else if sr.IsBaseline == true {
+            firstScanId = sr.ScanId
+            isBaseline = true
+        }
+
+        replayed[sr.ScanId] = true
+    }
+
+    allChanges, err := self.loadScanChanges("`sc`.`scan_id` >= ? AND `sc`.`scan_id` <= ?", firstScanId, into.ScanId)
+    if err != nil {
+        panic(err)
+    }
+
+    // Scans that are kept might be in the range, too.
+    changes := make([]*ScanChange, 0, len(allChanges))
+    for _, sc := range allChanges {
+        if replayed[sc.ScanId] == true {
+            changes = append(changes, sc)
+        }
+    }
+
+    net := netScanChanges(changes)
+
+    counts := make(map[int]int)
+    for _, sc := range net {
+        counts[sc.ChangeType]++
+    }
+
+    tx, err := self.db.Begin()
+    if err != nil {
+        panic(err)
+    }
+
+    statements := []string {
+        "DELETE FROM `scan_changes` WHERE `scan_id` = ?",
+        "DELETE FROM `scans` WHERE `scan_id` = ?",
+    }
+
+    for _, sr := range removed {
+        for _, statement := range statements {
+            _, err = tx.Exec(statement, sr.ScanId)
+            if err != nil {
+                tx.Rollback()
+                panic(err)
+            }
+        }
+    }
+
+    _, err = tx.Exec(statements[0], into.ScanId)
+    if err != nil {
+        tx.Rollback()
+        panic(err)
+    }
+
+    query :=
+        "INSERT INTO `scan_changes` " +
+            "(`scan_id`, `entity_type`, `change_type`, `rel_path`, `hash`, `previous_hash`) " +
+        "VALUES " +
+            "(?, ?, ?, ?, ?, ?)"
+
+    stmt, err := tx.Prepare(query)
+    if err != nil {
+        tx.Rollback()
+        panic(err)
+    }
+
+    defer stmt.Close()
+
+    for _, sc := range net {
+        var hash *string
+        if sc.Hash != "" {
+            hash = &sc.Hash
+        }
+
+        var previousHash *string
+        if sc.PreviousHash != "" {
+            previousHash = &sc.PreviousHash
+        }
+
+        _, err = stmt.Exec(into.ScanId, EntityTypeName(sc.EntityType), UpdateTypeName(sc.ChangeType), sc.RelPath, hash, previousHash)
+        if err != nil {
+            tx.Rollback()
+            panic(err)
+        }
+    }
+
+    query =
+        "UPDATE " +
+            "`scans` " +
+        "SET " +
+            "`create_count` = ?, " +
+            "`update_count` = ?, " +
+            "`delete_count` = ?, " +
+            "`is_baseline` = ? " +
+        "WHERE " +
+            "`scan_id` = ?"
+
+    _, err = tx.Exec(query, counts[UpdateTypeCreate], counts[UpdateTypeUpdate], counts[UpdateTypeDelete], isBaseline, into.ScanId)
+    if err != nil {
+        tx.Rollback()
+        panic(err)
+    }
+
+    err = tx.Commit()
+    if err != nil {
+        panic(err)
+    }
+
+    l.Debug("Folded scans.", "first", firstScanId, "into", into.ScanId, "changes", len(changes), "net", len(net))
+
+    return nil
+}
+
+// Remove the recorded scans that the policy doesn't keep. The changes of a
+// removed scan are folded into the next scan that's kept, so the hashes as of
+// the remaining scans don't change. The newest scan is always kept. Scans that
+// didn't finish can't have anything folded into them, so the scans before the
+// newest one are kept if it didn't finish. Returns the scans that were (or, if
+// dryRun is true, would be) removed.
+func (self *catalogResource) ApplyRetention(policy *RetentionPolicy, dryRun bool) (removed []*ScanRecord, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            removed = nil
+            err = r.(error)
+
+            l.Error("Could not apply retention policy", "err", err)
+        }
+    }()
+
+    scans, err := self.ListScans()
+    if err != nil {
+        panic(err)
+    }
+
+    keep := selectScansToKeep(scans, policy)
+
+    if len(scans) > 0 {
+        keep[scans[len(scans) - 1].ScanId] = true
+    }
+
+    removed = make([]*ScanRecord, 0)
+    pending := make([]*ScanRecord, 0)
+
+    for _, sr := range scans {
+        if keep[sr.ScanId] == false {
+            pending = append(pending, sr)
+            continue
+        } else if sr.IsComplete() == false {
+            continue
+        }
+
+        if len(pending) > 0 && dryRun == false {
+            err = self.foldScans(pending, sr)
+            if err != nil {
+                panic(err)
+            }
+        }
+
+        removed = append(removed, pending...)
+        pending = pending[:0]
+    }
+
+    return removed, nil
+}
+
+// The result of compacting the catalog.
+type CompactResult struct {
+    SizeBefore int64
+    SizeAfter int64
+}
+
+func (self *CompactResult) Reclaimed() int64 {
+    return self.SizeBefore - self.SizeAfter
+}
+
+func (self *catalogResource) getDatabaseSize() (size int64, err error) {
+    var pageCount int64
+    var pageSize int64
+
+    err = self.db.QueryRow("PRAGMA page_count").Scan(&pageCount)
+    if err != nil {
+        return 0, err
+    }
+
+    err = self.db.QueryRow("PRAGMA page_size").Scan(&pageSize)
+    if err != nil {
+        return 0, err
+    }
+
+    return pageCount * pageSize, nil
+}
+
+// Rebuild the catalog to release the space left behind by deleted records,
+// and refresh the statistics that SQLite uses to plan queries.
+func (self *catalogResource) Compact() (result *CompactResult, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            result = nil
+            err = r.(error)
+
+            l.Error("Could not compact catalog", "err", err)
+        }
+    }()
+
+    result = &CompactResult {}
+
+    // The statistics take space of their own, so they're collected before we
+    // measure what the vacuum reclaims.
+    _, err = self.db.Exec("ANALYZE")
+    if err != nil {
+        panic(err)
+    }
+
+    result.SizeBefore, err = self.getDatabaseSize()
+    if err != nil {
+        panic(err)
+    }
+
+    _, err = self.db.Exec("VACUUM")
+    if err != nil {
+        panic(err)
+    }
+
+    result.SizeAfter, err = self.getDatabaseSize()
+    if err != nil {
+        panic(err)
+    }
+
+    l.Debug("Compacted catalog.", "before", result.SizeBefore, "after", result.SizeAfter)
+
+    return result, nil
+}
@@ -0,0 +1,348 @@
+package pfinternal
+
+import (
+    "testing"
+    "os"
+    "path"
+    "time"
+    "sort"
+    "fmt"
+    "strings"
+    "bytes"
+
+    "io/ioutil"
+)
+
+func TestSelectScansToKeep(t *testing.T) {
+    // Two scans a day, for three weeks, starting on a Monday.
+    start := time.Date(2016, 1, 4, 0, 0, 0, 0, time.Local)
+
+    scans := make([]*ScanRecord, 0)
+    for day := 0; day < 21; day++ {
+        for _, hour := range []int { 9, 21 } {
+            epoch := start.AddDate(0, 0, day).Add(time.Hour * time.Duration(hour)).Unix()
+
+            sr := &ScanRecord {
+                    ScanId: len(scans) + 1,
+                    StartEpoch: epoch,
+            }
+
+            scans = append(scans, sr)
+        }
+    }
+
+    cases := []struct {
+        policy RetentionPolicy
+        expected []int
+    } {
+        // Everything.
+        { RetentionPolicy {}, nil },
+        { RetentionPolicy { KeepLast: 3 }, []int { 40, 41, 42 } },
+        // The evening scan of each of the last three days.
+        { RetentionPolicy { KeepDaily: 3 }, []int { 38, 40, 42 } },
+        // The Sunday evening scan of each week.
+        { RetentionPolicy { KeepWeekly: 5 }, []int { 14, 28, 42 } },
+        { RetentionPolicy { KeepLast: 2, KeepDaily: 2, KeepWeekly: 2 }, []int { 28, 40, 41, 42 } },
+    }
+
+    for i, c := range cases {
+        keep := selectScansToKeep(scans, &c.policy)
+
+        actual := make([]int, 0)
+        for scanId, _ := range keep {
+            actual = append(actual, scanId)
+        }
+
+        sort.Ints(actual)
+
+        if c.expected == nil {
+            if len(actual) != len(scans) {
+                t.Fatalf("Expected every scan to be kept for case (%d): (%d)", i, len(actual))
+            }
+        } else if fmt.Sprintf("%v", actual) != fmt.Sprintf("%v", c.expected) {
+            t.Fatalf("Kept scans not correct for case (%d): %v", i, actual)
+        }
+    }
+}
+
+func TestNetScanChanges(t *testing.T) {
+    changes := []*ScanChange {
+        // Created and then updated.
+        &ScanChange { EntityType: EntityTypePath, ChangeType: UpdateTypeCreate, RelPath: "dir" },
+        &ScanChange { EntityType: EntityTypePath, ChangeType: UpdateTypeUpdate, RelPath: "dir", Hash: "h1" },
+
+        // Created and then deleted.
+        &ScanChange { EntityType: EntityTypeFile, ChangeType: UpdateTypeCreate, RelPath: "dir/tmp", Hash: "h2" },
+        &ScanChange { EntityType: EntityTypeFile, ChangeType: UpdateTypeDelete, RelPath: "dir/tmp", PreviousHash: "h2" },
+
+        // Changed and then changed back.
+        &ScanChange { EntityType: EntityTypeFile, ChangeType: UpdateTypeUpdate, RelPath: "aa", Hash: "h4", PreviousHash: "h3" },
+        &ScanChange { EntityType: EntityTypeFile, ChangeType: UpdateTypeUpdate, RelPath: "aa", Hash: "h3", PreviousHash: "h4" },
+
+        // Deleted and then recreated.
+        &ScanChange { EntityType: EntityTypeFile, ChangeType: UpdateTypeDelete, RelPath: "bb", PreviousHash: "h5" },
+        &ScanChange { EntityType: EntityTypeFile, ChangeType: UpdateTypeCreate, RelPath: "bb", Hash: "h6" },
+
+        // Updated and then deleted.
+        &ScanChange { EntityType: EntityTypeFile, ChangeType: UpdateTypeUpdate, RelPath: "cc", Hash: "h8", PreviousHash: "h7" },
+        &ScanChange { EntityType: EntityTypeFile, ChangeType: UpdateTypeDelete, RelPath: "cc", PreviousHash: "h8" },
+    }
+
+    actual := make([]string, 0)
+    for _, sc := range netScanChanges(changes) {
+        actual = append(actual, fmt.Sprintf("%s %s %s [%s] [%s]",
+                                    UpdateTypeName(sc.ChangeType),
+                                    EntityTypeName(sc.EntityType),
+                                    sc.RelPath,
+                                    sc.PreviousHash,
+                                    sc.Hash))
+    }
+
+    expected := []string {
+        "create path dir [] [h1]",
+        "update file bb [h5] [h6]",
+        "delete file cc [h7] []",
+    }
+
+    if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+        t.Fatalf("Net changes not correct:\n%s", strings.Join(actual, "\n"))
+    }
+}
+
+func TestApplyRetention(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    cr := recordTestHistory(t, catalogFilepath, scanPath)
+    defer cr.Close()
+
+    relPaths := []string { "", "aa", "dir", "dir/cc" }
+
+    expected := make(map[string]string)
+    for _, relPath := range relPaths {
+        sc, err := cr.ResolvePathAsOf(&relPath, 2)
+        if err != nil {
+            t.Fatalf("Could not resolve [%s] before retention.", relPath)
+        }
+
+        expected[relPath] = sc.Hash
+    }
+
+    removed, err := cr.ApplyRetention(&RetentionPolicy { KeepLast: 1 }, true)
+    if err != nil {
+        t.Fatalf("Could not do dry run.")
+    } else if len(removed) != 1 || removed[0].ScanId != 1 {
+        t.Fatalf("Dry run should report the first scan.")
+    }
+
+    scans, err := cr.ListScans()
+    if err != nil {
+        t.Fatalf("Could not list scans.")
+    } else if len(scans) != 2 {
+        t.Fatalf("Dry run removed scans.")
+    }
+
+    removed, err = cr.ApplyRetention(&RetentionPolicy { KeepLast: 1 }, false)
+    if err != nil {
+        t.Fatalf("Could not apply retention.")
+    } else if len(removed) != 1 {
+        t.Fatalf("Expected one scan to be removed: (%d)", len(removed))
+    }
+
+    scans, err = cr.ListScans()
+    if err != nil {
+        t.Fatalf("Could not list scans.")
+    } else if len(scans) != 1 || scans[0].ScanId != 2 {
+        t.Fatalf("Only the last scan should be left.")
+    } else if scans[0].Creates != 4 || scans[0].Updates != 0 || scans[0].Deletes != 0 {
+        t.Fatalf("Counts not updated: %v", scans[0])
+    }
+
+    // The remaining scan has to describe the same tree that it did before.
+    for _, relPath := range relPaths {
+        sc, err := cr.ResolvePathAsOf(&relPath, 2)
+        if err != nil {
+            t.Fatalf("Could not resolve [%s] after retention.", relPath)
+        } else if sc.Hash != expected[relPath] {
+            t.Fatalf("Hash for [%s] changed: [%s] != [%s]", relPath, sc.Hash, expected[relPath])
+        }
+    }
+
+    relPath := "dir/bb"
+    _, err = cr.ResolvePathAsOf(&relPath, 2)
+    if err != ErrPathNotFound {
+        t.Fatalf("Deleted file came back: %v", err)
+    }
+
+    result, err := cr.Compact()
+    if err != nil {
+        t.Fatalf("Could not compact catalog.")
+    } else if result.SizeAfter > result.SizeBefore || result.SizeAfter == 0 {
+        t.Fatalf("Sizes not correct: (%d) -> (%d)", result.SizeBefore, result.SizeAfter)
+    }
+}
+
+func TestApplyRetentionAcrossBaseline(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    // Scans (1), (2), and (3), which fails.
+    cr := recordTestHistory(t, catalogFilepath, scanPath)
+    recordFailedTestScan(t, cr)
+    cr.Close()
+
+    ioutil.WriteFile(path.Join(scanPath, "ee"), []byte("ee"), 0644)
+
+    time.Sleep(time.Second * 1)
+
+    // Scans (4), the baseline, and (5).
+    hashAlgorithm := HashAlgorithm
+
+    cr, _, err := scanIntoCatalog(&scanPath, &catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not scan after failure.")
+    }
+
+    defer cr.Close()
+
+    // Scan (6) fails. Nothing can be folded into it, so nothing is removed.
+    recordFailedTestScan(t, cr)
+
+    removed, err := cr.ApplyRetention(&RetentionPolicy { KeepLast: 1 }, false)
+    if err != nil {
+        t.Fatalf("Could not apply retention with a failed scan last.")
+    } else if len(removed) != 0 {
+        t.Fatalf("Scans removed without anything to fold them into: (%d)", len(removed))
+    }
+
+    removed, err = cr.ApplyRetention(&RetentionPolicy { KeepLast: 2 }, false)
+    if err != nil {
+        t.Fatalf("Could not apply retention.")
+    } else if len(removed) != 4 {
+        t.Fatalf("Expected four scans to be removed: (%d)", len(removed))
+    }
+
+    scans, err := cr.ListScans()
+    if err != nil {
+        t.Fatalf("Could not list scans.")
+    } else if len(scans) != 2 || scans[0].ScanId != 5 || scans[1].ScanId != 6 {
+        t.Fatalf("Scans (5) and (6) should be left.")
+    } else if scans[0].IsBaseline == false {
+        t.Fatalf("Baseline not folded into the scan that was kept.")
+    } else if scans[0].Creates != 5 || scans[0].Updates != 0 || scans[0].Deletes != 0 {
+        t.Fatalf("Counts not updated: %v", scans[0])
+    }
+
+    checkReplayMatchesCatalog(t, cr, 5)
+}
+
+func TestApplyRetentionIntoEarlierScan(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    // Scans (1) and (2).
+    cr := recordTestHistory(t, catalogFilepath, scanPath)
+    cr.Close()
+
+    hashAlgorithm := HashAlgorithm
+
+    // Scan (3) creates a file and scan (4) deletes it again.
+    ioutil.WriteFile(path.Join(scanPath, "ee"), []byte("ee"), 0644)
+
+    time.Sleep(time.Second * 1)
+
+    cr, _, err := scanIntoCatalog(&scanPath, &catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not do third scan.")
+    }
+
+    cr.Close()
+
+    os.Remove(path.Join(scanPath, "ee"))
+    ioutil.WriteFile(path.Join(scanPath, "aa"), []byte("aa3"), 0644)
+
+    time.Sleep(time.Second * 1)
+
+    cr, rootHash, err := scanIntoCatalog(&scanPath, &catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not do fourth scan.")
+    }
+
+    defer cr.Close()
+
+    // Scans (1) and (2) are folded into (3), which has a scan after it.
+    removed, err := cr.ApplyRetention(&RetentionPolicy { KeepLast: 2 }, false)
+    if err != nil {
+        t.Fatalf("Could not apply retention.")
+    } else if len(removed) != 2 {
+        t.Fatalf("Expected two scans to be removed: (%d)", len(removed))
+    }
+
+    checkReplayMatchesCatalog(t, cr, 4)
+
+    rootRelPath := ""
+    sc, err := cr.ResolvePathAsOf(&rootRelPath, 4)
+    if err != nil {
+        t.Fatalf("Could not resolve root as of the last scan.")
+    } else if sc.Hash != rootHash {
+        t.Fatalf("Root hash as of the last scan not correct: [%s] != [%s]", sc.Hash, rootHash)
+    }
+
+    relPath := "ee"
+    _, err = cr.ResolvePathAsOf(&relPath, 4)
+    if err != ErrPathNotFound {
+        t.Fatalf("Deleted file resolved as of the last scan: %v", err)
+    }
+
+    b := new(bytes.Buffer)
+    rcs := NewReportChangeSink(b, false)
+
+    _, err = cr.DiffScans(3, 4, &rootRelPath, rcs)
+    if err != nil {
+        t.Fatalf("Could not diff the kept scans.")
+    }
+
+    rcs.End()
+
+    expected := "update path .\nupdate file aa\ndelete file ee\n"
+    if b.String() != expected {
+        t.Fatalf("Diff of the kept scans not correct:\n%s", b.String())
+    }
+
+    timeline, err := cr.GetTimeline(&relPath)
+    if err != nil {
+        t.Fatalf("Could not get timeline.")
+    } else if len(timeline) != 2 || timeline[0].ScanId != 3 || timeline[1].ScanId != 4 {
+        t.Fatalf("Timeline not in order.")
+    }
+}
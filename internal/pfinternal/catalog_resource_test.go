@@ -0,0 +1,390 @@
+package pfinternal
+
+import (
+    "testing"
+    "os"
+    "path"
+    "strings"
+    "database/sql"
+)
+
+func TestResolvePathNotFound(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    dir1Path := path.Join(scanPath, "dir1")
+    os.Mkdir(dir1Path, 0755)
+
+    createSpecificFile(scanPath, "aa")
+    createSpecificFile(dir1Path, "bb")
+
+    hashAlgorithm := HashAlgorithm
+
+    cr, err := NewCatalogResource(&catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not create catalog-resource.")
+    }
+
+    err = cr.Open()
+    if err != nil {
+        t.Fatalf("Could not open catalog.")
+    }
+
+    defer cr.Close()
+
+    relPath := ""
+    _, err = cr.ResolvePath(&relPath)
+    if err != ErrRootNotRecorded {
+        t.Fatalf("Expected root-not-recorded error for empty catalog: %v", err)
+    }
+
+    scanAndCollectChanges(t, catalogFilepath, scanPath, true)
+
+    lookups := map[string]error {
+        "": nil,
+        ".": nil,
+        "aa": nil,
+        "dir1": nil,
+        "dir1/": nil,
+        "dir1/bb": nil,
+        "zz": ErrFileNotFound,
+        "dir1/zz": ErrFileNotFound,
+        "dir2/zz": ErrPathNotFound,
+    }
+
+    for relPath, expectedErr := range lookups {
+        rr, err := cr.ResolvePath(&relPath)
+        if err != expectedErr {
+            t.Fatalf("Lookup of [%s] returned the wrong error: %v", relPath, err)
+        } else if expectedErr == nil && rr.Hash == "" {
+            t.Fatalf("Lookup of [%s] didn't return a hash.", relPath)
+        }
+    }
+
+    relPath = "aa"
+    rr, err := cr.ResolvePath(&relPath)
+    if err != nil {
+        t.Fatalf("Could not resolve root file.")
+    } else if rr.RelPath != "" || rr.Filename != "aa" || rr.FileId == 0 {
+        t.Fatalf("Root file not resolved correctly: %v", rr)
+    }
+
+    relPath = "dir2"
+    _, err = cr.ListChildren(&relPath, []int { 1 }, false)
+    if err != ErrPathNotFound {
+        t.Fatalf("Expected path-not-found error for children: %v", err)
+    }
+
+    _, err = cr.GetTree(&relPath, 0)
+    if err != ErrPathNotFound {
+        t.Fatalf("Expected path-not-found error for tree: %v", err)
+    }
+}
+
+func TestFindByHash(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    dir1Path := path.Join(scanPath, "dir1")
+    os.Mkdir(dir1Path, 0755)
+
+    createSpecificFile(scanPath, "aa")
+    createSpecificFile(dir1Path, "bb")
+
+    scanAndCollectChanges(t, catalogFilepath, scanPath, true)
+
+    hashAlgorithm := HashAlgorithm
+
+    cr, err := NewCatalogResource(&catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not create catalog-resource.")
+    }
+
+    err = cr.Open()
+    if err != nil {
+        t.Fatalf("Could not open catalog.")
+    }
+
+    defer cr.Close()
+
+    relPath := "dir1"
+    rr, err := cr.ResolvePath(&relPath)
+    if err != nil {
+        t.Fatalf("Could not resolve path.")
+    }
+
+    results, err := cr.FindByHash(strings.ToUpper(rr.Hash))
+    if err != nil {
+        t.Fatalf("Could not find path by hash.")
+    } else if len(results) != 1 || results[0].RelPath != "dir1" || results[0].FileId != 0 {
+        t.Fatalf("Path not found by hash: %v", results)
+    }
+
+    // Both files are empty.
+    results, err = cr.FindByHash("da39a3ee5e6b4b0d3255bfef95601890afd80709")
+    if err != nil {
+        t.Fatalf("Could not find files by hash.")
+    } else if len(results) != 2 || results[0].Filename != "aa" || results[1].RelPath != "dir1" || results[1].Filename != "bb" {
+        t.Fatalf("Files not found by hash: %v", results)
+    }
+
+    results, err = cr.FindByHash("0000000000000000000000000000000000000000")
+    if err != nil {
+        t.Fatalf("Could not search for unknown hash.")
+    } else if len(results) != 0 {
+        t.Fatalf("Expected no results for unknown hash: %v", results)
+    }
+}
+
+func TestFindDuplicates(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    for _, name := range []string { "a", "b" } {
+        copyPath := path.Join(scanPath, name)
+        subPath := path.Join(copyPath, "sub")
+
+        os.MkdirAll(subPath, 0755)
+
+        createSpecificFile(copyPath, "x")
+        createSpecificFile(subPath, "y")
+    }
+
+    createSpecificFile(scanPath, "z")
+
+    scanAndCollectChanges(t, catalogFilepath, scanPath, true)
+
+    hashAlgorithm := HashAlgorithm
+
+    cr, err := NewCatalogResource(&catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not create catalog-resource.")
+    }
+
+    err = cr.Open()
+    if err != nil {
+        t.Fatalf("Could not open catalog.")
+    }
+
+    defer cr.Close()
+
+    relPath := ""
+    dr, err := cr.FindDuplicates(&relPath, 0)
+    if err != nil {
+        t.Fatalf("Could not find duplicates.")
+    }
+
+    // The copies of "sub" are inside of duplicated paths, so they're not
+    // reported. The empty files are, because "z" is outside of them.
+
+    if len(dr.Paths) != 1 || strings.Join(dr.Paths[0].RelPaths, ",") != "a,b" {
+        t.Fatalf("Duplicate paths not correct: %v", dr.Paths)
+    } else if len(dr.Files) != 1 || strings.Join(dr.Files[0].RelPaths, ",") != "a/sub/y,a/x,b/sub/y,b/x,z" {
+        t.Fatalf("Duplicate files not correct: %v", dr.Files)
+    } else if dr.WastedBytes != 0 {
+        t.Fatalf("Wasted bytes not correct: (%d)", dr.WastedBytes)
+    }
+
+    // Everything is empty.
+    dr, err = cr.FindDuplicates(&relPath, 1)
+    if err != nil {
+        t.Fatalf("Could not find duplicates with minimum size.")
+    } else if len(dr.Paths) != 0 || len(dr.Files) != 0 {
+        t.Fatalf("Expected no duplicates with minimum size: %v %v", dr.Paths, dr.Files)
+    }
+}
+
+func TestOpenOlderCatalogReadOnly(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    createSpecificFile(scanPath, "aa")
+
+    scanAndCollectChanges(t, catalogFilepath, scanPath, true)
+
+    hashAlgorithm := HashAlgorithm
+
+    // Make it look like a catalog from before the algorithm was recorded.
+    cr, err := NewCatalogResource(&catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not create catalog-resource.")
+    }
+
+    err = cr.Open()
+    if err != nil {
+        t.Fatalf("Could not open catalog.")
+    }
+
+    _, err = cr.db.Exec("DELETE FROM `catalog_info` WHERE `key` = 'hash_algorithm'")
+    if err != nil {
+        t.Fatalf("Could not forget hash algorithm: %s", err)
+    }
+
+    cr.Close()
+
+    readOnlyDsn := "file:" + catalogFilepath + "?mode=ro"
+
+    // The algorithm is only inferred, so opening and scanning without updates
+    // works on a catalog that can't be written.
+    changes := scanAndCollectChanges(t, readOnlyDsn, scanPath, false)
+    if len(changes) != 1 || changes[0] != "" {
+        t.Fatalf("Expected no changes: %v", changes)
+    }
+
+    otherAlgorithm := Sha256Algorithm
+
+    cr, err = NewCatalogResource(&readOnlyDsn, &otherAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not create catalog-resource.")
+    }
+
+    err = cr.Open()
+    if err != ErrAlgorithmMismatch {
+        t.Fatalf("Expected algorithm mismatch: %v", err)
+    }
+
+    // A scan that's allowed to update the catalog records the algorithm.
+    scanAndCollectChanges(t, catalogFilepath, scanPath, true)
+
+    cr, err = NewCatalogResource(&catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not create catalog-resource.")
+    }
+
+    err = cr.Open()
+    if err != nil {
+        t.Fatalf("Could not reopen catalog.")
+    }
+
+    defer cr.Close()
+
+    var recordedAlgorithm string
+
+    err = cr.db.QueryRow("SELECT `value` FROM `catalog_info` WHERE `key` = 'hash_algorithm'").Scan(&recordedAlgorithm)
+    if err != nil {
+        t.Fatalf("Hash algorithm not recorded: %s", err)
+    } else if recordedAlgorithm != hashAlgorithm {
+        t.Fatalf("Hash algorithm not recorded correctly: [%s]", recordedAlgorithm)
+    }
+}
+
+func TestOpenReadOnlyLeavesOlderSchema(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    createSpecificFile(scanPath, "aa")
+
+    scanAndCollectChanges(t, catalogFilepath, scanPath, true)
+
+    hashAlgorithm := HashAlgorithm
+
+    cr, err := NewCatalogResource(&catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not create catalog-resource.")
+    }
+
+    // A current catalog can be opened read-only.
+    err = cr.OpenReadOnly()
+    if err != nil {
+        t.Fatalf("Could not open catalog read-only: %s", err)
+    }
+
+    cr.Close()
+
+    // Make it look like a catalog from before the buffer hashes were fixed.
+    err = cr.Open()
+    if err != nil {
+        t.Fatalf("Could not open catalog.")
+    }
+
+    _, err = cr.db.Exec("UPDATE `catalog_info` SET `value` = '4' WHERE `key` = 'schema_version'")
+    if err != nil {
+        t.Fatalf("Could not set schema version: %s", err)
+    }
+
+    cr.Close()
+
+    err = cr.OpenReadOnly()
+    if err != ErrSchemaOutdated {
+        t.Fatalf("Expected outdated schema: %v", err)
+    }
+
+    // Nothing was upgraded, so the next scan doesn't have to rehash.
+    cr, err = NewCatalogResource(&catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not create catalog-resource.")
+    }
+
+    db, err := sql.Open(DbType, catalogFilepath)
+    if err != nil {
+        t.Fatalf("Could not open database: %s", err)
+    }
+
+    defer db.Close()
+
+    version, err := cr.getSchemaVersion(db)
+    if err != nil {
+        t.Fatalf("Could not read schema version: %s", err)
+    } else if version != 4 {
+        t.Fatalf("Schema was upgraded: (%d)", version)
+    }
+
+    var resetCount int
+
+    err = db.QueryRow("SELECT COUNT(*) FROM `files` WHERE `mtime_epoch` = 0").Scan(&resetCount)
+    if err != nil {
+        t.Fatalf("Could not count files: %s", err)
+    } else if resetCount != 0 {
+        t.Fatalf("Modified-times were reset: (%d)", resetCount)
+    }
+}
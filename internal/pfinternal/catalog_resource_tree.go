@@ -0,0 +1,292 @@
+package pfinternal
+
+import (
+    "sort"
+    "path"
+
+    "database/sql"
+)
+
+// A path or file in the tree returned by GetTree().
+type CatalogTreeNode struct {
+    Name string
+    RelPath string
+    IsPath bool
+    Hash string
+
+    // For paths, this is the total size of all of the files below it. For
+    // files, this is UnknownFileSize if it hasn't been recorded yet.
+    Size int64
+
+    // For paths, this is the latest mtime of all of the files below it.
+    Mtime int64
+
+    // Whether the entry was created or updated during the last scan.
+    Changed bool
+
+    Children []*CatalogTreeNode
+}
+
+type catalogTreeNodesByName []*CatalogTreeNode
+
+func (self catalogTreeNodesByName) Len() int {
+    return len(self)
+}
+
+func (self catalogTreeNodesByName) Swap(i, j int) {
+    self[i], self[j] = self[j], self[i]
+}
+
+func (self catalogTreeNodesByName) Less(i, j int) bool {
+    return self[i].Name < self[j].Name
+}
+
+// Return the parent of the given relative path. The parent of a top-level
+// entry is the root ("").
+func parentRelPath(relPath string) string {
+    parent := path.Dir(relPath)
+    if parent == "." {
+        return ""
+    }
+
+    return parent
+}
+
+// Return the epoch of the last scan that was allowed to update the catalog.
+func (self *catalogResource) getLastScanEpoch() (epoch int64, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            epoch = 0
+            err = r.(error)
+
+            l.Error("Could not get last scan epoch", "err", err)
+        }
+    }()
+
+    // The root path is checked on every scan.
+    query :=
+        "SELECT " +
+            "`p`.`last_check_epoch` " +
+        "FROM " +
+            "`paths` `p` " +
+        "WHERE " +
+            "`p`.`rel_path` = ''"
+
+    err = self.db.QueryRow(query).Scan(&epoch)
+    if err == sql.ErrNoRows {
+        return 0, nil
+    } else if err != nil {
+        panic(err)
+    }
+
+    return epoch, nil
+}
+
+// Build a tree of the paths and files at and below the given path, entirely
+// from the catalog. Entries more than maxDepth levels below the path aren't
+// included (though they're still counted in the sizes and mtimes of the paths
+// above them). A maxDepth of (0) means no limit.
+func (self *catalogResource) GetTree(relPath *string, maxDepth int) (root *CatalogTreeNode, err error) {
+    l := NewLogger("catalog_resource")
+
+    defer func() {
+        if r := recover(); r != nil {
+            root = nil
+            err = r.(error)
+
+            l.Error("Could not build tree", "err", err)
+        }
+    }()
+
+    normalRelPath := cleanRelPath(*relPath)
+    relPath = &normalRelPath
+
+    lastScanEpoch, err := self.getLastScanEpoch()
+    if err != nil {
+        panic(err)
+    }
+
+    condition, args := descendantPathsCondition("p", *relPath)
+    allArgs := append([]interface{} { *relPath }, args...)
+
+    query :=
+        "SELECT " +
+            "`p`.`path_id`, " +
+            "`p`.`rel_path`, " +
+            "`p`.`hash`, " +
+            "`p`.`last_change_epoch` " +
+        "FROM " +
+            "`paths` `p` " +
+        "WHERE " +
+            "`p`.`rel_path` = ? OR (" + condition + ")"
+
+    stmt, err := self.db.Prepare(query)
+    if err != nil {
+        panic(err)
+    }
+
+    rows, err := stmt.Query(allArgs...)
+    if err != nil {
+        panic(err)
+    }
+
+    defer rows.Close()
+
+    pathNodes := make(map[string]*CatalogTreeNode)
+    pathNodesById := make(map[int]*CatalogTreeNode)
+
+    for rows.Next() {
+        var pathId int
+        var childRelPath string
+        var hash sql.NullString
+        var lastChangeEpoch sql.NullInt64
+
+        err = rows.Scan(&pathId, &childRelPath, &hash, &lastChangeEpoch)
+        if err != nil {
+            panic(err)
+        }
+
+        node := &CatalogTreeNode {
+                Name: path.Base(childRelPath),
+                RelPath: childRelPath,
+                IsPath: true,
+                Hash: hash.String,
+                Changed: lastChangeEpoch.Int64 != 0 && lastChangeEpoch.Int64 == lastScanEpoch,
+                Children: make([]*CatalogTreeNode, 0),
+        }
+
+        pathNodes[childRelPath] = node
+        pathNodesById[pathId] = node
+    }
+
+    err = rows.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    root, found := pathNodes[*relPath]
+    if found == false {
+        if *relPath == "" {
+            panic(ErrRootNotRecorded)
+        }
+
+        panic(ErrPathNotFound)
+    }
+
+    if *relPath == "" {
+        root.Name = "."
+    }
+
+    query =
+        "SELECT " +
+            "`f`.`path_id`, " +
+            "`f`.`filename`, " +
+            "`f`.`hash`, " +
+            "`f`.`mtime_epoch`, " +
+            "`f`.`size`, " +
+            "`f`.`last_change_epoch` " +
+        "FROM " +
+            "`files` `f`, " +
+            "`paths` `p` " +
+        "WHERE " +
+            "`p`.`path_id` = `f`.`path_id` AND " +
+            "(`p`.`rel_path` = ? OR (" + condition + "))"
+
+    stmt, err = self.db.Prepare(query)
+    if err != nil {
+        panic(err)
+    }
+
+    fileRows, err := stmt.Query(allArgs...)
+    if err != nil {
+        panic(err)
+    }
+
+    defer fileRows.Close()
+
+    for fileRows.Next() {
+        var pathId int
+        var filename string
+        var hash string
+        var mtimeEpoch int64
+        var size sql.NullInt64
+        var lastChangeEpoch sql.NullInt64
+
+        err = fileRows.Scan(&pathId, &filename, &hash, &mtimeEpoch, &size, &lastChangeEpoch)
+        if err != nil {
+            panic(err)
+        }
+
+        parent := pathNodesById[pathId]
+
+        node := &CatalogTreeNode {
+                Name: filename,
+                RelPath: path.Join(parent.RelPath, filename),
+                Hash: hash,
+                Size: UnknownFileSize,
+                Mtime: mtimeEpoch,
+                Changed: lastChangeEpoch.Int64 != 0 && lastChangeEpoch.Int64 == lastScanEpoch,
+        }
+
+        if size.Valid == true {
+            node.Size = size.Int64
+        }
+
+        parent.Children = append(parent.Children, node)
+
+        // Roll the size and mtime up into every path above it.
+
+        ancestor := parent
+        for {
+            if node.Size > 0 {
+                ancestor.Size += node.Size
+            }
+
+            if node.Mtime > ancestor.Mtime {
+                ancestor.Mtime = node.Mtime
+            }
+
+            if ancestor == root {
+                break
+            }
+
+            ancestor, found = pathNodes[parentRelPath(ancestor.RelPath)]
+            if found == false {
+                break
+            }
+        }
+    }
+
+    err = fileRows.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    // Attach the paths to their parents.
+
+    for childRelPath, node := range pathNodes {
+        if node == root {
+            continue
+        }
+
+        parent, found := pathNodes[parentRelPath(childRelPath)]
+        if found == false {
+            l.Warn("Path has no parent in the catalog.", "relPath", childRelPath)
+            continue
+        }
+
+        parent.Children = append(parent.Children, node)
+    }
+
+    for _, node := range pathNodes {
+        sort.Sort(catalogTreeNodesByName(node.Children))
+
+        if maxDepth > 0 && relativeDepth(*relPath, node.RelPath) >= maxDepth {
+            node.Children = node.Children[:0]
+        }
+    }
+
+    return root, nil
+}
@@ -0,0 +1,142 @@
+package pfinternal
+
+import (
+    "testing"
+    "os"
+    "path"
+    "time"
+    "fmt"
+    "strings"
+
+    "io/ioutil"
+)
+
+// Render the tree as one line per node, in order: the relative path, the
+// size, and whether it changed.
+func renderTree(node *CatalogTreeNode, lines []string) []string {
+    lines = append(lines, fmt.Sprintf("%s %d %v", node.RelPath, node.Size, node.Changed))
+
+    for _, child := range node.Children {
+        lines = renderTree(child, lines)
+    }
+
+    return lines
+}
+
+func TestGetTree(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    cr := scanChildrenTestTree(t, catalogFilepath, scanPath)
+    cr.Close()
+
+    // Change one file so that it, and the paths above it, are marked as
+    // changed by the next scan. Nothing else should be.
+    time.Sleep(time.Second * 1)
+
+    ioutil.WriteFile(path.Join(scanPath, "dir1", "dir1dir1", "ee"), []byte("changed"), 0644)
+
+    hashAlgorithm := HashAlgorithm
+
+    cr, _, err := scanIntoCatalog(&scanPath, &catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not rescan.")
+    }
+
+    defer cr.Close()
+
+    cases := []struct {
+        relPath string
+        maxDepth int
+        expected []string
+    } {
+        {
+            "", 0, []string {
+                " 12 true",
+                "aa 0 false",
+                "bb 0 false",
+                "dir1 12 true",
+                "dir1/cc 5 false",
+                "dir1/dd 0 false",
+                "dir1/dir1dir1 7 true",
+                "dir1/dir1dir1/ee 7 true",
+                "dir1/dir1dir1/ff 0 false",
+                "dir2 0 false",
+                "dir2/gg 0 false",
+                "dir2/hh 0 false",
+            },
+        },
+        // What's below the limit still counts toward the sizes.
+        {
+            "", 1, []string {
+                " 12 true",
+                "aa 0 false",
+                "bb 0 false",
+                "dir1 12 true",
+                "dir2 0 false",
+            },
+        },
+        {
+            "dir1/", 1, []string {
+                "dir1 12 true",
+                "dir1/cc 5 false",
+                "dir1/dd 0 false",
+                "dir1/dir1dir1 7 true",
+            },
+        },
+        {
+            "./dir2", 0, []string {
+                "dir2 0 false",
+                "dir2/gg 0 false",
+                "dir2/hh 0 false",
+            },
+        },
+    }
+
+    for i, c := range cases {
+        root, err := cr.GetTree(&c.relPath, c.maxDepth)
+        if err != nil {
+            t.Fatalf("Could not get tree for case (%d): %v", i, err)
+        }
+
+        actual := renderTree(root, make([]string, 0))
+        if strings.Join(actual, "\n") != strings.Join(c.expected, "\n") {
+            t.Fatalf("Tree not correct for case (%d):\n%s", i, strings.Join(actual, "\n"))
+        }
+    }
+
+    relPath := ""
+    root, err := cr.GetTree(&relPath, 0)
+    if err != nil {
+        t.Fatalf("Could not get tree.")
+    } else if root.Name != "." || root.IsPath == false {
+        t.Fatalf("Root not correct: %v", root)
+    }
+
+    // The mtime of a path is the latest of the files below it.
+    var latestMtime int64
+    for _, relFilepath := range []string { "aa", "dir1/cc", "dir1/dir1dir1/ee" } {
+        s, err := os.Stat(path.Join(scanPath, relFilepath))
+        if err != nil {
+            t.Fatalf("Could not stat [%s].", relFilepath)
+        }
+
+        if s.ModTime().Unix() > latestMtime {
+            latestMtime = s.ModTime().Unix()
+        }
+    }
+
+    if root.Mtime != latestMtime {
+        t.Fatalf("Root mtime not correct: (%d) != (%d)", root.Mtime, latestMtime)
+    }
+}
@@ -0,0 +1,126 @@
+package pfinternal
+
+import (
+    "testing"
+    "os"
+    "path"
+    "sort"
+    "time"
+    "bytes"
+    "strings"
+)
+
+func scanAndCollectChanges(t *testing.T, catalogFilepath string, scanPath string, allowUpdates bool) []string {
+    hashAlgorithm := HashAlgorithm
+    b := new(bytes.Buffer)
+
+    cr, err := NewCatalogResource(&catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not create catalog-resource.")
+    }
+
+    err = cr.Open()
+    if err != nil {
+        panic(err)
+    }
+
+    defer cr.Close()
+
+    rcs := NewReportChangeSink(b, false)
+
+    p := NewPath(&hashAlgorithm, rcs)
+
+    c, err := NewCatalog(cr, &scanPath, allowUpdates, &hashAlgorithm, rcs)
+    if err != nil {
+        t.Fatalf("Could not create new catalog.")
+    }
+
+    relPath := ""
+    _, err = p.GeneratePathHash(&scanPath, &relPath, c)
+    if err != nil {
+        t.Fatalf("Could not generate hash.")
+    }
+
+    err = c.Cleanup()
+    if err != nil {
+        t.Fatalf("Could not cleanup catalog.")
+    }
+
+    err = rcs.End()
+    if err != nil {
+        t.Fatalf("Could not end report.")
+    }
+
+    changes := strings.Split(strings.TrimSpace(b.String()), "\n")
+    sort.Strings(changes)
+
+    return changes
+}
+
+func TestNoUpdatesReportsDeletions(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    dir1Path := path.Join(scanPath, "dir1")
+    dir2Path := path.Join(scanPath, "dir2")
+
+    os.Mkdir(dir1Path, 0755)
+    os.Mkdir(dir2Path, 0755)
+
+    createSpecificFile(scanPath, "aa")
+    createSpecificFile(dir1Path, "bb")
+    createSpecificFile(dir1Path, "cc")
+    createSpecificFile(dir2Path, "dd")
+
+    scanAndCollectChanges(t, catalogFilepath, scanPath, true)
+
+    // Deletions are determined by check-timestamps, which have a resolution of
+    // one second.
+    time.Sleep(time.Second * 1)
+
+    os.RemoveAll(dir2Path)
+    os.Remove(path.Join(dir1Path, "cc"))
+    createSpecificFile(scanPath, "ee")
+
+    noUpdatesChanges := scanAndCollectChanges(t, catalogFilepath, scanPath, false)
+    updatesChanges := scanAndCollectChanges(t, catalogFilepath, scanPath, true)
+
+    expectedChanges := []string {
+        "create file ee",
+        "delete file dir1/cc",
+        "delete file dir2/dd",
+        "delete path dir2",
+        "update path .",
+        "update path dir1",
+    }
+
+    if len(updatesChanges) != len(expectedChanges) {
+        t.Fatalf("Update-mode changes not correct: %v", updatesChanges)
+    }
+
+    for i, change := range expectedChanges {
+        if updatesChanges[i] != change {
+            t.Fatalf("Update-mode changes not correct: %v", updatesChanges)
+        }
+    }
+
+    if len(noUpdatesChanges) != len(updatesChanges) {
+        t.Fatalf("No-updates changes do not match update-mode changes: %v != %v", noUpdatesChanges, updatesChanges)
+    }
+
+    for i, change := range updatesChanges {
+        if noUpdatesChanges[i] != change {
+            t.Fatalf("No-updates changes do not match update-mode changes: %v != %v", noUpdatesChanges, updatesChanges)
+        }
+    }
+}
@@ -3,6 +3,8 @@ package pfinternal
 import (
     "errors"
     "fmt"
+    "sort"
+    "strings"
 )
 
 const (
@@ -30,6 +32,12 @@ type ChangeEvent struct {
     EntityType int
     ChangeType int
     RelPath string
+
+    // The hash after the change and the one before it. Either is empty if it
+    // doesn't apply or isn't known (a path's hash isn't known when the path
+    // is created, so it gets an update event once it is).
+    Hash string
+    PreviousHash string
 }
 
 func UpdateTypeName(updateType int) string {
@@ -48,6 +56,17 @@ func UpdateTypeName(updateType int) string {
     }
 }
 
+// Return the update-type with the given name.
+func UpdateTypeFromName(name string) (int, error) {
+    for _, updateType := range []int { UpdateTypeCreate, UpdateTypeUpdate, UpdateTypeDelete } {
+        if UpdateTypeName(updateType) == name {
+            return updateType, nil
+        }
+    }
+
+    return 0, errors.New(fmt.Sprintf("Update-type name not valid: [%s]", name))
+}
+
 func PathStateName(pathState int) string {
     switch pathState {
     case PathStateNew:
@@ -76,3 +95,73 @@ func EntityTypeName(entityType int) string {
         panic(errors.New(fmt.Sprintf("Entity-type not valid: (%d)", entityType)))
     }
 }
+
+// Return the entity-type with the given name.
+func EntityTypeFromName(name string) (int, error) {
+    for _, entityType := range []int { EntityTypeFile, EntityTypePath } {
+        if EntityTypeName(entityType) == name {
+            return entityType, nil
+        }
+    }
+
+    return 0, errors.New(fmt.Sprintf("Entity-type name not valid: [%s]", name))
+}
+
+// Orders change events by path. Paths are compared component by component so 
+// that a directory's events come before the events for its children, and 
+// path events come before file events for the same name.
+type changeEventsByPath []*ChangeEvent
+
+func (self changeEventsByPath) Len() int {
+    return len(self)
+}
+
+func (self changeEventsByPath) Swap(i, j int) {
+    self[i], self[j] = self[j], self[i]
+}
+
+func (self changeEventsByPath) Less(i, j int) bool {
+    a := self[i]
+    b := self[j]
+
+    if a.RelPath != b.RelPath {
+        return compareRelPaths(a.RelPath, b.RelPath) < 0
+    }
+
+    if a.EntityType != b.EntityType {
+        return a.EntityType == EntityTypePath
+    }
+
+    return a.ChangeType < b.ChangeType
+}
+
+func splitRelPath(relPath string) []string {
+    if relPath == "" {
+        return []string {}
+    }
+
+    return strings.Split(relPath, "/")
+}
+
+// Compare two relative paths component by component. A path always sorts 
+// before its descendants.
+func compareRelPaths(a, b string) int {
+    aParts := splitRelPath(a)
+    bParts := splitRelPath(b)
+
+    for i := 0; i < len(aParts) && i < len(bParts); i++ {
+        if aParts[i] < bParts[i] {
+            return -1
+        } else if aParts[i] > bParts[i] {
+            return 1
+        }
+    }
+
+    return len(aParts) - len(bParts)
+}
+
+// Sort the given events into a deterministic, tree-like order. Deletions are 
+// placed alongside everything else rather than at the end.
+func SortChangeEvents(events []*ChangeEvent) {
+    sort.Stable(changeEventsByPath(events))
+}
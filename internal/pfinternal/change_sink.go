@@ -0,0 +1,155 @@
+package pfinternal
+
+import (
+    "io"
+)
+
+// Receives the changes detected during a scan. Begin() is called before the
+// scan starts, Event() is called (synchronously) for every change, and then
+// either End() is called once the scan and the catalog cleanup have finished
+// or Error() is called if the scan failed. A sink must have finished with
+// every event by the time End() returns.
+type ChangeSink interface {
+    Begin() error
+    Event(ce *ChangeEvent) error
+    End() error
+    Error(err error)
+}
+
+// Forwards everything to several sinks at once.
+type FanoutChangeSink struct {
+    sinks []ChangeSink
+}
+
+func NewFanoutChangeSink(sinks ...ChangeSink) *FanoutChangeSink {
+    fcs := FanoutChangeSink {
+            sinks: sinks,
+    }
+
+    return &fcs
+}
+
+// Begin all sinks. We stop at the first failure.
+func (self *FanoutChangeSink) Begin() error {
+    for _, cs := range self.sinks {
+        err := cs.Begin()
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// Forward the event to every sink. Every sink will see the event even if an
+// earlier one fails, and the first failure is returned.
+func (self *FanoutChangeSink) Event(ce *ChangeEvent) error {
+    var firstErr error
+
+    for _, cs := range self.sinks {
+        err := cs.Event(ce)
+        if err != nil && firstErr == nil {
+            firstErr = err
+        }
+    }
+
+    return firstErr
+}
+
+// End every sink. Every sink will be ended even if an earlier one fails, and
+// the first failure is returned.
+func (self *FanoutChangeSink) End() error {
+    var firstErr error
+
+    for _, cs := range self.sinks {
+        err := cs.End()
+        if err != nil && firstErr == nil {
+            firstErr = err
+        }
+    }
+
+    return firstErr
+}
+
+func (self *FanoutChangeSink) Error(err error) {
+    for _, cs := range self.sinks {
+        cs.Error(err)
+    }
+}
+
+// Writes a line-oriented report of changes (e.g. "create file subdir1/aa").
+// If sorting was requested, the events are buffered and written in path order
+// when the sink is ended.
+type ReportChangeSink struct {
+    w io.Writer
+    sortReport bool
+    buffered []*ChangeEvent
+}
+
+func NewReportChangeSink(w io.Writer, sortReport bool) *ReportChangeSink {
+    rcs := ReportChangeSink {
+            w: w,
+            sortReport: sortReport,
+            buffered: make([]*ChangeEvent, 0),
+    }
+
+    return &rcs
+}
+
+func (self *ReportChangeSink) Begin() error {
+    return nil
+}
+
+func (self *ReportChangeSink) Event(ce *ChangeEvent) error {
+    l := NewLogger("report_change_sink")
+
+    l.Debug("Catalog change.",
+        "EntityType", EntityTypeName(ce.EntityType),
+        "ChangeType", UpdateTypeName(ce.ChangeType),
+        "RelPath", ce.RelPath)
+
+    if self.sortReport == true {
+        self.buffered = append(self.buffered, ce)
+        return nil
+    }
+
+    return self.writeEvent(ce)
+}
+
+func (self *ReportChangeSink) End() error {
+    if self.sortReport == false {
+        return nil
+    }
+
+    SortChangeEvents(self.buffered)
+
+    for _, ce := range self.buffered {
+        err := self.writeEvent(ce)
+        if err != nil {
+            return err
+        }
+    }
+
+    self.buffered = self.buffered[:0]
+
+    return nil
+}
+
+func (self *ReportChangeSink) Error(err error) {
+    // Whatever we've buffered can't be trusted.
+    self.buffered = self.buffered[:0]
+}
+
+func (self *ReportChangeSink) writeEvent(ce *ChangeEvent) error {
+    var effectiveRelPath string
+    if ce.EntityType == EntityTypePath && ce.RelPath == "" {
+        effectiveRelPath = "."
+    } else {
+        effectiveRelPath = ce.RelPath
+    }
+
+    line := UpdateTypeName(ce.ChangeType) + " " + EntityTypeName(ce.EntityType) + " " + effectiveRelPath + "\n"
+
+    _, err := io.WriteString(self.w, line)
+    return err
+}
@@ -0,0 +1,52 @@
+package pfinternal
+
+import (
+    "testing"
+    "bytes"
+)
+
+func TestFanoutChangeSink(t *testing.T) {
+    unsorted := new(bytes.Buffer)
+    sorted := new(bytes.Buffer)
+
+    fcs := NewFanoutChangeSink(
+            NewReportChangeSink(unsorted, false),
+            NewReportChangeSink(sorted, true))
+
+    err := fcs.Begin()
+    if err != nil {
+        t.Fatalf("Could not begin sinks.")
+    }
+
+    events := []*ChangeEvent {
+        &ChangeEvent { EntityType: EntityTypeFile, ChangeType: UpdateTypeCreate, RelPath: "subdir1/aa" },
+        &ChangeEvent { EntityType: EntityTypePath, ChangeType: UpdateTypeUpdate, RelPath: "" },
+        &ChangeEvent { EntityType: EntityTypePath, ChangeType: UpdateTypeCreate, RelPath: "subdir1" },
+    }
+
+    for _, ce := range events {
+        err := fcs.Event(ce)
+        if err != nil {
+            t.Fatalf("Could not send event.")
+        }
+    }
+
+    if sorted.Len() != 0 {
+        t.Fatalf("Sorted report was written before the sink was ended.")
+    }
+
+    err = fcs.End()
+    if err != nil {
+        t.Fatalf("Could not end sinks.")
+    }
+
+    expectedUnsorted := "create file subdir1/aa\nupdate path .\ncreate path subdir1\n"
+    if unsorted.String() != expectedUnsorted {
+        t.Fatalf("Unsorted report not correct: [%s]", unsorted.String())
+    }
+
+    expectedSorted := "update path .\ncreate path subdir1\ncreate file subdir1/aa\n"
+    if sorted.String() != expectedSorted {
+        t.Fatalf("Sorted report not correct: [%s]", sorted.String())
+    }
+}
@@ -0,0 +1,38 @@
+package pfinternal
+
+import (
+    "testing"
+)
+
+func TestSortChangeEvents(t *testing.T) {
+    events := []*ChangeEvent {
+        &ChangeEvent { EntityType: EntityTypePath, ChangeType: UpdateTypeUpdate, RelPath: "" },
+        &ChangeEvent { EntityType: EntityTypeFile, ChangeType: UpdateTypeDelete, RelPath: "dir1/aa" },
+        &ChangeEvent { EntityType: EntityTypeFile, ChangeType: UpdateTypeCreate, RelPath: "dir1-x" },
+        &ChangeEvent { EntityType: EntityTypePath, ChangeType: UpdateTypeUpdate, RelPath: "dir1" },
+        &ChangeEvent { EntityType: EntityTypeFile, ChangeType: UpdateTypeCreate, RelPath: "dir1/dir2/bb" },
+        &ChangeEvent { EntityType: EntityTypePath, ChangeType: UpdateTypeCreate, RelPath: "dir1/dir2" },
+        &ChangeEvent { EntityType: EntityTypePath, ChangeType: UpdateTypeCreate, RelPath: "dir1" },
+        &ChangeEvent { EntityType: EntityTypeFile, ChangeType: UpdateTypeDelete, RelPath: "cc" },
+    }
+
+    SortChangeEvents(events)
+
+    expected := []string {
+        "update path ",
+        "delete file cc",
+        "create path dir1",
+        "update path dir1",
+        "delete file dir1/aa",
+        "create path dir1/dir2",
+        "create file dir1/dir2/bb",
+        "create file dir1-x",
+    }
+
+    for i, ce := range events {
+        actual := UpdateTypeName(ce.ChangeType) + " " + EntityTypeName(ce.EntityType) + " " + ce.RelPath
+        if actual != expected[i] {
+            t.Fatalf("Event (%d) not sorted correctly: ACT [%s] != EXP [%s]", i, actual, expected[i])
+        }
+    }
+}
@@ -0,0 +1,134 @@
+package pfinternal
+
+// Update the catalog for the given path and return the root hash.
+func scanIntoCatalog(scanPath *string, catalogFilepath *string, hashAlgorithm *string) (cr *catalogResource, hash string, err error) {
+    l := NewLogger("compare")
+
+    defer func() {
+        if r := recover(); r != nil {
+            if cr != nil {
+                cr.Close()
+            }
+
+            cr = nil
+            hash = ""
+            err = r.(error)
+
+            l.Error("Could not scan into catalog", "scanPath", *scanPath, "err", err)
+        }
+    }()
+
+    cr, err = NewCatalogResource(catalogFilepath, hashAlgorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    err = cr.Open()
+    if err != nil {
+        cr = nil
+        panic(err)
+    }
+
+    // Record the scan if the catalog keeps a history.
+    var cs ChangeSink
+
+    isHistoryEnabled, err := cr.HistoryEnabled()
+    if err != nil {
+        panic(err)
+    } else if isHistoryEnabled == true {
+        cs = cr.NewHistoryChangeSink()
+
+        err = cs.Begin()
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    fail := func(err error) {
+        if cs != nil {
+            cs.Error(err)
+        }
+
+        panic(err)
+    }
+
+    c, err := NewCatalog(cr, scanPath, true, hashAlgorithm, cs)
+    if err != nil {
+        fail(err)
+    }
+
+    err = c.Open()
+    if err != nil {
+        fail(err)
+    }
+
+    defer c.Close()
+
+    p := NewPath(hashAlgorithm, cs)
+
+    relPath := ""
+    hash, err = p.GeneratePathHash(scanPath, &relPath, c)
+    if err != nil {
+        fail(err)
+    }
+
+    err = c.Cleanup()
+    if err != nil {
+        fail(err)
+    }
+
+    if cs != nil {
+        err = cs.End()
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    return cr, hash, nil
+}
+
+// Compare two directory trees. Each one is scanned into its own catalog
+// (which will be created if it doesn't exist and makes subsequent comparisons
+// much faster) and, if the root hashes differ, the catalogs are diffed. The
+// events describe how to get from the first tree to the second. Returns the
+// two root hashes and the number of events.
+func CompareTrees(scanPathA *string, catalogFilepathA *string, scanPathB *string, catalogFilepathB *string, hashAlgorithm *string, cs ChangeSink) (hashA string, hashB string, count int, err error) {
+    l := NewLogger("compare")
+
+    defer func() {
+        if r := recover(); r != nil {
+            hashA = ""
+            hashB = ""
+            count = 0
+            err = r.(error)
+
+            l.Error("Could not compare trees", "err", err)
+        }
+    }()
+
+    crA, hashA, err := scanIntoCatalog(scanPathA, catalogFilepathA, hashAlgorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    defer crA.Close()
+
+    crB, hashB, err := scanIntoCatalog(scanPathB, catalogFilepathB, hashAlgorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    defer crB.Close()
+
+    if hashA == hashB {
+        return hashA, hashB, 0, nil
+    }
+
+    relPath := ""
+    count, err = DiffCatalogs(crA, crB, &relPath, cs)
+    if err != nil {
+        panic(err)
+    }
+
+    return hashA, hashB, count, nil
+}
@@ -0,0 +1,64 @@
+package pfinternal
+
+import (
+    "testing"
+    "os"
+    "path"
+    "bytes"
+)
+
+func TestCompareTrees(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepathA := createTempFile(tempPath)
+    catalogFilepathB := createTempFile(tempPath)
+    scanPathA := createTempPath(tempPath, "scan")
+    scanPathB := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPathA)
+        os.RemoveAll(scanPathB)
+        os.Remove(catalogFilepathA)
+        os.Remove(catalogFilepathB)
+    }
+
+    defer cleanup()
+
+    for _, scanPath := range []string { scanPathA, scanPathB } {
+        dir1Path := path.Join(scanPath, "dir1")
+        os.Mkdir(dir1Path, 0755)
+
+        createSpecificFile(scanPath, "aa")
+        createSpecificFile(dir1Path, "bb")
+    }
+
+    hashAlgorithm := HashAlgorithm
+    b := new(bytes.Buffer)
+
+    hashA, hashB, count, err := CompareTrees(&scanPathA, &catalogFilepathA, &scanPathB, &catalogFilepathB, &hashAlgorithm, NewReportChangeSink(b, true))
+    if err != nil {
+        t.Fatalf("Could not compare identical trees.")
+    } else if hashA != hashB || count != 0 || b.Len() != 0 {
+        t.Fatalf("Identical trees compared as different: [%s] [%s] (%d)", hashA, hashB, count)
+    }
+
+    createSpecificFile(path.Join(scanPathB, "dir1"), "cc")
+
+    rcs := NewReportChangeSink(b, true)
+
+    hashA, hashB, count, err = CompareTrees(&scanPathA, &catalogFilepathA, &scanPathB, &catalogFilepathB, &hashAlgorithm, rcs)
+    if err != nil {
+        t.Fatalf("Could not compare different trees.")
+    }
+
+    err = rcs.End()
+    if err != nil {
+        t.Fatalf("Could not end report.")
+    }
+
+    expected := "update path .\nupdate path dir1\ncreate file dir1/cc\n"
+    if hashA == hashB || count != 3 || b.String() != expected {
+        t.Fatalf("Differences not correct:\n%s", b.String())
+    }
+}
@@ -0,0 +1,231 @@
+package pfinternal
+
+import (
+    "io"
+    "fmt"
+    "path"
+    "bufio"
+    "errors"
+    "regexp"
+    "strings"
+
+    "encoding/json"
+)
+
+const (
+    ManifestFormatGnu = "gnu"
+    ManifestFormatBsd = "bsd"
+    ManifestFormatJson = "json"
+
+    // We can write this but not read it.
+    ManifestFormatCsv = "csv"
+)
+
+const (
+    ManifestEntryTypeFile = "file"
+    ManifestEntryTypePath = "path"
+)
+
+// A file or path in a manifest. Sizes and mtimes are only available in JSON
+// manifests.
+type ManifestEntry struct {
+    Type string             `json:"type"`
+    RelPath string          `json:"rel_path"`
+    Hash string             `json:"hash"`
+    Size *int64             `json:"size,omitempty"`
+    Mtime *int64            `json:"mtime,omitempty"`
+}
+
+// A list of hashes. The algorithm and root hash are only available in JSON
+// manifests (the algorithm is otherwise inferred from the hashes).
+type Manifest struct {
+    Format string           `json:"-"`
+    Algorithm string        `json:"algorithm"`
+    RootHash string         `json:"root_hash"`
+    Entries []*ManifestEntry `json:"entries"`
+}
+
+var (
+    bsdManifestLineRx = regexp.MustCompile(`^\\?([A-Za-z0-9]+) \((.*)\) = ([0-9A-Fa-f]+)$`)
+    gnuManifestLineRx = regexp.MustCompile(`^\\?([0-9A-Fa-f]+) [ *](.*)$`)
+)
+
+var gnuFilenameUnescaper = strings.NewReplacer("\\\\", "\\", "\\n", "\n", "\\r", "\r")
+
+// Return the algorithm that produces hashes of the given length.
+func algorithmForHash(hash string) (string, error) {
+    switch len(hash) {
+    case 40:
+        return Sha1Algorithm, nil
+
+    case 64:
+        return Sha256Algorithm, nil
+
+    default:
+        return "", errors.New(fmt.Sprintf("Hash length not recognized: [%s]", hash))
+    }
+}
+
+// Make manifest paths look like catalog paths ("./a/b" becomes "a/b" and the
+// root is empty).
+func normalizeManifestRelPath(relPath string) (string, error) {
+    if path.IsAbs(relPath) == true {
+        return "", errors.New(fmt.Sprintf("Manifest paths must be relative: [%s]", relPath))
+    }
+
+    relPath = path.Clean(relPath)
+    if relPath == "." {
+        return "", nil
+    } else if relPath == ".." || strings.HasPrefix(relPath, "../") == true {
+        return "", errors.New(fmt.Sprintf("Manifest path is outside of the tree: [%s]", relPath))
+    }
+
+    return relPath, nil
+}
+
+// Read a manifest in the format written by sha1sum/sha256sum, the BSD-style
+// "tag" format, or our own JSON format. The format is detected automatically.
+func ReadManifest(r io.Reader) (m *Manifest, err error) {
+    l := NewLogger("manifest")
+
+    defer func() {
+        if r := recover(); r != nil {
+            m = nil
+            err = r.(error)
+
+            l.Error("Could not read manifest", "err", err)
+        }
+    }()
+
+    br := bufio.NewReader(r)
+
+    // Skip whitespace to find out if this is JSON.
+    for {
+        b, err := br.Peek(1)
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            panic(err)
+        } else if strings.ContainsAny(string(b), " \t\r\n") == false {
+            break
+        }
+
+        br.ReadByte()
+    }
+
+    if b, err := br.Peek(1); err == nil && b[0] == '{' {
+        m = new(Manifest)
+
+        err = json.NewDecoder(br).Decode(m)
+        if err != nil {
+            panic(err)
+        }
+
+        m.Format = ManifestFormatJson
+
+        for _, me := range m.Entries {
+            if me.Type != ManifestEntryTypeFile && me.Type != ManifestEntryTypePath {
+                panic(errors.New(fmt.Sprintf("Manifest entry type not valid: [%s]", me.Type)))
+            }
+
+            me.RelPath, err = normalizeManifestRelPath(me.RelPath)
+            if err != nil {
+                panic(err)
+            }
+
+            me.Hash = strings.ToLower(me.Hash)
+        }
+
+        m.RootHash = strings.ToLower(m.RootHash)
+
+        if m.Algorithm == "" {
+            panic(errors.New("Manifest doesn't have an algorithm."))
+        }
+
+        return m, nil
+    }
+
+    m = &Manifest {
+            Entries: make([]*ManifestEntry, 0),
+    }
+
+    s := bufio.NewScanner(br)
+    lineNumber := 0
+
+    for s.Scan() {
+        lineNumber++
+
+        line := strings.TrimRight(s.Text(), "\r")
+        if line == "" {
+            continue
+        }
+
+        var algorithm string
+        var hash string
+        var relPath string
+        var format string
+
+        if matches := bsdManifestLineRx.FindStringSubmatch(line); matches != nil {
+            format = ManifestFormatBsd
+            algorithm = strings.ToLower(matches[1])
+            relPath = matches[2]
+            hash = matches[3]
+
+            // The algorithm is given, so the hash has to fit it.
+            h, err := getHashObject(&algorithm)
+            if err != nil {
+                panic(err)
+            } else if len(hash) != h.Size() * 2 {
+                panic(errors.New(fmt.Sprintf("Manifest line (%d) has a hash that doesn't fit [%s].", lineNumber, algorithm)))
+            }
+        } else if matches := gnuManifestLineRx.FindStringSubmatch(line); matches != nil {
+            format = ManifestFormatGnu
+            hash = matches[1]
+            relPath = matches[2]
+
+            algorithm, err = algorithmForHash(hash)
+            if err != nil {
+                panic(err)
+            }
+        } else {
+            panic(errors.New(fmt.Sprintf("Manifest line (%d) not valid.", lineNumber)))
+        }
+
+        // Names with backslashes or newlines are escaped and the line is
+        // prefixed with a backslash.
+        if line[0] == '\\' {
+            relPath = gnuFilenameUnescaper.Replace(relPath)
+        }
+
+        if m.Format == "" {
+            m.Format = format
+            m.Algorithm = algorithm
+        } else if format != m.Format || algorithm != m.Algorithm {
+            panic(errors.New(fmt.Sprintf("Manifest line (%d) doesn't match the lines before it.", lineNumber)))
+        }
+
+        relPath, err = normalizeManifestRelPath(relPath)
+        if err != nil {
+            panic(err)
+        }
+
+        me := &ManifestEntry {
+                Type: ManifestEntryTypeFile,
+                RelPath: relPath,
+                Hash: strings.ToLower(hash),
+        }
+
+        m.Entries = append(m.Entries, me)
+    }
+
+    err = s.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    if m.Format == "" {
+        panic(errors.New("Manifest is empty."))
+    }
+
+    return m, nil
+}
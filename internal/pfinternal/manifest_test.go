@@ -0,0 +1,91 @@
+package pfinternal
+
+import (
+    "testing"
+    "strings"
+)
+
+func TestReadGnuManifest(t *testing.T) {
+    ConfigureRootLogger()
+
+    raw := "da39a3ee5e6b4b0d3255bfef95601890afd80709  ./aa\n" +
+           "4E1243BD22C66E76C2BA9EDDC1F91394E57F9F83 *dir1/cc\n" +
+           "\\da39a3ee5e6b4b0d3255bfef95601890afd80709  dir1/back\\\\slash\n"
+
+    m, err := ReadManifest(strings.NewReader(raw))
+    if err != nil {
+        t.Fatalf("Could not read manifest.")
+    } else if m.Format != ManifestFormatGnu || m.Algorithm != Sha1Algorithm {
+        t.Fatalf("Manifest format not detected correctly: [%s] [%s]", m.Format, m.Algorithm)
+    } else if len(m.Entries) != 3 {
+        t.Fatalf("Manifest entries not read correctly: %v", m.Entries)
+    }
+
+    if m.Entries[0].RelPath != "aa" {
+        t.Fatalf("Relative path not normalized: [%s]", m.Entries[0].RelPath)
+    } else if m.Entries[1].RelPath != "dir1/cc" || m.Entries[1].Hash != "4e1243bd22c66e76c2ba9eddc1f91394e57f9f83" {
+        t.Fatalf("Binary-mode entry not read correctly: %v", m.Entries[1])
+    } else if m.Entries[2].RelPath != "dir1/back\\slash" {
+        t.Fatalf("Escaped entry not read correctly: [%s]", m.Entries[2].RelPath)
+    }
+}
+
+func TestReadBsdManifest(t *testing.T) {
+    ConfigureRootLogger()
+
+    raw := "SHA256 (aa) = e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\n" +
+           "SHA256 (dir1/c c) = e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\n"
+
+    m, err := ReadManifest(strings.NewReader(raw))
+    if err != nil {
+        t.Fatalf("Could not read manifest.")
+    } else if m.Format != ManifestFormatBsd || m.Algorithm != Sha256Algorithm {
+        t.Fatalf("Manifest format not detected correctly: [%s] [%s]", m.Format, m.Algorithm)
+    } else if len(m.Entries) != 2 || m.Entries[1].RelPath != "dir1/c c" {
+        t.Fatalf("Manifest entries not read correctly: %v", m.Entries)
+    }
+}
+
+func TestReadJsonManifest(t *testing.T) {
+    ConfigureRootLogger()
+
+    raw := `
+{
+  "algorithm": "sha1",
+  "root_hash": "7DE9894AA603D20DAE695E2A9BCCF02D465979CB",
+  "entries": [
+    { "type": "path", "rel_path": "", "hash": "7de9894aa603d20dae695e2a9bccf02d465979cb" },
+    { "type": "file", "rel_path": "aa", "hash": "da39a3ee5e6b4b0d3255bfef95601890afd80709", "size": 0 }
+  ]
+}`
+
+    m, err := ReadManifest(strings.NewReader(raw))
+    if err != nil {
+        t.Fatalf("Could not read manifest.")
+    } else if m.Format != ManifestFormatJson || m.RootHash != "7de9894aa603d20dae695e2a9bccf02d465979cb" {
+        t.Fatalf("Manifest not read correctly: [%s] [%s]", m.Format, m.RootHash)
+    } else if len(m.Entries) != 2 || m.Entries[0].Type != ManifestEntryTypePath || *m.Entries[1].Size != 0 {
+        t.Fatalf("Manifest entries not read correctly: %v", m.Entries)
+    }
+}
+
+func TestReadInvalidManifest(t *testing.T) {
+    ConfigureRootLogger()
+
+    invalid := []string {
+        "",
+        "not a manifest\n",
+        "da39a3ee5e6b4b0d3255bfef95601890afd80709  ../aa\n",
+        "da39a3ee5e6b4b0d3255bfef95601890afd80709  aa\nSHA1 (bb) = da39a3ee5e6b4b0d3255bfef95601890afd80709\n",
+        "SHA256 (aa) = da39a3ee5e6b4b0d3255bfef95601890afd80709\n",
+        "SHA1 (aa) = e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\n",
+        "MD5 (aa) = d41d8cd98f00b204e9800998ecf8427e\n",
+    }
+
+    for _, raw := range invalid {
+        _, err := ReadManifest(strings.NewReader(raw))
+        if err == nil {
+            t.Fatalf("Expected error for invalid manifest: [%s]", raw)
+        }
+    }
+}
@@ -0,0 +1,187 @@
+package pfinternal
+
+import (
+    "io"
+    "fmt"
+    "errors"
+    "strconv"
+    "strings"
+
+    "encoding/csv"
+    "encoding/json"
+)
+
+// Writes manifest entries as they're produced, so that the whole manifest
+// never has to be in memory.
+type ManifestWriter interface {
+    Begin(algorithm string, rootHash string) error
+    Write(me *ManifestEntry) error
+    End() error
+
+    // Whether the format has entries for paths.
+    IncludesPaths() bool
+}
+
+func NewManifestWriter(w io.Writer, format string) (ManifestWriter, error) {
+    switch format {
+    case ManifestFormatGnu:
+        return newChecksumManifestWriter(w, false), nil
+
+    case ManifestFormatBsd:
+        return newChecksumManifestWriter(w, true), nil
+
+    case ManifestFormatCsv:
+        return newCsvManifestWriter(w), nil
+
+    case ManifestFormatJson:
+        return newJsonManifestWriter(w), nil
+
+    default:
+        return nil, errors.New(fmt.Sprintf("Manifest format not valid: [%s]", format))
+    }
+}
+
+var gnuFilenameEscaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r")
+
+// Writes the format used by sha1sum/sha256sum, or their BSD-style "tag"
+// format.
+type checksumManifestWriter struct {
+    w io.Writer
+    isTagged bool
+    tag string
+}
+
+func newChecksumManifestWriter(w io.Writer, isTagged bool) *checksumManifestWriter {
+    cmw := checksumManifestWriter {
+            w: w,
+            isTagged: isTagged,
+    }
+
+    return &cmw
+}
+
+func (self *checksumManifestWriter) Begin(algorithm string, rootHash string) error {
+    self.tag = strings.ToUpper(algorithm)
+    return nil
+}
+
+func (self *checksumManifestWriter) Write(me *ManifestEntry) error {
+    // Like the coreutils tools, names with backslashes or newlines are escaped
+    // and the line is prefixed with a backslash.
+    var prefix string
+    relPath := me.RelPath
+
+    if strings.ContainsAny(relPath, "\\\n\r") == true {
+        prefix = "\\"
+        relPath = gnuFilenameEscaper.Replace(relPath)
+    }
+
+    var err error
+    if self.isTagged == true {
+        _, err = fmt.Fprintf(self.w, "%s%s (%s) = %s\n", prefix, self.tag, relPath, me.Hash)
+    } else {
+        _, err = fmt.Fprintf(self.w, "%s%s  %s\n", prefix, me.Hash, relPath)
+    }
+
+    return err
+}
+
+func (self *checksumManifestWriter) End() error {
+    return nil
+}
+
+func (self *checksumManifestWriter) IncludesPaths() bool {
+    return false
+}
+
+type csvManifestWriter struct {
+    cw *csv.Writer
+}
+
+func newCsvManifestWriter(w io.Writer) *csvManifestWriter {
+    cmw := csvManifestWriter {
+            cw: csv.NewWriter(w),
+    }
+
+    return &cmw
+}
+
+func (self *csvManifestWriter) Begin(algorithm string, rootHash string) error {
+    return self.cw.Write([]string { "rel_path", "hash", "size", "mtime" })
+}
+
+func (self *csvManifestWriter) Write(me *ManifestEntry) error {
+    optional := func(value *int64) string {
+        if value == nil {
+            return ""
+        }
+
+        return strconv.FormatInt(*value, 10)
+    }
+
+    return self.cw.Write([]string { me.RelPath, me.Hash, optional(me.Size), optional(me.Mtime) })
+}
+
+func (self *csvManifestWriter) End() error {
+    self.cw.Flush()
+    return self.cw.Error()
+}
+
+func (self *csvManifestWriter) IncludesPaths() bool {
+    return false
+}
+
+// Writes the same structure as Manifest, one entry at a time.
+type jsonManifestWriter struct {
+    w io.Writer
+    count int
+}
+
+func newJsonManifestWriter(w io.Writer) *jsonManifestWriter {
+    jmw := jsonManifestWriter {
+            w: w,
+    }
+
+    return &jmw
+}
+
+func (self *jsonManifestWriter) Begin(algorithm string, rootHash string) error {
+    encodedAlgorithm, err := json.Marshal(algorithm)
+    if err != nil {
+        return err
+    }
+
+    encodedRootHash, err := json.Marshal(rootHash)
+    if err != nil {
+        return err
+    }
+
+    _, err = fmt.Fprintf(self.w, "{\n  \"algorithm\": %s,\n  \"root_hash\": %s,\n  \"entries\": [", encodedAlgorithm, encodedRootHash)
+    return err
+}
+
+func (self *jsonManifestWriter) Write(me *ManifestEntry) error {
+    encoded, err := json.Marshal(me)
+    if err != nil {
+        return err
+    }
+
+    separator := ","
+    if self.count == 0 {
+        separator = ""
+    }
+
+    self.count++
+
+    _, err = fmt.Fprintf(self.w, "%s\n    %s", separator, encoded)
+    return err
+}
+
+func (self *jsonManifestWriter) End() error {
+    _, err := io.WriteString(self.w, "\n  ]\n}\n")
+    return err
+}
+
+func (self *jsonManifestWriter) IncludesPaths() bool {
+    return true
+}
@@ -0,0 +1,210 @@
+package pfinternal
+
+import (
+    "testing"
+    "os"
+    "path"
+    "bytes"
+)
+
+func writeTestManifest(t *testing.T, format string, entries []*ManifestEntry) string {
+    b := new(bytes.Buffer)
+
+    mw, err := NewManifestWriter(b, format)
+    if err != nil {
+        t.Fatalf("Could not create manifest writer.")
+    }
+
+    err = mw.Begin(Sha1Algorithm, "7de9894aa603d20dae695e2a9bccf02d465979cb")
+    if err != nil {
+        t.Fatalf("Could not begin manifest.")
+    }
+
+    for _, me := range entries {
+        if me.Type == ManifestEntryTypePath && mw.IncludesPaths() == false {
+            continue
+        }
+
+        err = mw.Write(me)
+        if err != nil {
+            t.Fatalf("Could not write manifest entry.")
+        }
+    }
+
+    err = mw.End()
+    if err != nil {
+        t.Fatalf("Could not end manifest.")
+    }
+
+    return b.String()
+}
+
+func TestManifestWriterRoundTrip(t *testing.T) {
+    ConfigureRootLogger()
+
+    size := int64(0)
+
+    entries := []*ManifestEntry {
+        &ManifestEntry { Type: ManifestEntryTypePath, RelPath: "", Hash: "7de9894aa603d20dae695e2a9bccf02d465979cb" },
+        &ManifestEntry { Type: ManifestEntryTypeFile, RelPath: "aa", Hash: "da39a3ee5e6b4b0d3255bfef95601890afd80709", Size: &size },
+        &ManifestEntry { Type: ManifestEntryTypeFile, RelPath: "back\\slash", Hash: "da39a3ee5e6b4b0d3255bfef95601890afd80709" },
+        &ManifestEntry { Type: ManifestEntryTypeFile, RelPath: "new\nline", Hash: "da39a3ee5e6b4b0d3255bfef95601890afd80709" },
+        &ManifestEntry { Type: ManifestEntryTypeFile, RelPath: "paren (x) = y", Hash: "da39a3ee5e6b4b0d3255bfef95601890afd80709" },
+    }
+
+    for _, format := range []string { ManifestFormatGnu, ManifestFormatBsd, ManifestFormatJson } {
+        raw := writeTestManifest(t, format, entries)
+
+        m, err := ReadManifest(bytes.NewBufferString(raw))
+        if err != nil {
+            t.Fatalf("Could not read [%s] manifest:\n%s", format, raw)
+        } else if m.Format != format || m.Algorithm != Sha1Algorithm {
+            t.Fatalf("Manifest format not correct: [%s] [%s]", m.Format, m.Algorithm)
+        }
+
+        expected := entries
+        if format != ManifestFormatJson {
+            expected = entries[1:]
+        }
+
+        if len(m.Entries) != len(expected) {
+            t.Fatalf("Wrong number of entries in [%s] manifest:\n%s", format, raw)
+        }
+
+        for i, me := range m.Entries {
+            if me.Type != expected[i].Type || me.RelPath != expected[i].RelPath || me.Hash != expected[i].Hash {
+                t.Fatalf("Entry (%d) in [%s] manifest not correct: [%s]", i, format, me.RelPath)
+            }
+        }
+    }
+}
+
+func TestCsvManifestWriter(t *testing.T) {
+    size := int64(5)
+
+    entries := []*ManifestEntry {
+        &ManifestEntry { Type: ManifestEntryTypeFile, RelPath: "a,b", Hash: "da39a3ee5e6b4b0d3255bfef95601890afd80709", Size: &size },
+        &ManifestEntry { Type: ManifestEntryTypeFile, RelPath: "\"q\"", Hash: "da39a3ee5e6b4b0d3255bfef95601890afd80709" },
+    }
+
+    raw := writeTestManifest(t, ManifestFormatCsv, entries)
+
+    expected := "rel_path,hash,size,mtime\n" +
+                "\"a,b\",da39a3ee5e6b4b0d3255bfef95601890afd80709,5,\n" +
+                "\"\"\"q\"\"\",da39a3ee5e6b4b0d3255bfef95601890afd80709,,\n"
+
+    if raw != expected {
+        t.Fatalf("CSV not correct:\n%s", raw)
+    }
+}
+
+func TestExportManifest(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    dir1Path := path.Join(scanPath, "dir1")
+    subPath := path.Join(dir1Path, "sub")
+
+    os.MkdirAll(subPath, 0755)
+
+    createSpecificFile(scanPath, "aa")
+    createSpecificFile(dir1Path, "bb")
+    createSpecificFile(subPath, "cc")
+
+    scanAndCollectChanges(t, catalogFilepath, scanPath, true)
+
+    hashAlgorithm := HashAlgorithm
+
+    cr, err := NewCatalogResource(&catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not create catalog-resource.")
+    }
+
+    err = cr.Open()
+    if err != nil {
+        t.Fatalf("Could not open catalog.")
+    }
+
+    defer cr.Close()
+
+    b := new(bytes.Buffer)
+
+    mw, err := NewManifestWriter(b, ManifestFormatJson)
+    if err != nil {
+        t.Fatalf("Could not create manifest writer.")
+    }
+
+    relPath := "dir1"
+    err = cr.ExportManifest(&relPath, mw)
+    if err != nil {
+        t.Fatalf("Could not export manifest.")
+    }
+
+    m, err := ReadManifest(b)
+    if err != nil {
+        t.Fatalf("Could not read exported manifest.")
+    }
+
+    rr, err := cr.ResolvePath(&relPath)
+    if err != nil {
+        t.Fatalf("Could not resolve path.")
+    }
+
+    if m.RootHash != rr.Hash {
+        t.Fatalf("Root hash not the hash of the exported path: [%s] != [%s]", m.RootHash, rr.Hash)
+    }
+
+    relPaths := make([]string, len(m.Entries))
+    for i, me := range m.Entries {
+        relPaths[i] = me.Type + ":" + me.RelPath
+    }
+
+    expected := []string { "path:", "path:sub", "file:bb", "file:sub/cc" }
+
+    if len(relPaths) != len(expected) {
+        t.Fatalf("Exported entries not correct: %v", relPaths)
+    }
+
+    for i, relPath := range expected {
+        if relPaths[i] != relPath {
+            t.Fatalf("Exported entries not correct: %v", relPaths)
+        }
+    }
+
+    // The path is cleaned first, like it is for lookups.
+    b.Reset()
+
+    mw, err = NewManifestWriter(b, ManifestFormatJson)
+    if err != nil {
+        t.Fatalf("Could not create manifest writer.")
+    }
+
+    uncleanRelPath := "./dir1/"
+    err = cr.ExportManifest(&uncleanRelPath, mw)
+    if err != nil {
+        t.Fatalf("Could not export manifest with an unclean path.")
+    }
+
+    m2, err := ReadManifest(b)
+    if err != nil {
+        t.Fatalf("Could not read exported manifest.")
+    } else if m2.RootHash != m.RootHash || len(m2.Entries) != len(m.Entries) {
+        t.Fatalf("Manifest exported with an unclean path not correct.")
+    }
+
+    for i, me := range m2.Entries {
+        if me.Type + ":" + me.RelPath != relPaths[i] {
+            t.Fatalf("Manifest exported with an unclean path not correct.")
+        }
+    }
+}
@@ -0,0 +1,618 @@
+package pfinternal
+
+import (
+    "os"
+    "io"
+    "fmt"
+    "path"
+    "sort"
+    "bufio"
+    "errors"
+    "strconv"
+    "strings"
+
+    "io/ioutil"
+)
+
+const (
+    MtreeTypeFile = "file"
+    MtreeTypeDir = "dir"
+    MtreeTypeLink = "link"
+)
+
+// The digest keywords that we understand (both spellings are used in the
+// wild) and the algorithms that they're for.
+var mtreeDigestKeywords = map[string]string {
+    "sha1": Sha1Algorithm,
+    "sha1digest": Sha1Algorithm,
+    "sha256": Sha256Algorithm,
+    "sha256digest": Sha256Algorithm,
+}
+
+// A file, directory, or symlink in an mtree spec. Only the keywords that we
+// check are kept, and anything that the spec didn't have is nil/empty.
+type MtreeEntry struct {
+    RelPath string
+    Type string
+    Mode *uint32
+    Size *int64
+    Link *string
+
+    // By algorithm.
+    Digests map[string]string
+}
+
+type MtreeSpec struct {
+    Entries []*MtreeEntry
+}
+
+// Return the permission bits the way that chmod (and mtree) write them.
+func unixMode(mode os.FileMode) uint32 {
+    bits := uint32(mode.Perm())
+
+    if mode & os.ModeSetuid != 0 {
+        bits |= 04000
+    }
+
+    if mode & os.ModeSetgid != 0 {
+        bits |= 02000
+    }
+
+    if mode & os.ModeSticky != 0 {
+        bits |= 01000
+    }
+
+    return bits
+}
+
+// Escape a name the way mtree does (octal for whitespace, non-printable
+// characters, and the characters that mtree treats specially).
+func encodeMtreeName(name string) string {
+    encoded := make([]byte, 0, len(name))
+
+    for i := 0; i < len(name); i++ {
+        c := name[i]
+
+        if c <= ' ' || c >= 0x7f || strings.IndexByte("\\#*?[", c) != -1 {
+            encoded = append(encoded, []byte(fmt.Sprintf("\\%03o", c))...)
+        } else {
+            encoded = append(encoded, c)
+        }
+    }
+
+    return string(encoded)
+}
+
+func decodeMtreeName(encoded string) string {
+    decoded := make([]byte, 0, len(encoded))
+
+    for i := 0; i < len(encoded); i++ {
+        c := encoded[i]
+
+        if c != '\\' || i + 1 >= len(encoded) {
+            decoded = append(decoded, c)
+            continue
+        }
+
+        if i + 3 < len(encoded) && strings.Trim(encoded[i + 1:i + 4], "01234567") == "" {
+            value, _ := strconv.ParseUint(encoded[i + 1:i + 4], 8, 8)
+            decoded = append(decoded, byte(value))
+            i += 3
+
+            continue
+        }
+
+        i++
+
+        switch encoded[i] {
+        case 's':
+            decoded = append(decoded, ' ')
+
+        case 't':
+            decoded = append(decoded, '\t')
+
+        case 'n':
+            decoded = append(decoded, '\n')
+
+        case 'r':
+            decoded = append(decoded, '\r')
+
+        default:
+            decoded = append(decoded, encoded[i])
+        }
+    }
+
+    return string(decoded)
+}
+
+// Apply "keyword=value" pairs to the given keywords.
+func applyMtreeKeywords(keywords map[string]string, fields []string) {
+    for _, field := range fields {
+        parts := strings.SplitN(field, "=", 2)
+        if len(parts) == 1 {
+            keywords[parts[0]] = ""
+        } else {
+            keywords[parts[0]] = parts[1]
+        }
+    }
+}
+
+func newMtreeEntry(relPath string, keywords map[string]string) (me *MtreeEntry, err error) {
+    me = &MtreeEntry {
+            RelPath: relPath,
+            Type: MtreeTypeFile,
+            Digests: make(map[string]string),
+    }
+
+    for keyword, value := range keywords {
+        if algorithm, found := mtreeDigestKeywords[keyword]; found == true {
+            me.Digests[algorithm] = strings.ToLower(value)
+            continue
+        }
+
+        switch keyword {
+        case "type":
+            me.Type = value
+
+        case "mode":
+            mode, err := strconv.ParseUint(value, 8, 32)
+            if err != nil {
+                return nil, errors.New(fmt.Sprintf("Mode not valid: [%s] [%s]", relPath, value))
+            }
+
+            mode32 := uint32(mode)
+            me.Mode = &mode32
+
+        case "size":
+            size, err := strconv.ParseInt(value, 10, 64)
+            if err != nil {
+                return nil, errors.New(fmt.Sprintf("Size not valid: [%s] [%s]", relPath, value))
+            }
+
+            me.Size = &size
+
+        case "link":
+            link := decodeMtreeName(value)
+            me.Link = &link
+        }
+    }
+
+    return me, nil
+}
+
+// Read an mtree spec. Both the traditional format (names relative to the
+// directory above them, with ".." to go back up) and the format with full
+// paths are supported, as are "/set" and "/unset".
+func ReadMtree(r io.Reader) (spec *MtreeSpec, err error) {
+    l := NewLogger("mtree")
+
+    defer func() {
+        if r := recover(); r != nil {
+            spec = nil
+            err = r.(error)
+
+            l.Error("Could not read mtree spec", "err", err)
+        }
+    }()
+
+    spec = &MtreeSpec {
+            Entries: make([]*MtreeEntry, 0),
+    }
+
+    defaults := make(map[string]string)
+    cwd := make([]string, 0)
+
+    s := bufio.NewScanner(r)
+    lineNumber := 0
+    continued := ""
+
+    for s.Scan() {
+        lineNumber++
+
+        line := continued + strings.TrimSpace(s.Text())
+        continued = ""
+
+        if strings.HasSuffix(line, "\\") == true {
+            continued = line[:len(line) - 1] + " "
+            continue
+        } else if line == "" || line[0] == '#' {
+            continue
+        }
+
+        fields := strings.Fields(line)
+
+        if fields[0] == "/set" {
+            applyMtreeKeywords(defaults, fields[1:])
+            continue
+        } else if fields[0] == "/unset" {
+            for _, keyword := range fields[1:] {
+                if keyword == "all" {
+                    defaults = make(map[string]string)
+                } else {
+                    delete(defaults, keyword)
+                }
+            }
+
+            continue
+        } else if fields[0] == ".." {
+            if len(cwd) == 0 {
+                panic(errors.New(fmt.Sprintf("Spec line (%d) goes above the root.", lineNumber)))
+            }
+
+            cwd = cwd[:len(cwd) - 1]
+            continue
+        }
+
+        name := decodeMtreeName(fields[0])
+
+        keywords := make(map[string]string)
+        for keyword, value := range defaults {
+            keywords[keyword] = value
+        }
+
+        applyMtreeKeywords(keywords, fields[1:])
+
+        isFullPath := strings.Contains(name, "/")
+
+        var fullPath string
+        if isFullPath == true {
+            fullPath = name
+        } else {
+            fullPath = path.Join(append(append([]string {}, cwd...), name)...)
+        }
+
+        relPath, err := normalizeManifestRelPath(fullPath)
+        if err != nil {
+            panic(err)
+        }
+
+        me, err := newMtreeEntry(relPath, keywords)
+        if err != nil {
+            panic(err)
+        }
+
+        spec.Entries = append(spec.Entries, me)
+
+        // Only the traditional format descends into directories.
+        if isFullPath == false && me.Type == MtreeTypeDir {
+            cwd = append(cwd, name)
+        }
+    }
+
+    err = s.Err()
+    if err != nil {
+        panic(err)
+    }
+
+    return spec, nil
+}
+
+// Walk the tree and describe everything in it, in tree order. Files get
+// their sizes and hashes from the given catalog nodes rather than being
+// read.
+func collectMtreeEntries(scanPath string, relPath string, mode os.FileMode, files map[string]*CatalogTreeNode, algorithm string, entries []*MtreeEntry) []*MtreeEntry {
+    l := NewLogger("mtree")
+
+    dirMode := unixMode(mode)
+
+    me := &MtreeEntry {
+            RelPath: relPath,
+            Type: MtreeTypeDir,
+            Mode: &dirMode,
+            Digests: make(map[string]string),
+    }
+
+    entries = append(entries, me)
+
+    children, err := ioutil.ReadDir(path.Join(scanPath, relPath))
+    if err != nil {
+        panic(err)
+    }
+
+    for _, child := range children {
+        childRelPath := path.Join(relPath, child.Name())
+        childMode := child.Mode()
+
+        if childMode.IsDir() == true {
+            entries = collectMtreeEntries(scanPath, childRelPath, childMode, files, algorithm, entries)
+            continue
+        }
+
+        bits := unixMode(childMode)
+
+        me := &MtreeEntry {
+                RelPath: childRelPath,
+                Mode: &bits,
+                Digests: make(map[string]string),
+        }
+
+        if childMode.IsRegular() == true {
+            me.Type = MtreeTypeFile
+
+            node, found := files[childRelPath]
+            if found == false {
+                // It appeared after the scan.
+                panic(ErrFileChanged)
+            }
+
+            size := node.Size
+            me.Size = &size
+            me.Digests[algorithm] = node.Hash
+        } else if childMode & os.ModeSymlink != 0 {
+            me.Type = MtreeTypeLink
+
+            link, err := os.Readlink(path.Join(scanPath, childRelPath))
+            if err != nil {
+                panic(err)
+            }
+
+            me.Link = &link
+        } else {
+            l.Warn("Skipping file of unacceptable type.", "relPath", childRelPath, "mode", childMode)
+            continue
+        }
+
+        entries = append(entries, me)
+    }
+
+    return entries
+}
+
+// Copy the catalog to a temporary file, so that a tree can be scanned into the
+// copy without changing the catalog. If the catalog isn't given or doesn't
+// exist, the copy is empty. The caller removes the copy.
+func copyCatalogToTemp(catalogFilepath string) (tempFilepath string, err error) {
+    f, err := ioutil.TempFile("", "pfmtree_")
+    if err != nil {
+        return "", err
+    }
+
+    defer f.Close()
+
+    tempFilepath = f.Name()
+
+    if catalogFilepath == "" {
+        return tempFilepath, nil
+    }
+
+    source, err := os.Open(catalogFilepath)
+    if os.IsNotExist(err) == true {
+        return tempFilepath, nil
+    } else if err != nil {
+        os.Remove(tempFilepath)
+        return "", err
+    }
+
+    defer source.Close()
+
+    _, err = io.Copy(f, source)
+    if err != nil {
+        os.Remove(tempFilepath)
+        return "", err
+    }
+
+    return tempFilepath, nil
+}
+
+// Scan the tree into a copy of the catalog (so only the files that changed
+// since the catalog was last updated are read, and the catalog itself isn't
+// changed) and then describe it.
+func describeTreeForMtree(scanPath *string, catalogFilepath *string, hashAlgorithm *string) (entries []*MtreeEntry, err error) {
+    l := NewLogger("mtree")
+
+    defer func() {
+        if r := recover(); r != nil {
+            entries = nil
+            err = r.(error)
+
+            l.Error("Could not describe tree", "scanPath", *scanPath, "err", err)
+        }
+    }()
+
+    tempFilepath, err := copyCatalogToTemp(*catalogFilepath)
+    if err != nil {
+        panic(err)
+    }
+
+    defer os.Remove(tempFilepath)
+
+    cr, _, err := scanIntoCatalog(scanPath, &tempFilepath, hashAlgorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    defer cr.Close()
+
+    relPath := ""
+    root, err := cr.GetTree(&relPath, 0)
+    if err != nil {
+        panic(err)
+    }
+
+    files := make(map[string]*CatalogTreeNode)
+
+    var collect func(node *CatalogTreeNode)
+    collect = func(node *CatalogTreeNode) {
+        if node.IsPath == false {
+            files[node.RelPath] = node
+        }
+
+        for _, child := range node.Children {
+            collect(child)
+        }
+    }
+
+    collect(root)
+
+    s, err := os.Stat(*scanPath)
+    if err != nil {
+        panic(err)
+    }
+
+    entries = collectMtreeEntries(*scanPath, "", s.Mode(), files, *hashAlgorithm, make([]*MtreeEntry, 0))
+
+    return entries, nil
+}
+
+// Write an mtree spec (with full paths) for the tree. The tree is scanned into
+// a copy of the catalog first, so only the files that changed since the last
+// scan are read and the catalog isn't changed.
+func ExportMtree(scanPath *string, catalogFilepath *string, hashAlgorithm *string, w io.Writer) (err error) {
+    l := NewLogger("mtree")
+
+    defer func() {
+        if r := recover(); r != nil {
+            err = r.(error)
+            l.Error("Could not export mtree spec", "err", err)
+        }
+    }()
+
+    entries, err := describeTreeForMtree(scanPath, catalogFilepath, hashAlgorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    _, err = io.WriteString(w, "#mtree\n")
+    if err != nil {
+        panic(err)
+    }
+
+    for _, me := range entries {
+        name := "."
+        if me.RelPath != "" {
+            name = "./" + encodeMtreeName(me.RelPath)
+        }
+
+        line := fmt.Sprintf("%s type=%s mode=%04o", name, me.Type, *me.Mode)
+
+        if me.Size != nil {
+            line += fmt.Sprintf(" size=%d %sdigest=%s", *me.Size, *hashAlgorithm, me.Digests[*hashAlgorithm])
+        }
+
+        if me.Link != nil {
+            line += " link=" + encodeMtreeName(*me.Link)
+        }
+
+        _, err = io.WriteString(w, line + "\n")
+        if err != nil {
+            panic(err)
+        }
+    }
+
+    return nil
+}
+
+// Whether everything that the spec has for the entry matches what's there.
+func mtreeEntryMatches(expected *MtreeEntry, actual *MtreeEntry, algorithm string) bool {
+    if expected.Type != actual.Type {
+        return false
+    } else if expected.Mode != nil && *expected.Mode != *actual.Mode {
+        return false
+    } else if expected.Link != nil && (actual.Link == nil || *expected.Link != *actual.Link) {
+        return false
+    } else if actual.Type != MtreeTypeFile {
+        return true
+    } else if expected.Size != nil && *expected.Size != *actual.Size {
+        return false
+    } else if digest, found := expected.Digests[algorithm]; found == true && digest != actual.Digests[algorithm] {
+        return false
+    }
+
+    return true
+}
+
+// Check a tree against an mtree spec. The tree is scanned into a copy of the
+// given catalog (which can be empty or not exist) using the strongest
+// algorithm that the spec has digests for. An event is emitted for every
+// entry that's extra (create), missing (delete), or different (update);
+// directories are paths and everything else is a file. Returns the number of
+// events.
+func VerifyMtree(scanPath *string, catalogFilepath *string, spec *MtreeSpec, cs ChangeSink) (count int, err error) {
+    l := NewLogger("mtree")
+
+    defer func() {
+        if r := recover(); r != nil {
+            count = 0
+            err = r.(error)
+
+            l.Error("Could not verify mtree spec", "err", err)
+        }
+    }()
+
+    // Digests are only checked for one algorithm. If the spec doesn't have
+    // any, SHA256 is used for the catalog.
+    algorithm := Sha256Algorithm
+    for _, candidate := range []string { Sha256Algorithm, Sha1Algorithm } {
+        found := false
+        for _, me := range spec.Entries {
+            if _, found = me.Digests[candidate]; found == true {
+                break
+            }
+        }
+
+        if found == true {
+            algorithm = candidate
+            break
+        }
+    }
+
+    actualEntries, err := describeTreeForMtree(scanPath, catalogFilepath, &algorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    expected := make(map[string]*MtreeEntry)
+    actual := make(map[string]*MtreeEntry)
+    relPaths := make([]string, 0)
+
+    for _, me := range spec.Entries {
+        if _, found := expected[me.RelPath]; found == false {
+            relPaths = append(relPaths, me.RelPath)
+        }
+
+        expected[me.RelPath] = me
+    }
+
+    for _, me := range actualEntries {
+        actual[me.RelPath] = me
+
+        if _, found := expected[me.RelPath]; found == false {
+            relPaths = append(relPaths, me.RelPath)
+        }
+    }
+
+    sort.Sort(relPathsInTreeOrder(relPaths))
+
+    for _, relPath := range relPaths {
+        expectedEntry, isExpected := expected[relPath]
+        actualEntry, isActual := actual[relPath]
+
+        ce := &ChangeEvent {
+                EntityType: EntityTypeFile,
+                RelPath: relPath,
+        }
+
+        if isExpected == false {
+            ce.ChangeType = UpdateTypeCreate
+        } else if isActual == false {
+            ce.ChangeType = UpdateTypeDelete
+        } else if mtreeEntryMatches(expectedEntry, actualEntry, algorithm) == false {
+            ce.ChangeType = UpdateTypeUpdate
+        } else {
+            continue
+        }
+
+        if (isExpected == true && expectedEntry.Type == MtreeTypeDir) || (isActual == true && actualEntry.Type == MtreeTypeDir) {
+            ce.EntityType = EntityTypePath
+        }
+
+        err := cs.Event(ce)
+        if err != nil {
+            panic(err)
+        }
+
+        count++
+    }
+
+    return count, nil
+}
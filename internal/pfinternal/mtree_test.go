@@ -0,0 +1,190 @@
+package pfinternal
+
+import (
+    "testing"
+    "os"
+    "path"
+    "bytes"
+    "strings"
+
+    "io/ioutil"
+)
+
+func TestReadMtreeTraditional(t *testing.T) {
+    ConfigureRootLogger()
+
+    raw := "#\t   user: nobody\n" +
+           "/set type=file mode=0644\n" +
+           ".               type=dir mode=0755\n" +
+           "    aa          size=2 \\\n" +
+           "                sha1digest=E0C9035898DD52FC65C41454CEC9C4D2611BFB37\n" +
+           "    dir\\0401    type=dir\n" +
+           "        bb      size=0 mode=0600\n" +
+           "    # ./dir 1\n" +
+           "    ..\n" +
+           "    cc          type=link link=aa\n" +
+           "..\n"
+
+    spec, err := ReadMtree(strings.NewReader(raw))
+    if err != nil {
+        t.Fatalf("Could not read spec.")
+    }
+
+    described := make([]string, len(spec.Entries))
+    for i, me := range spec.Entries {
+        described[i] = me.Type + ":" + me.RelPath
+    }
+
+    expected := "dir:,file:aa,dir:dir 1,file:dir 1/bb,link:cc"
+    if strings.Join(described, ",") != expected {
+        t.Fatalf("Entries not correct: %v", described)
+    }
+
+    aa := spec.Entries[1]
+    if *aa.Mode != 0644 || *aa.Size != 2 || aa.Digests[Sha1Algorithm] != "e0c9035898dd52fc65c41454cec9c4d2611bfb37" {
+        t.Fatalf("File entry not correct.")
+    } else if *spec.Entries[3].Mode != 0600 {
+        t.Fatalf("Mode not overridden.")
+    } else if *spec.Entries[4].Link != "aa" {
+        t.Fatalf("Link not correct.")
+    }
+}
+
+func TestExportAndVerifyMtree(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    os.MkdirAll(path.Join(scanPath, "dir 1"), 0755)
+
+    ioutil.WriteFile(path.Join(scanPath, "aa"), []byte("aa"), 0644)
+    ioutil.WriteFile(path.Join(scanPath, "dir 1", "b#b"), []byte("bbb"), 0600)
+    os.Symlink("aa", path.Join(scanPath, "cc"))
+
+    hashAlgorithm := Sha256Algorithm
+    b := new(bytes.Buffer)
+
+    err := ExportMtree(&scanPath, &catalogFilepath, &hashAlgorithm, b)
+    if err != nil {
+        t.Fatalf("Could not export spec.")
+    }
+
+    if strings.Contains(b.String(), "./dir\\0401/b\\043b type=file mode=0600 size=3 sha256digest=") == false {
+        t.Fatalf("Spec not correct: [%s]", b.String())
+    } else if strings.Contains(b.String(), "./cc type=link mode=0777 link=aa\n") == false {
+        t.Fatalf("Spec not correct: [%s]", b.String())
+    }
+
+    spec, err := ReadMtree(b)
+    if err != nil {
+        t.Fatalf("Could not read spec.")
+    }
+
+    verify := func() string {
+        b := new(bytes.Buffer)
+        rcs := NewReportChangeSink(b, false)
+
+        _, err := VerifyMtree(&scanPath, &catalogFilepath, spec, rcs)
+        if err != nil {
+            t.Fatalf("Could not verify spec.")
+        }
+
+        err = rcs.End()
+        if err != nil {
+            t.Fatalf("Could not end report.")
+        }
+
+        return b.String()
+    }
+
+    if report := verify(); report != "" {
+        t.Fatalf("Tree doesn't match its own spec: [%s]", report)
+    }
+
+    os.Chmod(path.Join(scanPath, "dir 1"), 0700)
+    os.Remove(path.Join(scanPath, "cc"))
+    ioutil.WriteFile(path.Join(scanPath, "aa"), []byte("changed"), 0644)
+    ioutil.WriteFile(path.Join(scanPath, "zz"), []byte("zz"), 0644)
+
+    expected := "update file aa\ndelete file cc\nupdate path dir 1\ncreate file zz\n"
+    if report := verify(); report != expected {
+        t.Fatalf("Problems not correct: [%s]", report)
+    }
+}
+
+func TestExportMtreeLeavesCatalog(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    ioutil.WriteFile(path.Join(scanPath, "aa"), []byte("aa"), 0644)
+
+    hashAlgorithm := Sha256Algorithm
+
+    cr, _, err := scanIntoCatalog(&scanPath, &catalogFilepath, &hashAlgorithm)
+    if err != nil {
+        t.Fatalf("Could not scan.")
+    }
+
+    err = cr.SetHistoryEnabled(true)
+    if err != nil {
+        cr.Close()
+        t.Fatalf("Could not enable history.")
+    }
+
+    cr.Close()
+
+    ioutil.WriteFile(path.Join(scanPath, "bb"), []byte("bb"), 0644)
+
+    before, err := ioutil.ReadFile(catalogFilepath)
+    if err != nil {
+        t.Fatalf("Could not read catalog.")
+    }
+
+    b := new(bytes.Buffer)
+
+    err = ExportMtree(&scanPath, &catalogFilepath, &hashAlgorithm, b)
+    if err != nil {
+        t.Fatalf("Could not export spec.")
+    } else if strings.Contains(b.String(), "./bb type=file") == false {
+        t.Fatalf("New file not in spec: [%s]", b.String())
+    }
+
+    after, err := ioutil.ReadFile(catalogFilepath)
+    if err != nil {
+        t.Fatalf("Could not read catalog after export.")
+    } else if bytes.Equal(before, after) == false {
+        t.Fatalf("Export changed the catalog.")
+    }
+
+    // A catalog that doesn't exist isn't created.
+    missingFilepath := path.Join(scanPath, "missing.db")
+
+    err = ExportMtree(&scanPath, &missingFilepath, &hashAlgorithm, new(bytes.Buffer))
+    if err != nil {
+        t.Fatalf("Could not export spec without a catalog.")
+    }
+
+    _, err = os.Stat(missingFilepath)
+    if os.IsNotExist(err) == false {
+        t.Fatalf("Export created the catalog.")
+    }
+}
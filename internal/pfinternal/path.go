@@ -16,13 +16,13 @@ const (
 
 type Path struct {
     hashAlgorithm *string
-    reportingChannel chan<- *ChangeEvent
+    changeSink ChangeSink
 }
 
-func NewPath(hashAlgorithm *string, reportingChannel chan<- *ChangeEvent) *Path {
+func NewPath(hashAlgorithm *string, changeSink ChangeSink) *Path {
     p := Path {
             hashAlgorithm: hashAlgorithm,
-            reportingChannel: reportingChannel,
+            changeSink: changeSink,
     }
 
     return &p
@@ -80,6 +80,8 @@ func (self *Path) GeneratePathHash(scanPath *string, relPath *string, existingCa
         panic(err)
     }
 
+    symlinkCount := 0
+
     for _, entry := range entries {
         var childHash string = ""
 
@@ -110,11 +112,12 @@ func (self *Path) GeneratePathHash(scanPath *string, relPath *string, existingCa
             }
 
             mtime := s.ModTime().Unix()
+            size := s.Size()
 
             flr, err := existingCatalog.lookupFile(&filename)
             if err != nil {
                 panic(err)
-            } else if flr.wasFound == false || flr.entry.mtime != mtime {
+            } else if flr.wasFound == false || flr.entry.mtime != mtime || (flr.entry.size != UnknownFileSize && flr.entry.size != size) {
                 childHash, err = self.GenerateFileHash(&childPath)
                 if err != nil {
                     panic(err)
@@ -122,13 +125,28 @@ func (self *Path) GeneratePathHash(scanPath *string, relPath *string, existingCa
 
                 if flr.wasFound == false || childHash != flr.entry.hash {
 // TODO(dustin): !! How do we or should we emit update events for paths?
-                    err = existingCatalog.setFile(flr, mtime, &childHash)
+                    err = existingCatalog.setFile(flr, mtime, size, &childHash)
+                    if err != nil {
+                        panic(err)
+                    }
+                } else {
+                    // The content didn't change, but we don't want to have 
+                    // to hash it again next time.
+                    err = existingCatalog.setFileStat(flr, mtime, size)
                     if err != nil {
                         panic(err)
                     }
                 }
             } else {
                 childHash = flr.entry.hash
+
+                if flr.entry.size == UnknownFileSize {
+                    // The record is from before we recorded sizes.
+                    err = existingCatalog.setFileStat(flr, mtime, size)
+                    if err != nil {
+                        panic(err)
+                    }
+                }
             }
         } else if mode & os.ModeSymlink > 0 {
             l.Debug("Hashing symlink.", "relChildPath", relChildPath)
@@ -142,6 +160,8 @@ func (self *Path) GeneratePathHash(scanPath *string, relPath *string, existingCa
             if err != nil {
                 panic(err)
             }
+
+            symlinkCount++
         } else {
             l.Warn("Skipping file of unacceptable type.", 
                 "relChildPath", relChildPath, 
@@ -150,7 +170,9 @@ func (self *Path) GeneratePathHash(scanPath *string, relPath *string, existingCa
             continue
         }
 
-        io.WriteString(h, relChildPath)
+        // Only the name goes into the hash (not the relative path) so that 
+        // identical trees have identical hashes wherever they are.
+        io.WriteString(h, filename)
         io.WriteString(h, "\000")
         io.WriteString(h, childHash)
         io.WriteString(h, "\000")
@@ -162,7 +184,7 @@ func (self *Path) GeneratePathHash(scanPath *string, relPath *string, existingCa
         "hash", hash)
 
 // TODO(dustin): !! How do we or should we emit update events for paths?
-    lhp := existingCatalog.getLastHash()
+    lhp := existingCatalog.LastHash()
 
     if lhp == nil || *lhp != hash {
         err = existingCatalog.updatePath(&hash)
@@ -171,6 +193,11 @@ func (self *Path) GeneratePathHash(scanPath *string, relPath *string, existingCa
         }
     }
 
+    err = existingCatalog.setSymlinkCount(symlinkCount)
+    if err != nil {
+        panic(err)
+    }
+
     return hash, nil
 }
 
@@ -206,14 +233,16 @@ func (self *Path) GenerateFileHash(filepath *string) (hash string, err error) {
     part := make([]byte, h.BlockSize() * 2)
 
     for {
-        _, err := f.Read(part)
-        if err == io.EOF {
-            break
-        } else if err != nil {
+        n, err := f.Read(part)
+
+        // Only what was actually read (the last read is usually short).
+        if _, err := h.Write(part[:n]); err != nil {
             panic(err)
         }
 
-        if _, err := h.Write(part); err != nil {
+        if err == io.EOF {
+            break
+        } else if err != nil {
             panic(err)
         }
     }
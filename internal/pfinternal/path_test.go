@@ -3,8 +3,10 @@ package pfinternal
 import (
     "testing"
     "os"
+    "fmt"
     "path"
     "io/ioutil"
+    "crypto/sha1"
 )
 
 const (
@@ -93,3 +95,38 @@ func TestCalculateSimpleHash(t *testing.T) {
         t.Fatalf("Hash was not generated correctly: ACT [%s] != EXP [%s].", hash, expectedHash)
     }
 }
+
+func TestGenerateFileHash(t *testing.T) {
+    ConfigureRootLogger()
+
+    hashAlgorithm := HashAlgorithm
+
+    p := NewPath(&hashAlgorithm, nil)
+
+    tempPath := os.TempDir()
+    filepath := createTempFile(tempPath)
+
+    defer os.Remove(filepath)
+
+    // Longer than one read so that the last read is short.
+    data := make([]byte, 1000)
+    for i := range data {
+        data[i] = byte(i)
+    }
+
+    err := ioutil.WriteFile(filepath, data, 0644)
+    if err != nil {
+        panic(err)
+    }
+
+    hash, err := p.GenerateFileHash(&filepath)
+    if err != nil {
+        t.Fatalf("Could not generate hash.")
+    }
+
+    expectedHash := fmt.Sprintf("%x", sha1.Sum(data))
+
+    if hash != expectedHash {
+        t.Fatalf("File hash was not generated correctly: ACT [%s] != EXP [%s].", hash, expectedHash)
+    }
+}
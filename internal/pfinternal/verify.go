@@ -0,0 +1,122 @@
+package pfinternal
+
+import (
+    "sort"
+)
+
+// Collect the hashes of every path and file below the given node, by
+// relative path.
+func collectTreeHashes(node *CatalogTreeNode, paths map[string]string, files map[string]string) {
+    if node.IsPath == true {
+        paths[node.RelPath] = node.Hash
+    } else {
+        files[node.RelPath] = node.Hash
+    }
+
+    for _, child := range node.Children {
+        collectTreeHashes(child, paths, files)
+    }
+}
+
+// Emit an event for every entry that's only in one of the sets or that has
+// a different hash in each of them.
+func compareHashSets(entityType int, expected map[string]string, actual map[string]string, cs ChangeSink) (count int) {
+    relPaths := make([]string, 0)
+
+    for relPath, _ := range expected {
+        relPaths = append(relPaths, relPath)
+    }
+
+    for relPath, _ := range actual {
+        if _, found := expected[relPath]; found == false {
+            relPaths = append(relPaths, relPath)
+        }
+    }
+
+    sort.Sort(relPathsInTreeOrder(relPaths))
+
+    for _, relPath := range relPaths {
+        expectedHash, isExpected := expected[relPath]
+        actualHash, isActual := actual[relPath]
+
+        ce := &ChangeEvent {
+                EntityType: entityType,
+                RelPath: relPath,
+        }
+
+        if isExpected == false {
+            ce.ChangeType = UpdateTypeCreate
+        } else if isActual == false {
+            ce.ChangeType = UpdateTypeDelete
+        } else if expectedHash != actualHash {
+            ce.ChangeType = UpdateTypeUpdate
+        } else {
+            continue
+        }
+
+        err := cs.Event(ce)
+        if err != nil {
+            panic(err)
+        }
+
+        count++
+    }
+
+    return count
+}
+
+// Check a tree against a manifest. The tree is scanned into the given catalog
+// (which will be created if it doesn't exist) using the manifest's algorithm.
+// An event is emitted for every file that's extra (create), missing (delete),
+// or different (update). Paths are only checked if the manifest has them.
+// Returns the root hash of the tree and the number of events.
+func VerifyTree(scanPath *string, catalogFilepath *string, m *Manifest, cs ChangeSink) (rootHash string, count int, err error) {
+    l := NewLogger("verify")
+
+    defer func() {
+        if r := recover(); r != nil {
+            rootHash = ""
+            count = 0
+            err = r.(error)
+
+            l.Error("Could not verify tree", "err", err)
+        }
+    }()
+
+    cr, rootHash, err := scanIntoCatalog(scanPath, catalogFilepath, &m.Algorithm)
+    if err != nil {
+        panic(err)
+    }
+
+    defer cr.Close()
+
+    relPath := ""
+    root, err := cr.GetTree(&relPath, 0)
+    if err != nil {
+        panic(err)
+    }
+
+    actualPaths := make(map[string]string)
+    actualFiles := make(map[string]string)
+
+    collectTreeHashes(root, actualPaths, actualFiles)
+
+    expectedPaths := make(map[string]string)
+    expectedFiles := make(map[string]string)
+
+    for _, me := range m.Entries {
+        if me.Type == ManifestEntryTypePath {
+            expectedPaths[me.RelPath] = me.Hash
+        } else {
+            expectedFiles[me.RelPath] = me.Hash
+        }
+    }
+
+    count = compareHashSets(EntityTypeFile, expectedFiles, actualFiles, cs)
+
+    if len(expectedPaths) > 0 {
+        count += compareHashSets(EntityTypePath, expectedPaths, actualPaths, cs)
+    }
+
+    return rootHash, count, nil
+}
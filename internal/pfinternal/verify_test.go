@@ -0,0 +1,56 @@
+package pfinternal
+
+import (
+    "testing"
+    "os"
+    "path"
+    "bytes"
+    "strings"
+)
+
+func TestVerifyTree(t *testing.T) {
+    ConfigureRootLogger()
+
+    tempPath := os.TempDir()
+    catalogFilepath := createTempFile(tempPath)
+    scanPath := createTempPath(tempPath, "scan")
+
+    cleanup := func() {
+        os.RemoveAll(scanPath)
+        os.Remove(catalogFilepath)
+    }
+
+    defer cleanup()
+
+    dir1Path := path.Join(scanPath, "dir1")
+    os.Mkdir(dir1Path, 0755)
+
+    createSpecificFile(scanPath, "aa")
+    createSpecificFile(dir1Path, "bb")
+    createSpecificFile(dir1Path, "extra")
+
+    raw := "da39a3ee5e6b4b0d3255bfef95601890afd80709  ./aa\n" +
+           "0000000000000000000000000000000000000000  ./dir1/bb\n" +
+           "da39a3ee5e6b4b0d3255bfef95601890afd80709  ./dir1/missing\n"
+
+    m, err := ReadManifest(strings.NewReader(raw))
+    if err != nil {
+        t.Fatalf("Could not read manifest.")
+    }
+
+    b := new(bytes.Buffer)
+    rcs := NewReportChangeSink(b, false)
+
+    rootHash, count, err := VerifyTree(&scanPath, &catalogFilepath, m, rcs)
+    if err != nil {
+        t.Fatalf("Could not verify tree.")
+    } else if rootHash == "" {
+        t.Fatalf("Root hash not returned.")
+    }
+
+    expected := "update file dir1/bb\ncreate file dir1/extra\ndelete file dir1/missing\n"
+
+    if count != 3 || b.String() != expected {
+        t.Fatalf("Verification problems not correct:\n%s", b.String())
+    }
+}
@@ -0,0 +1,189 @@
+package pfinternal
+
+import (
+    "bytes"
+    "fmt"
+    "time"
+    "errors"
+
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+)
+
+const (
+    WebhookSignatureHeader = "X-Pathfingerprint-Signature"
+    WebhookDefaultRetryDelay = time.Second * 2
+)
+
+// A single change as it appears in the webhook body.
+type webhookChange struct {
+    EntityType string   `json:"entity_type"`
+    ChangeType string   `json:"change_type"`
+    RelPath string      `json:"rel_path"`
+}
+
+// The webhook body.
+type webhookPayload struct {
+    OldHash string              `json:"old_hash"`
+    NewHash string              `json:"new_hash"`
+    Changes []webhookChange     `json:"changes"`
+}
+
+// Collects the changes from a scan and POSTs them, along with the old and new
+// root hashes, as a single JSON document once the scan has finished. Nothing
+// is sent if nothing changed. If a secret is given, the body is signed with
+// HMAC-SHA256 and the signature is sent in the X-Pathfingerprint-Signature
+// header as "sha256=<hex digest>".
+type WebhookChangeSink struct {
+    url string
+    secret string
+    retries int
+    retryDelay time.Duration
+    client *http.Client
+
+    oldHash string
+    newHash string
+    changes []webhookChange
+}
+
+func NewWebhookChangeSink(url string, secret string, timeout time.Duration, retries int) *WebhookChangeSink {
+    client := &http.Client {
+            Timeout: timeout,
+    }
+
+    wcs := WebhookChangeSink {
+            url: url,
+            secret: secret,
+            retries: retries,
+            retryDelay: WebhookDefaultRetryDelay,
+            client: client,
+            changes: make([]webhookChange, 0),
+    }
+
+    return &wcs
+}
+
+// Set the root hashes to send. The old hash is empty if the root wasn't
+// previously recorded. This has to be called before End().
+func (self *WebhookChangeSink) SetRootHashes(oldHash string, newHash string) {
+    self.oldHash = oldHash
+    self.newHash = newHash
+}
+
+func (self *WebhookChangeSink) Begin() error {
+    self.changes = self.changes[:0]
+
+    return nil
+}
+
+func (self *WebhookChangeSink) Event(ce *ChangeEvent) error {
+    wc := webhookChange {
+            EntityType: EntityTypeName(ce.EntityType),
+            ChangeType: UpdateTypeName(ce.ChangeType),
+            RelPath: ce.RelPath,
+    }
+
+    self.changes = append(self.changes, wc)
+
+    return nil
+}
+
+func (self *WebhookChangeSink) End() (err error) {
+    l := NewLogger("webhook_change_sink")
+
+    defer func() {
+        if r := recover(); r != nil {
+            err = r.(error)
+            l.Error("Could not send webhook.", "err", err)
+        }
+    }()
+
+    if len(self.changes) == 0 && self.oldHash == self.newHash {
+        l.Debug("Nothing changed. Not sending webhook.")
+        return nil
+    }
+
+    wp := webhookPayload {
+            OldHash: self.oldHash,
+            NewHash: self.newHash,
+            Changes: self.changes,
+    }
+
+    body, err := json.Marshal(wp)
+    if err != nil {
+        panic(err)
+    }
+
+    var signature string
+    if self.secret != "" {
+        mac := hmac.New(sha256.New, []byte(self.secret))
+        mac.Write(body)
+
+        signature = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+    }
+
+    for attempt := 0; ; attempt++ {
+        retryable, err := self.post(body, signature)
+        if err == nil {
+            break
+        } else if retryable == false || attempt >= self.retries {
+            panic(err)
+        }
+
+        l.Warn("Webhook failed. Retrying.",
+            "url", self.url,
+            "attempt", attempt + 1,
+            "err", err)
+
+        time.Sleep(self.retryDelay)
+    }
+
+    return nil
+}
+
+func (self *WebhookChangeSink) Error(err error) {
+    // Don't tell anyone about a scan that didn't finish.
+    self.changes = self.changes[:0]
+}
+
+// Send the body once. Indicate whether a failure is worth retrying.
+func (self *WebhookChangeSink) post(body []byte, signature string) (retryable bool, err error) {
+    l := NewLogger("webhook_change_sink")
+
+    req, err := http.NewRequest("POST", self.url, bytes.NewReader(body))
+    if err != nil {
+        return false, err
+    }
+
+    req.Header.Set("Content-Type", "application/json")
+
+    if signature != "" {
+        req.Header.Set(WebhookSignatureHeader, signature)
+    }
+
+    res, err := self.client.Do(req)
+    if err != nil {
+        return true, err
+    }
+
+    defer res.Body.Close()
+
+    // Allow the connection to be reused.
+    ioutil.ReadAll(res.Body)
+
+    l.Debug("Webhook sent.",
+        "url", self.url,
+        "status", res.StatusCode)
+
+    if res.StatusCode >= 200 && res.StatusCode < 300 {
+        return false, nil
+    }
+
+    err = errors.New(fmt.Sprintf("Webhook returned status (%d)", res.StatusCode))
+
+    return res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests, err
+}
@@ -0,0 +1,123 @@
+package pfinternal
+
+import (
+    "testing"
+    "time"
+
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+)
+
+func TestWebhookChangeSink(t *testing.T) {
+    secret := "some secret"
+
+    attempts := 0
+    var received webhookPayload
+    var signature string
+    var expectedSignature string
+
+    s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        attempts++
+
+        // Fail the first time so that we can check the retries.
+        if attempts == 1 {
+            w.WriteHeader(http.StatusServiceUnavailable)
+            return
+        }
+
+        body, err := ioutil.ReadAll(r.Body)
+        if err != nil {
+            panic(err)
+        }
+
+        mac := hmac.New(sha256.New, []byte(secret))
+        mac.Write(body)
+        expectedSignature = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+        signature = r.Header.Get(WebhookSignatureHeader)
+
+        err = json.Unmarshal(body, &received)
+        if err != nil {
+            panic(err)
+        }
+    }))
+
+    defer s.Close()
+
+    wcs := NewWebhookChangeSink(s.URL, secret, time.Second * 5, 2)
+    wcs.retryDelay = 0
+
+    wcs.Begin()
+    wcs.Event(&ChangeEvent { EntityType: EntityTypeFile, ChangeType: UpdateTypeCreate, RelPath: "subdir1/aa" })
+    wcs.Event(&ChangeEvent { EntityType: EntityTypePath, ChangeType: UpdateTypeUpdate, RelPath: "subdir1" })
+    wcs.SetRootHashes("old", "new")
+
+    err := wcs.End()
+    if err != nil {
+        t.Fatalf("Webhook failed: %s", err)
+    }
+
+    if attempts != 2 {
+        t.Fatalf("Webhook not retried: (%d)", attempts)
+    }
+
+    if signature != expectedSignature {
+        t.Fatalf("Webhook signature not correct: [%s] != [%s]", signature, expectedSignature)
+    }
+
+    if received.OldHash != "old" || received.NewHash != "new" {
+        t.Fatalf("Webhook hashes not correct: %v", received)
+    }
+
+    if len(received.Changes) != 2 || received.Changes[0].RelPath != "subdir1/aa" || received.Changes[0].ChangeType != "create" || received.Changes[1].EntityType != "path" {
+        t.Fatalf("Webhook changes not correct: %v", received.Changes)
+    }
+
+    // Nothing changed, so nothing should be sent.
+
+    attempts = 0
+
+    wcs.Begin()
+    wcs.SetRootHashes("new", "new")
+
+    err = wcs.End()
+    if err != nil {
+        t.Fatalf("Webhook failed: %s", err)
+    }
+
+    if attempts != 0 {
+        t.Fatalf("Webhook sent even though nothing changed.")
+    }
+}
+
+func TestWebhookChangeSinkGivesUp(t *testing.T) {
+    attempts := 0
+
+    s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        attempts++
+        w.WriteHeader(http.StatusInternalServerError)
+    }))
+
+    defer s.Close()
+
+    wcs := NewWebhookChangeSink(s.URL, "", time.Second * 5, 2)
+    wcs.retryDelay = 0
+
+    wcs.Begin()
+    wcs.Event(&ChangeEvent { EntityType: EntityTypeFile, ChangeType: UpdateTypeDelete, RelPath: "aa" })
+    wcs.SetRootHashes("old", "new")
+
+    err := wcs.End()
+    if err == nil {
+        t.Fatalf("Expected the webhook to fail.")
+    }
+
+    if attempts != 3 {
+        t.Fatalf("Webhook not attempted the right number of times: (%d)", attempts)
+    }
+}